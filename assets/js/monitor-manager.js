@@ -150,9 +150,13 @@ document.addEventListener('DOMContentLoaded', function () {
             `;
         }).join('');
 
-        const photocellStatus = status['Photocell'] === true
+        let photocellStatus = status['Photocell'] === true
             ? '<span style="color: #333; font-weight: bold;">DARK</span> (Lights enabled)'
             : '<span style="color: orange; font-weight: bold;">LIGHT</span> (Lights disabled by daylight)';
+        if (status['PhotocellForced']) {
+            const until = new Date(status['PhotocellForcedUntil']).toLocaleString([], { hour: 'numeric', minute: '2-digit', month: 'short', day: 'numeric' });
+            photocellStatus += ` <span style="color: #b32d2e; font-weight: bold;">FORCED until ${until}</span>`;
+        }
 
         // Show current polling speed
         const isBursting = typeof burstEndTime !== 'undefined' && Date.now() < burstEndTime;
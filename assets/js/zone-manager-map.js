@@ -228,10 +228,12 @@ function renderMappingForm(formContainer, listContainer, addNewBtn, map = {}) {
                 <tr>
                     <th scope="row"><label for="map-plc-id">Controller</label></th>
                     <td>
-                        <select id="map-plc-id" name="plc_id">
-                            <option value="1" ${map.plc_id == 1 ? 'selected' : ''}>Controller #1 (Lodge)</option>
-                            <option value="2" ${map.plc_id == 2 ? 'selected' : ''}>Controller #2 (Pool House)</option>
-                        </select>
+                        <input type="number" id="map-plc-id" name="plc_id" min="1" max="127" class="small-text" value="${map.plc_id || 1}" list="map-plc-ids" required>
+                        <datalist id="map-plc-ids">
+                            <option value="1">Controller #1 (Lodge)</option>
+                            <option value="2">Controller #2 (Pool House)</option>
+                        </datalist>
+                        <p class="description">The PLC ID in the Go service config. 1 is the Lodge and 2 the Pool House; further controllers are added there.</p>
                     </td>
                 </tr>
                 <tr>
@@ -250,6 +252,20 @@ function renderMappingForm(formContainer, listContainer, addNewBtn, map = {}) {
                                value="${Array.isArray(map.relays) ? map.relays.join(',') : ''}" required>
                     </td>
                 </tr>
+                <tr>
+                    <th scope="row"><label for="map-lamp-wattage">Lamp Wattage (optional)</label></th>
+                    <td><input type="number" id="map-lamp-wattage" name="lamp_wattage" class="small-text" min="0" step="any"
+                               value="${map.lamp_wattage ? escapeHTML(String(map.lamp_wattage)) : ''}">
+                        <p class="description">Total watts for this circuit. Used for the kWh report.</p>
+                    </td>
+                </tr>
+                <tr>
+                    <th scope="row"><label for="map-rated-life">Rated Lamp Life, hours (optional)</label></th>
+                    <td><input type="number" id="map-rated-life" name="rated_life_hours" class="small-text" min="0" step="1"
+                               value="${map.rated_life_hours ? escapeHTML(String(map.rated_life_hours)) : ''}">
+                        <p class="description">Used for "replace soon" warnings.</p>
+                    </td>
+                </tr>
                 
                 ${mapEditorHTML}
                 
@@ -20,6 +20,12 @@ require_once plugin_dir_path( __FILE__ ) . 'includes/actions-schedules.php';
 require_once plugin_dir_path( __FILE__ ) . 'includes/actions-monitor.php';
 require_once plugin_dir_path( __FILE__ ) . 'includes/admin-settings.php';
 
+/**
+ * Schema version of the custom tables. Bump it whenever a CREATE TABLE below
+ * changes, so existing installs get the change on update without reactivating.
+ */
+define( 'FSBHOA_LIGHTING_DB_VERSION', '2' ); // 2: lamp_wattage, rated_life_hours
+
 /**
  * Create/update the custom database tables on plugin activation.
  */
@@ -46,6 +52,14 @@ function fsbhoa_lighting_activate() {
         );
         return; // Stop activation
     }
+    fsbhoa_lighting_install_tables();
+}
+register_activation_hook( __FILE__, 'fsbhoa_lighting_activate' );
+
+/**
+ * Create or update the custom database tables and record the schema version.
+ */
+function fsbhoa_lighting_install_tables() {
     global $wpdb;
     $charset_collate = $wpdb->get_charset_collate();
     require_once( ABSPATH . 'wp-admin/includes/upgrade.php' );
@@ -64,6 +78,8 @@ function fsbhoa_lighting_activate() {
         plc_outputs json NOT NULL,
         relays json NOT NULL,
         map_coordinates json DEFAULT NULL,
+        lamp_wattage decimal(8,1) DEFAULT NULL,
+        rated_life_hours int(10) unsigned DEFAULT NULL,
         PRIMARY KEY  (id)
     ) $charset_collate;";
     dbDelta( $sql_outputs );
@@ -87,8 +103,20 @@ function fsbhoa_lighting_activate() {
     $table_name_schedule_map = $wpdb->prefix . 'fsbhoa_lighting_zone_schedule_map';
     $sql_schedule_map = "CREATE TABLE $table_name_schedule_map ( zone_id mediumint(9) NOT NULL, schedule_id mediumint(9) NOT NULL, PRIMARY KEY  (zone_id, schedule_id) ) $charset_collate;";
     dbDelta( $sql_schedule_map );
+
+    update_option( 'fsbhoa_lighting_db_version', FSBHOA_LIGHTING_DB_VERSION );
 }
-register_activation_hook( __FILE__, 'fsbhoa_lighting_activate' );
+
+/**
+ * Plugin updates don't run the activation hook, so bring the tables up to
+ * date here when the stored schema version is older.
+ */
+function fsbhoa_lighting_check_db_version() {
+    if ( get_option( 'fsbhoa_lighting_db_version' ) !== FSBHOA_LIGHTING_DB_VERSION ) {
+        fsbhoa_lighting_install_tables();
+    }
+}
+add_action( 'plugins_loaded', 'fsbhoa_lighting_check_db_version' );
 
 /**
  * Enqueue the JavaScript files for our applications.
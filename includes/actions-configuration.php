@@ -193,7 +193,7 @@ function fsbhoa_lighting_get_mappings() {
     $table_name = $wpdb->prefix . 'fsbhoa_lighting_plc_outputs';
     
     // Get the raw results from the database
-    $mappings_raw = $wpdb->get_results( "SELECT id, plc_id, description, plc_outputs, relays, map_coordinates FROM $table_name ORDER BY plc_id, id ASC" );
+    $mappings_raw = $wpdb->get_results( "SELECT id, plc_id, description, plc_outputs, relays, map_coordinates, lamp_wattage, rated_life_hours FROM $table_name ORDER BY plc_id, id ASC" );
     
     if ($wpdb->last_error) {
         return new WP_REST_Response(['message' => 'DB error: ' . $wpdb->last_error], 500);
@@ -223,6 +223,9 @@ function fsbhoa_lighting_create_or_update_mapping( WP_REST_Request $request ) {
     $table_name = $wpdb->prefix . 'fsbhoa_lighting_plc_outputs';
     $params = $request->get_json_params();
     $mapping_id = isset( $params['mapping_id'] ) ? intval( $params['mapping_id'] ) : 0;
+    if ( intval( $params['plc_id'] ?? 0 ) < 1 ) {
+        return new WP_Error( 'rest_invalid_param', 'Controller must be a PLC ID of 1 or more.', ['status' => 400] );
+    }
     
     $plc_outputs_raw = explode(',', $params['plc_outputs']);
     $plc_outputs_sanitized = array_map(function($output) { return sanitize_text_field(trim($output)); }, $plc_outputs_raw);
@@ -234,7 +237,9 @@ function fsbhoa_lighting_create_or_update_mapping( WP_REST_Request $request ) {
         'description' => sanitize_text_field( $params['description'] ),
         'plc_outputs' => wp_json_encode( $plc_outputs_sanitized ),
         'relays'      => wp_json_encode( $relays_sanitized ),
-        'map_coordinates' => isset($params['map_coordinates']) ? $params['map_coordinates'] : null
+        'map_coordinates' => isset($params['map_coordinates']) ? $params['map_coordinates'] : null,
+        'lamp_wattage'     => ( isset($params['lamp_wattage']) && $params['lamp_wattage'] !== '' ) ? floatval( $params['lamp_wattage'] ) : null,
+        'rated_life_hours' => ( isset($params['rated_life_hours']) && $params['rated_life_hours'] !== '' ) ? absint( $params['rated_life_hours'] ) : null,
     ];
 
     // Ensure the data is valid JSON or null
@@ -347,9 +352,12 @@ function fsbhoa_lighting_get_full_config() {
             $config_data['mappings'][] = [
                 'id' => (int)$map['id'], // <-- FIX: Cast to int
                 'plc_id' => (int)$map['plc_id'], // <-- FIX: Cast to int
+                'description' => $map['description'],
                 'plc_outputs' => json_decode($map['plc_outputs']),
                 'relays' => json_decode($map['relays']),
-                'linked_zone_ids' => $links_by_output[(int)$map['id']] ?? []
+                'linked_zone_ids' => $links_by_output[(int)$map['id']] ?? [],
+                'lamp_wattage' => (float)($map['lamp_wattage'] ?? 0),
+                'rated_life_hours' => (int)($map['rated_life_hours'] ?? 0)
             ];
         }
     }
@@ -20,6 +20,13 @@ function fsbhoa_monitor_register_rest_routes() {
         'permission_callback' => function () { return current_user_can( 'manage_options' ); }
     ) );
 
+    // Endpoint to POST several overrides at once
+    register_rest_route( 'fsbhoa-lighting/v1', '/overrides', array(
+        'methods'  => 'POST',
+        'callback' => 'fsbhoa_lighting_send_bulk_override',
+        'permission_callback' => function () { return current_user_can( 'manage_options' ); }
+    ) );
+
     register_rest_route( 'fsbhoa-lighting/v1', '/test-mapping', array(
         'methods'  => 'POST',
         'callback' => 'fsbhoa_lighting_send_test_mapping',
@@ -42,16 +49,100 @@ function fsbhoa_monitor_register_rest_routes() {
 add_action( 'rest_api_init', 'fsbhoa_monitor_register_rest_routes' );
 
 /**
- * Fetches the real-time status from the Go service.
- * Acts as a secure proxy between the browser and the Go backend.
+ * Headers sent with every request to the Go service.
+ * The service records X-FSBHOA-User in its audit log of PLC commands, and
+ * checks the HMAC signature (shared API key, timestamp, one-time nonce).
+ * The signed string must match signRequest() in lighting-service/auth.go.
  */
-function fsbhoa_lighting_get_status_from_service() {
-    // Get the saved settings from the database
+function fsbhoa_lighting_service_headers( $method, $uri, $body = '' ) {
+    $user = wp_get_current_user();
+    $headers = array(
+        'X-FSBHOA-User' => ( $user && $user->exists() ) ? $user->user_login : 'system',
+    );
+
+    $options = get_option('fsbhoa_lighting_settings');
+    $key = isset($options['go_service_api_key']) ? $options['go_service_api_key'] : '';
+    if ( ! empty($key) ) {
+        $timestamp = (string) time();
+        $nonce = bin2hex( random_bytes(16) );
+        $signed = implode( "\n", array( $method, $uri, $timestamp, $nonce, hash('sha256', $body) ) );
+        $headers['X-FSBHOA-Timestamp'] = $timestamp;
+        $headers['X-FSBHOA-Nonce'] = $nonce;
+        $headers['X-FSBHOA-Signature'] = hash_hmac( 'sha256', $signed, $key );
+    }
+    return $headers;
+}
+
+/**
+ * Pulls the message and code out of a Go service error response,
+ * which looks like {"error": {"code": "ZONE_NOT_FOUND", "message": "..."}, "request_id": "..."}.
+ */
+function fsbhoa_lighting_service_error( $body ) {
+    $data = json_decode( $body, true );
+    if ( ! is_array($data) || empty($data['error']['message']) ) {
+        return array( 'message' => $body, 'code' => '' );
+    }
+    return array(
+        'message' => $data['error']['message'] . ( empty($data['request_id']) ? '' : ' (request ' . $data['request_id'] . ')' ),
+        'code'    => $data['error']['code'],
+    );
+}
+
+/**
+ * Full URL of a Go service route. Defaults to plain HTTP on 127.0.0.1, where
+ * the service listens unless BindAddress says otherwise; set the service URL
+ * on the settings page (e.g. https://...) if TLS is enabled.
+ */
+function fsbhoa_lighting_service_url( $path ) {
     $options = get_option('fsbhoa_lighting_settings');
+    if ( ! empty($options['go_service_url']) ) {
+        return untrailingslashit( $options['go_service_url'] ) . $path;
+    }
     // Get the port, or use 8085 as a default if not set
     $port = isset($options['go_service_port']) ? absint($options['go_service_port']) : 8085;
-    $service_url = sprintf('http://localhost:%d/status', $port);
-    $response = wp_remote_get( $service_url, array('timeout' => 10) );
+    return sprintf('http://127.0.0.1:%d%s', $port, $path);
+}
+
+/**
+ * Adds the TLS settings from the settings page to the args of a request to
+ * the Go service: a CA file to check its certificate against (e.g. a
+ * self-signed one), or no check at all.
+ */
+function fsbhoa_lighting_service_args( $args ) {
+    $options = get_option('fsbhoa_lighting_settings');
+    if ( ! empty($options['go_service_ca_file']) ) {
+        $args['sslcertificates'] = $options['go_service_ca_file'];
+    }
+    if ( ! empty($options['go_service_tls_insecure']) ) {
+        $args['sslverify'] = false;
+    }
+    return $args;
+}
+
+/**
+ * Sends the client certificate when the service requires one (TLSClientCAFile
+ * in its config). WP_Http has no argument for it, so it is set on the cURL
+ * handle of requests to the service URL only.
+ */
+function fsbhoa_lighting_service_client_cert( $handle, $parsed_args, $url ) {
+    $options = get_option('fsbhoa_lighting_settings');
+    if ( empty($options['go_service_client_cert']) || strpos( $url, fsbhoa_lighting_service_url('') ) !== 0 ) {
+        return;
+    }
+    curl_setopt( $handle, CURLOPT_SSLCERT, $options['go_service_client_cert'] );
+    if ( ! empty($options['go_service_client_key']) ) {
+        curl_setopt( $handle, CURLOPT_SSLKEY, $options['go_service_client_key'] );
+    }
+}
+add_action( 'http_api_curl', 'fsbhoa_lighting_service_client_cert', 10, 3 );
+
+/**
+ * Fetches the real-time status from the Go service.
+ * Acts as a secure proxy between the browser and the Go backend.
+ */
+function fsbhoa_lighting_get_status_from_service() {
+    $service_url = fsbhoa_lighting_service_url('/api/v1/status');
+    $response = wp_remote_get( $service_url, fsbhoa_lighting_service_args( array('timeout' => 10, 'headers' => fsbhoa_lighting_service_headers('GET', '/api/v1/status')) ) );
 
     if ( is_wp_error( $response ) ) {
         return new WP_REST_Response(
@@ -94,13 +185,10 @@ function fsbhoa_lighting_send_override_command( WP_REST_Request $request ) {
     }
 
     // Construct the URL for the Go service endpoint
-    // Get the saved settings from the database
-    $options = get_option('fsbhoa_lighting_settings');
-    // Get the port, or use 8085 as a default if not set
-    $port = isset($options['go_service_port']) ? absint($options['go_service_port']) : 8085;
-    $service_url = sprintf('http://localhost:%d/override/zone/%d/%s', $port, $zone_id, $state);
+    $path = sprintf('/api/v1/zones/%d/override/%s', $zone_id, $state);
+    $service_url = fsbhoa_lighting_service_url($path);
 
-    $response = wp_remote_post( $service_url, array('timeout' => 10) );
+    $response = wp_remote_post( $service_url, fsbhoa_lighting_service_args( array('timeout' => 10, 'headers' => fsbhoa_lighting_service_headers('POST', $path)) ) );
 
     if ( is_wp_error( $response ) ) {
         return new WP_REST_Response(
@@ -113,8 +201,9 @@ function fsbhoa_lighting_send_override_command( WP_REST_Request $request ) {
     $body = wp_remote_retrieve_body( $response );
 
     if ($http_code !== 200) {
+         $error = fsbhoa_lighting_service_error( $body );
          return new WP_REST_Response(
-            ['message' => 'Go service returned an error on override: ' . $body],
+            ['message' => 'Go service returned an error on override: ' . $error['message'], 'code' => $error['code']],
             $http_code
         );
     }
@@ -124,23 +213,78 @@ function fsbhoa_lighting_send_override_command( WP_REST_Request $request ) {
 
 
 /**
- * Triggers the Go service's /sync endpoint.
+ * Sends several zone/mapping overrides to the Go service in one request.
+ * Body: {"targets": [{"zone_id": 3, "state": "off"}, {"mapping_id": 12, "state": "on"}]}
+ * @param WP_REST_Request $request The incoming API request.
+ * @return WP_REST_Response The per-target results from the Go service.
+ */
+function fsbhoa_lighting_send_bulk_override( WP_REST_Request $request ) {
+    $params = $request->get_json_params();
+    $targets = array();
+    foreach ( (array) ($params['targets'] ?? array()) as $t ) {
+        $state = isset($t['state']) ? sanitize_key($t['state']) : '';
+        if ( $state !== 'on' && $state !== 'off' ) {
+            return new WP_REST_Response(['message' => 'Invalid parameters provided.'], 400);
+        }
+        if ( ! empty($t['zone_id']) ) {
+            $targets[] = array('zone_id' => intval($t['zone_id']), 'state' => $state);
+        } elseif ( ! empty($t['mapping_id']) ) {
+            $targets[] = array('mapping_id' => intval($t['mapping_id']), 'state' => $state);
+        } else {
+            return new WP_REST_Response(['message' => 'Invalid parameters provided.'], 400);
+        }
+    }
+    if ( empty($targets) ) {
+        return new WP_REST_Response(['message' => 'No targets given.'], 400);
+    }
+
+    $path = '/api/v1/overrides';
+    $body = wp_json_encode( array('targets' => $targets) );
+    $headers = fsbhoa_lighting_service_headers('POST', $path, $body);
+    $headers['Content-Type'] = 'application/json';
+    $response = wp_remote_post( fsbhoa_lighting_service_url($path), fsbhoa_lighting_service_args( array('timeout' => 15, 'headers' => $headers, 'body' => $body) ) );
+
+    if ( is_wp_error( $response ) ) {
+        return new WP_REST_Response(
+            ['message' => 'Failed to send overrides to Go service: ' . $response->get_error_message()],
+            503
+        );
+    }
+
+    $http_code = wp_remote_retrieve_response_code( $response );
+    $body = wp_remote_retrieve_body( $response );
+
+    if ($http_code !== 200) {
+         $error = fsbhoa_lighting_service_error( $body );
+         return new WP_REST_Response(
+            ['message' => 'Go service returned an error on override: ' . $error['message'], 'code' => $error['code']],
+            $http_code
+        );
+    }
+
+    return new WP_REST_Response( json_decode($body, true), 200 );
+}
+
+/**
+ * Triggers the Go service's /api/v1/sync endpoint.
  * This should be called after any configuration change.
  * It uses a non-blocking request so it doesn't slow down the WP admin.
+ * The service only writes what changed and keeps manual overrides unless a
+ * schedule in use changed; $full rewrites everything and sets C151, which
+ * clears every override.
  */
-function fsbhoa_lighting_trigger_go_service_sync() {
-    // Get the saved settings to find the port
-    $options = get_option('fsbhoa_lighting_settings');
-    $port = isset($options['go_service_port']) ? absint($options['go_service_port']) : 8085;
-    $service_url = sprintf('http://localhost:%d/sync', $port);
+function fsbhoa_lighting_trigger_go_service_sync( $full = false ) {
+    $path = $full ? '/api/v1/sync?full=true' : '/api/v1/sync';
+    $service_url = fsbhoa_lighting_service_url($path);
 
     // Use wp_remote_post for a non-blocking (fire-and-forget) request.
     // We set 'blocking' to false and 'timeout' to a very low value.
-    wp_remote_post( $service_url, array(
+    wp_remote_post( $service_url, fsbhoa_lighting_service_args( array(
         'method'    => 'POST',
         'timeout'   => 1, // Don't wait more than 1 second
         'blocking'  => false, // Return immediately, don't wait for the response
-    ) );
+        'headers'   => fsbhoa_lighting_service_headers('POST', $path),
+    ) ) );
 }
 
 
@@ -149,15 +293,18 @@ function fsbhoa_lighting_send_test_mapping( WP_REST_Request $request ) {
     $mapping_id = intval($params['mapping_id']);
     $state = sanitize_key($params['state']);
 
-    $options = get_option('fsbhoa_lighting_settings');
-    $port = isset($options['go_service_port']) ? absint($options['go_service_port']) : 8085;
-    $service_url = sprintf('http://localhost:%d/test/mapping/%d/%s', $port, $mapping_id, $state);
+    $path = sprintf('/api/v1/mappings/%d/test/%s', $mapping_id, $state);
+    $service_url = fsbhoa_lighting_service_url($path);
 
-    $response = wp_remote_post( $service_url, array('timeout' => 5) );
+    $response = wp_remote_post( $service_url, fsbhoa_lighting_service_args( array('timeout' => 5, 'headers' => fsbhoa_lighting_service_headers('POST', $path)) ) );
 
-    if ( is_wp_error( $response ) || wp_remote_retrieve_response_code( $response ) !== 200 ) {
+    if ( is_wp_error( $response ) ) {
         return new WP_REST_Response(['message' => 'Test command failed.'], 500);
     }
+    if ( wp_remote_retrieve_response_code( $response ) !== 200 ) {
+        $error = fsbhoa_lighting_service_error( wp_remote_retrieve_body( $response ) );
+        return new WP_REST_Response(['message' => 'Test command failed: ' . $error['message'], 'code' => $error['code']], 500);
+    }
     return new WP_REST_Response( ['message' => 'Test command sent.'], 200 );
 }
 
@@ -165,7 +312,7 @@ function fsbhoa_lighting_send_test_mapping( WP_REST_Request $request ) {
  * Manually triggers the Go service sync via REST API.
  */
 function fsbhoa_lighting_manual_sync() {
-    fsbhoa_lighting_trigger_go_service_sync();
+    fsbhoa_lighting_trigger_go_service_sync( true ); // The button is there to clear overrides
     return new WP_REST_Response( ['message' => 'Sync command sent.'], 200 );
 }
 
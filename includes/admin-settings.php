@@ -62,6 +62,11 @@ class Fsbhoa_Lighting_Admin_Settings {
 
         // --- Fields ---
         add_settings_field('go_service_port', 'Go Service Listen Port', array($this, 'render_field'), $this->page_slug, 'fsbhoa_lighting_section_service', ['id' => 'go_service_port', 'type' => 'number', 'default' => 8085, 'desc' => 'Port for the Go service HTTP server.']);
+        add_settings_field('go_service_url', 'Go Service URL', array($this, 'render_field'), $this->page_slug, 'fsbhoa_lighting_section_service', ['id' => 'go_service_url', 'type' => 'url', 'placeholder' => 'http://127.0.0.1:8085', 'desc' => 'Leave blank to use http://127.0.0.1 and the port above. Use https:// if TLS is enabled in the service config.']);
+        add_settings_field('go_service_ca_file', 'Go Service CA File', array($this, 'render_field'), $this->page_slug, 'fsbhoa_lighting_section_service', ['id' => 'go_service_ca_file', 'placeholder' => '/etc/fsbhoa/lighting-ca.pem', 'desc' => 'With https: PEM file of the CA (or the self-signed certificate) the service certificate is checked against. Blank uses the system CAs.']);
+        add_settings_field('go_service_client_cert', 'Go Service Client Certificate', array($this, 'render_field'), $this->page_slug, 'fsbhoa_lighting_section_service', ['id' => 'go_service_client_cert', 'placeholder' => '/etc/fsbhoa/wordpress.pem', 'desc' => 'PEM certificate WordPress presents when the service requires client certificates (TLSClientCAFile).']);
+        add_settings_field('go_service_client_key', 'Go Service Client Key', array($this, 'render_field'), $this->page_slug, 'fsbhoa_lighting_section_service', ['id' => 'go_service_client_key', 'placeholder' => '/etc/fsbhoa/wordpress-key.pem', 'desc' => 'Private key of the client certificate, if it is not in the same file. It must be readable by the web server user.']);
+        add_settings_field('go_service_tls_insecure', 'Skip Certificate Check', array($this, 'render_field'), $this->page_slug, 'fsbhoa_lighting_section_service', ['id' => 'go_service_tls_insecure', 'type' => 'checkbox', 'desc' => 'Do not verify the service certificate. For testing only; set a CA file instead.']);
         add_settings_field(
 		'log_file_path', 
 		'Go Service Log File Path', 
@@ -110,6 +115,19 @@ class Fsbhoa_Lighting_Admin_Settings {
         $value   = isset($options[$id]) ? $options[$id] : $default;
         $placeholder = $args['placeholder'] ?? '';
 
+        if ($type === 'checkbox') {
+            printf(
+                '<input type="checkbox" name="%s[%s]" value="1" %s>',
+                esc_attr($this->option_name),
+                esc_attr($id),
+                checked(!empty($value), true, false)
+            );
+            if ($desc) {
+                echo '<p class="description">' . esc_html($desc) . '</p>';
+            }
+            return;
+        }
+
         printf(
             '<input type="%s" name="%s[%s]" value="%s" class="%s" placeholder="%s">',
             esc_attr($type),
@@ -160,11 +178,16 @@ class Fsbhoa_Lighting_Admin_Settings {
         $output = get_option($this->option_name, []);
         // Sanitize each field appropriately
         $output['go_service_port'] = isset( $input['go_service_port'] ) ? absint( $input['go_service_port'] ) : 8085;
+        $output['go_service_url'] = isset( $input['go_service_url'] ) ? esc_url_raw( $input['go_service_url'] ) : '';
         $output['log_file_path'] = isset( $input['log_file_path'] ) ? sanitize_text_field( $input['log_file_path'] ) : $this->default_log_path;
         $output['plc1_address'] = isset( $input['plc1_address'] ) ? sanitize_text_field( $input['plc1_address'] ) : '';
         $output['plc2_address'] = isset( $input['plc2_address'] ) ? sanitize_text_field( $input['plc2_address'] ) : '';
         $output['go_service_api_key'] = isset( $input['go_service_api_key'] ) ? sanitize_text_field( $input['go_service_api_key'] ) : ($output['go_service_api_key'] ?? '');
         $output['map_image_url'] = isset( $input['map_image_url'] ) ? esc_url_raw( $input['map_image_url'] ) : '';
+        foreach (['go_service_ca_file', 'go_service_client_cert', 'go_service_client_key'] as $path_field) {
+            $output[$path_field] = isset( $input[$path_field] ) ? sanitize_text_field( $input[$path_field] ) : '';
+        }
+        $output['go_service_tls_insecure'] = !empty( $input['go_service_tls_insecure'] );
 
         return $output;
     }
@@ -177,15 +200,36 @@ class Fsbhoa_Lighting_Admin_Settings {
         $config = [
             'ListenPort' => ':' . ($options['go_service_port'] ?? 8085), // Go expects ":port" format
             'LogFilePath' => $options['log_file_path'] ?? $this->default_log_path,
-            'PLCs'       => [
-                // Store PLC addresses directly in the format Go expects (map[int]string)
-                1 => $options['plc1_address'] ?? '',
-                2 => $options['plc2_address'] ?? '',
-            ],
             'WordPressAPIKey' => $options['go_service_api_key'] ?? '',
             'WordPressAPIBaseURL' => site_url(),
         ];
 
+        // Keep settings that are only set by hand in the file (TLS, auth, logging, ...).
+        $existing = [];
+        if (is_readable($this->config_file_path)) {
+            $existing = json_decode(file_get_contents($this->config_file_path), true);
+            if (!is_array($existing)) {
+                $existing = [];
+            }
+        }
+
+        // Only the addresses of PLCs 1 and 2 are set here. A PLC can also be an
+        // object with a name, roles and capabilities, and more PLCs can be added,
+        // by hand in the file; keep those.
+        $plcs = (isset($existing['PLCs']) && is_array($existing['PLCs'])) ? $existing['PLCs'] : [];
+        foreach ([1 => 'plc1_address', 2 => 'plc2_address'] as $id => $option) {
+            $address = $options[$option] ?? '';
+            if (isset($plcs[$id]) && is_array($plcs[$id])) {
+                $plcs[$id]['Address'] = $address;
+            } else {
+                $plcs[$id] = $address;
+            }
+        }
+        ksort($plcs);
+        $config['PLCs'] = (object) $plcs; // Always a JSON object keyed by PLC ID
+
+        $config = array_merge($existing, $config);
+
         $json_data = json_encode($config, JSON_PRETTY_PRINT | JSON_UNESCAPED_SLASHES);
         $config_dir = dirname($this->config_file_path);
         if (!is_dir($config_dir)) {
@@ -218,9 +262,10 @@ class Fsbhoa_Lighting_Admin_Settings {
         update_option($this->option_name, $updated_options);
 
         $this->write_go_service_config(); // Write the JSON file after saving options
-        // We do NOT trigger a sync here, as this requires a service restart.
+        // The Go service watches this file and reloads it on its own (see /health for the result).
+        // Changing the listen port or log file still needs a restart.
 
-        wp_send_json_success('Settings saved. Config file updated.');
+        wp_send_json_success('Settings saved. The lighting service will reload them automatically.');
     }
 
 
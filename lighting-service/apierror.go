@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Error codes returned in the "code" field of every error response.
+const (
+	codeBadRequest          = "BAD_REQUEST"
+	codeInvalidID           = "INVALID_ID"
+	codeInvalidState        = "INVALID_STATE"
+	codeZoneNotFound        = "ZONE_NOT_FOUND"
+	codeMappingNotFound     = "MAPPING_NOT_FOUND"
+	codeZoneHasNoLights     = "ZONE_HAS_NO_LIGHTS"
+	codeMappingHasNoOutputs = "MAPPING_HAS_NO_OUTPUTS"
+	codePLCNotConfigured    = "PLC_NOT_CONFIGURED" // A mapping's PLC has no address in the service config
+	codeInvalidOutput       = "INVALID_OUTPUT"
+	codePLCUnreachable      = "PLC_UNREACHABLE"
+	codeConfigUnavailable   = "CONFIG_UNAVAILABLE" // Could not fetch the config from WordPress
+	codeThrottled           = "THROTTLED"
+	codeShuttingDown        = "SHUTTING_DOWN"
+	codeUnauthorized        = "UNAUTHORIZED"
+	codeForbidden           = "FORBIDDEN"
+	codeNotFound            = "NOT_FOUND"
+	codeMethodNotAllowed    = "METHOD_NOT_ALLOWED"
+	codeInternal            = "INTERNAL"
+)
+
+// APIError is an error with the HTTP status and code to report it with.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error // Underlying cause, if any
+}
+
+func (e *APIError) Error() string { return e.Message }
+func (e *APIError) Unwrap() error { return e.Err }
+
+func apiError(status int, code, format string, args ...any) *APIError {
+	return &APIError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// configUnavailable wraps a FetchConfigurationFromAPI failure.
+func configUnavailable(err error) *APIError {
+	return &APIError{Status: http.StatusBadGateway, Code: codeConfigUnavailable, Message: "could not fetch the lighting config from WordPress: " + err.Error(), Err: err}
+}
+
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Error     errorDetail `json:"error"`
+	RequestID string      `json:"request_id"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// toAPIError classifies an error from the PLC, pulse or config code.
+func toAPIError(err error) *APIError {
+	var ae *APIError
+	var throttled *ThrottledError
+	var plcErr *PLCError
+	switch {
+	case errors.As(err, &ae):
+		return ae
+	case errors.As(err, &throttled):
+		return &APIError{Status: http.StatusTooManyRequests, Code: codeThrottled, Message: err.Error(), Err: err}
+	case errors.Is(err, errShuttingDown), errors.Is(err, context.Canceled):
+		return &APIError{Status: http.StatusServiceUnavailable, Code: codeShuttingDown, Message: err.Error(), Err: err}
+	case errors.Is(err, errZoneHasNoLights):
+		return &APIError{Status: http.StatusConflict, Code: codeZoneHasNoLights, Message: err.Error(), Err: err}
+	case errors.As(err, &plcErr):
+		return &APIError{Status: http.StatusBadGateway, Code: codePLCUnreachable, Message: err.Error(), Err: err}
+	default:
+		return &APIError{Status: http.StatusInternalServerError, Code: codeInternal, Message: err.Error(), Err: err}
+	}
+}
+
+// writeError sends err as a JSON error response and logs it with the request ID.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	ae := reportError(w, r, err)
+	writeJSON(w, ae.Status, errorResponse{
+		Error:     errorDetail{Code: ae.Code, Message: ae.Message},
+		RequestID: requestID(r.Context()),
+	})
+}
+
+// writeCommandError sends a failed command's response with err in its
+// "error" field, the same place as in errorResponse.
+func writeCommandError(w http.ResponseWriter, r *http.Request, err error, resp commandResponse) {
+	ae := reportError(w, r, err)
+	resp.Error = &errorDetail{Code: ae.Code, Message: ae.Message}
+	resp.RequestID = requestID(r.Context())
+	writeJSON(w, ae.Status, resp)
+}
+
+// reportError classifies err, sets the headers that go with it and logs it.
+func reportError(w http.ResponseWriter, r *http.Request, err error) *APIError {
+	ae := toAPIError(err)
+	var throttled *ThrottledError
+	if errors.As(err, &throttled) && throttled.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())))
+	}
+	if ae.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `FSBHOA-HMAC realm="lighting-service"`)
+	}
+
+	level := slog.LevelWarn
+	if ae.Status >= 500 {
+		level = slog.LevelError
+	}
+	httpLog.Log(r.Context(), level, "request failed", "method", r.Method, "path", r.URL.Path, "status", ae.Status, "code", ae.Code, "err", ae.Message)
+	return ae
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// commandResponse is returned by routes that send commands to the PLCs.
+type commandResponse struct {
+	OK        bool            `json:"ok"`
+	Simulated bool            `json:"simulated,omitempty"`
+	Writes    []string        `json:"writes"`             // Coils/registers written, e.g. "PLC1:C203"
+	Warnings  []string        `json:"warnings,omitempty"` // Things to fix that didn't stop the command
+	Sync      []PLCPushResult `json:"sync,omitempty"`     // What a sync did on each PLC
+	Error     *errorDetail    `json:"error,omitempty"`    // Set when a sync fails; Sync still says where each PLC was left
+	RequestID string          `json:"request_id"`
+}
+
+func (app *App) writeCommandOK(w http.ResponseWriter, r *http.Request, writes []string) {
+	writeJSON(w, http.StatusOK, commandResponse{
+		OK:        true,
+		Simulated: app.isSimulationMode(),
+		Writes:    append([]string{}, writes...),
+		RequestID: requestID(r.Context()),
+	})
+}
+
+// --- Path parameters ---
+
+var digitsOnly = regexp.MustCompile(`^[0-9]{1,9}$`)
+
+// parseID reads a positive numeric path parameter. "abc", "0", "-1" and "+3" are rejected.
+func parseID(ps httprouter.Params, name string) (int, error) {
+	s := ps.ByName(name)
+	if !digitsOnly.MatchString(s) {
+		return 0, apiError(http.StatusBadRequest, codeInvalidID, "%s %q must be a positive number", name, s)
+	}
+	id, _ := strconv.Atoi(s)
+	if id < 1 {
+		return 0, apiError(http.StatusBadRequest, codeInvalidID, "%s %q must be a positive number", name, s)
+	}
+	return id, nil
+}
+
+// parseState reads the :state path parameter, which must be exactly "on" or "off".
+func parseState(ps httprouter.Params) (string, error) {
+	s := ps.ByName("state")
+	if s != "on" && s != "off" {
+		return "", apiError(http.StatusBadRequest, codeInvalidState, "state %q must be \"on\" or \"off\"", s)
+	}
+	return s, nil
+}
+
+// queryInt reads an optional non-negative number from the query string (0 if absent).
+func queryInt(v url.Values, name string) (int, error) {
+	s := v.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	if !digitsOnly.MatchString(s) {
+		return 0, apiError(http.StatusBadRequest, codeBadRequest, "%s %q must be a number", name, s)
+	}
+	n, _ := strconv.Atoi(s)
+	return n, nil
+}
+
+// queryBool reads an optional true/false query parameter.
+func queryBool(v url.Values, name string) (bool, error) {
+	s := v.Get(name)
+	if s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, apiError(http.StatusBadRequest, codeBadRequest, "%s %q must be true or false", name, s)
+	}
+	return b, nil
+}
+
+// --- Request IDs ---
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// validRequestID limits IDs passed in by callers to something safe to log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestID gives every request an ID, reusing the caller's X-Request-ID
+// if it sent a sane one. The ID is echoed in the response header and error
+// bodies, added to log lines written with the request's context, and stored
+// in audit entries.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// installErrorHandlers makes the router's own 404/405/panic responses use the JSON model.
+func installErrorHandlers(router *httprouter.Router) {
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, apiError(http.StatusNotFound, codeNotFound, "no route for %s", r.URL.Path))
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, apiError(http.StatusMethodNotAllowed, codeMethodNotAllowed, "%s is not allowed on %s", r.Method, r.URL.Path))
+	})
+	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, v any) {
+		writeError(w, r, apiError(http.StatusInternalServerError, codeInternal, "internal error: %v", v))
+	}
+}
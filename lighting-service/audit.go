@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// callerHeader is set by WordPress to the login of the user who clicked the button.
+const callerHeader = "X-FSBHOA-User"
+
+// AuditEntry records one command the service sent (or tried to send) to the PLCs.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Caller    string    `json:"caller"`
+	Remote    string    `json:"remote_addr"`
+	Action    string    `json:"action"` // "override", "test", "sync", "photocell", "time" or "repair"
+	ZoneID    int       `json:"zone_id,omitempty"`
+	MappingID int       `json:"mapping_id,omitempty"`
+	State     string    `json:"state,omitempty"`
+	Writes    []string  `json:"writes,omitempty"` // e.g. "PLC1:C203", "PLC2:DS1000-DS1023"
+	Outcome   string    `json:"outcome"`          // "ok", "error" or "simulated"
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"` // Matches the X-Request-ID header and log lines
+}
+
+// AuditLog is an append-only JSON-lines file of AuditEntry records.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Append writes one entry to the end of the log. Failures are logged, never returned,
+// so a full disk can't stop someone from turning the lights off.
+func (a *AuditLog) Append(entry AuditEntry) {
+	if a == nil || a.path == "" {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		auditLog.Error("could not encode audit entry", "err", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		auditLog.Error("could not open audit log", "path", a.path, "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		auditLog.Error("could not write audit log", "path", a.path, "err", err)
+	}
+}
+
+// AuditQuery filters entries returned by Query. Zero values match everything.
+type AuditQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Caller    string
+	Action    string
+	ZoneID    int
+	MappingID int
+	Limit     int // Most recent N matches
+}
+
+func (q AuditQuery) matches(e AuditEntry) bool {
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if q.Caller != "" && !strings.EqualFold(q.Caller, e.Caller) {
+		return false
+	}
+	if q.Action != "" && q.Action != e.Action {
+		return false
+	}
+	if q.ZoneID != 0 && q.ZoneID != e.ZoneID {
+		return false
+	}
+	if q.MappingID != 0 && q.MappingID != e.MappingID {
+		return false
+	}
+	return true
+}
+
+// Query scans the log file and returns matching entries, oldest first.
+func (a *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	if a == nil || a.path == "" {
+		return entries, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // Skip a torn last line rather than failing the whole query
+		}
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read audit log: %w", err)
+	}
+
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+// writeAuditCSV renders entries as a spreadsheet-friendly CSV. The caller
+// comes from a request header and the error text from wherever the error
+// started, so text cells are passed through csvText.
+func writeAuditCSV(w http.ResponseWriter, entries []AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="lighting_audit.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "caller", "remote_addr", "action", "zone_id", "mapping_id", "state", "writes", "outcome", "error", "request_id"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Time.Format(time.RFC3339),
+			csvText(e.Caller),
+			csvText(e.Remote),
+			csvText(e.Action),
+			strconv.Itoa(e.ZoneID),
+			strconv.Itoa(e.MappingID),
+			csvText(e.State),
+			csvText(strings.Join(e.Writes, " ")),
+			csvText(e.Outcome),
+			csvText(e.Error),
+			csvText(e.RequestID),
+		})
+	}
+	cw.Flush()
+}
+
+// csvText quotes a cell that a spreadsheet would otherwise run as a formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// callerFromRequest returns the WordPress user that sent the request, or
+// "token:<name>" for a named API token.
+func callerFromRequest(r *http.Request) string {
+	// A named token can't claim to be a WordPress user.
+	if p, ok := requestPrincipal(r); ok && p.Method == "token" {
+		return "token:" + p.Name
+	}
+	caller := strings.TrimSpace(r.Header.Get(callerHeader))
+	if caller == "" {
+		return "anonymous"
+	}
+	return caller
+}
+
+// newAuditEntry starts an entry with the caller identity taken from the request.
+func newAuditEntry(r *http.Request, action string) AuditEntry {
+	return AuditEntry{
+		Time:   time.Now(),
+		Caller: callerFromRequest(r),
+		Remote: r.RemoteAddr,
+		Action: action,
+
+		RequestID: requestID(r.Context()),
+	}
+}
+
+// finishAudit fills in the outcome and appends the entry.
+func (app *App) finishAudit(entry AuditEntry, writes []string, err error) {
+	entry.Writes = writes
+	switch {
+	case err != nil:
+		entry.Outcome = "error"
+		entry.Error = err.Error()
+	case app.isSimulationMode():
+		entry.Outcome = "simulated"
+	default:
+		entry.Outcome = "ok"
+	}
+	app.Audit.Append(entry)
+}
+
+// handleAuditQuery returns audit entries as JSON, or CSV with ?format=csv.
+// Filters: since, until (RFC3339), caller, action, zone, mapping, limit.
+func (app *App) handleAuditQuery(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	v := r.URL.Query()
+	var q AuditQuery
+	var err error
+	if s := v.Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "invalid 'since' %q (want RFC3339)", s))
+			return
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "invalid 'until' %q (want RFC3339)", s))
+			return
+		}
+	}
+	q.Caller = v.Get("caller")
+	q.Action = v.Get("action")
+	for name, dst := range map[string]*int{"zone": &q.ZoneID, "mapping": &q.MappingID, "limit": &q.Limit} {
+		if *dst, err = queryInt(v, name); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	entries, err := app.Audit.Query(q)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("could not read audit log: %w", err))
+		return
+	}
+
+	if v.Get("format") == "csv" {
+		writeAuditCSV(w, entries)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWriteAuditCSV(t *testing.T) {
+	entries := []AuditEntry{
+		{Time: time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC), Caller: "jsmith", Action: "zone", ZoneID: 3, State: "on", Writes: []string{"C1 PLC1"}, Outcome: "ok"},
+		{Time: time.Date(2026, 3, 1, 18, 5, 0, 0, time.UTC), Caller: `=HYPERLINK("http://example.com","x")`, Action: "sync", Outcome: "error", Error: "-2+3"},
+		{Time: time.Date(2026, 3, 1, 18, 6, 0, 0, time.UTC), Caller: "@SUM(A1)", Remote: "+1", Action: "zone", Error: "\tcmd"},
+	}
+	rec := httptest.NewRecorder()
+	writeAuditCSV(rec, entries)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"time", "caller", "remote_addr", "action", "zone_id", "mapping_id", "state", "writes", "outcome", "error", "request_id"},
+		{"2026-03-01T18:00:00Z", "jsmith", "", "zone", "3", "0", "on", "C1 PLC1", "ok", "", ""},
+		{"2026-03-01T18:05:00Z", `'=HYPERLINK("http://example.com","x")`, "", "sync", "0", "0", "", "", "error", "'-2+3", ""},
+		{"2026-03-01T18:06:00Z", "'@SUM(A1)", "'+1", "zone", "0", "0", "", "", "", "'\tcmd", ""},
+	}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("got\n%q\nwant\n%q", rows, want)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Request authentication. Callers use the same shared key that the service
+// sends to WordPress (Config.WordPressAPIKey), either as a plain header or,
+// preferably, as an HMAC signature over the request:
+//
+//	X-FSBHOA-Timestamp: unix seconds
+//	X-FSBHOA-Nonce:     random string, never reused
+//	X-FSBHOA-Signature: hex HMAC-SHA256(key, METHOD\nURI\nTIMESTAMP\nNONCE\nhex(SHA256(body)))
+//
+// Which of these a route accepts depends on its class and the config:
+// "none", "key" (plain key, named token or signature) or "hmac" (signature only).
+//
+// Besides the WordPress key, which may do anything, the config can list named
+// tokens (Config.APITokens) limited to some scopes, e.g. a read-only kiosk.
+// They are sent as "Authorization: Bearer <token>" (or in X-API-KEY).
+const (
+	apiKeyHeader    = "X-API-KEY"
+	timestampHeader = "X-FSBHOA-Timestamp"
+	nonceHeader     = "X-FSBHOA-Nonce"
+	signatureHeader = "X-FSBHOA-Signature"
+
+	// signatureWindow is how far a signed request's timestamp may be from our clock.
+	signatureWindow = 5 * time.Minute
+)
+
+// routeClass groups routes that share an auth setting.
+type routeClass string
+
+const (
+	routeRead    routeClass = "read"    // Status, reports, health
+	routeControl routeClass = "control" // Anything that writes to a PLC or changes the service
+)
+
+var validAuthModes = map[string]bool{"none": true, "key": true, "hmac": true}
+
+// Scopes a route can require. Only "status" routes are in the read class.
+const (
+	scopeStatus   = "status"   // /status, /health, /runtime/report
+	scopeOverride = "override" // Zone overrides
+	scopeTest     = "test"     // Test pulses on single mappings
+	scopeSync     = "sync"     // Push the WordPress config to the PLCs
+	scopeAdmin    = "admin"    // Audit log, log levels, lamp counters
+)
+
+var validScopes = map[string]bool{scopeStatus: true, scopeOverride: true, scopeTest: true, scopeSync: true, scopeAdmin: true}
+
+func scopeClass(scope string) routeClass {
+	if scope == scopeStatus {
+		return routeRead
+	}
+	return routeControl
+}
+
+// APIToken is a named credential limited to some scopes.
+type APIToken struct {
+	Name   string   `json:"Name"`
+	Token  string   `json:"Token"`
+	Scopes []string `json:"Scopes"`
+}
+
+func (t APIToken) allows(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// principal is who a request was authenticated as.
+type principal struct {
+	Name   string // "wordpress", a token name, or "anonymous" when auth is off
+	Method string // "none", "key", "token" or "hmac"
+	token  *APIToken
+}
+
+// allows reports whether the principal may use a scope. The WordPress key
+// and unauthenticated routes (mode "none") are not limited.
+func (p principal) allows(scope string) bool {
+	return p.token == nil || p.token.allows(scope)
+}
+
+type principalKey struct{}
+
+// requestPrincipal returns who the request was authenticated as, if anyone.
+func requestPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// authMode returns the configured mode for a route class.
+func (cfg Config) authMode(class routeClass) string {
+	if class == routeRead {
+		return cfg.AuthReadMode
+	}
+	return cfg.AuthControlMode
+}
+
+// nonceCache remembers the nonces of recent signed requests so a captured
+// request can't be replayed inside the timestamp window.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// use records a nonce and reports whether it was fresh.
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	for n, t := range c.seen {
+		if now.Sub(t) > 2*signatureWindow {
+			delete(c.seen, n)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = now
+	return true
+}
+
+func unauthorized(msg string) error {
+	return &APIError{Status: http.StatusUnauthorized, Code: codeUnauthorized, Message: msg}
+}
+
+func forbidden(msg string) error {
+	return &APIError{Status: http.StatusForbidden, Code: codeForbidden, Message: msg}
+}
+
+// signRequest computes the signature for a request. WordPress does the same
+// in fsbhoa_lighting_service_headers().
+func signRequest(key, method, uri, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, uri, timestamp, nonce, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authenticate checks a request against the mode for its route class.
+func (app *App) authenticate(r *http.Request, class routeClass) (principal, error) {
+	cfg := app.config()
+	mode := cfg.authMode(class)
+	if mode == "none" {
+		return principal{Name: "anonymous", Method: "none"}, nil
+	}
+
+	if r.Header.Get(signatureHeader) != "" {
+		if cfg.WordPressAPIKey == "" {
+			return principal{}, unauthorized("no API key is configured; set one on the WordPress settings page")
+		}
+		if err := app.checkSignature(r, cfg.WordPressAPIKey); err != nil {
+			return principal{}, err
+		}
+		return principal{Name: "wordpress", Method: "hmac"}, nil
+	}
+
+	provided := r.Header.Get(apiKeyHeader)
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		provided = strings.TrimSpace(bearer)
+	}
+	if provided == "" {
+		return principal{}, unauthorized("API key or signature is missing")
+	}
+
+	var p principal
+	switch {
+	case cfg.WordPressAPIKey != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(cfg.WordPressAPIKey)) == 1:
+		p = principal{Name: "wordpress", Method: "key"}
+	default:
+		for i := range cfg.APITokens {
+			t := &cfg.APITokens[i]
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(t.Token)) == 1 {
+				p = principal{Name: t.Name, Method: "token", token: t}
+				break
+			}
+		}
+		if p.Method == "" {
+			return principal{}, unauthorized("invalid API key or token")
+		}
+	}
+	if mode == "hmac" {
+		return principal{}, forbidden(fmt.Sprintf("%s requests must be signed", class))
+	}
+	return p, nil
+}
+
+// checkSignature verifies an HMAC-signed request. The body is read to hash
+// it and then put back for the handler.
+func (app *App) checkSignature(r *http.Request, key string) error {
+	timestamp := r.Header.Get(timestampHeader)
+	nonce := r.Header.Get(nonceHeader)
+	if timestamp == "" || nonce == "" {
+		return unauthorized("signed request needs timestamp and nonce headers")
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return unauthorized("bad timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(secs, 0)); skew > signatureWindow || skew < -signatureWindow {
+		return unauthorized("timestamp outside the allowed window")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return unauthorized("could not read request body")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := signRequest(key, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(signatureHeader))) {
+		return unauthorized("invalid signature")
+	}
+	// Only remember nonces of valid requests, so junk can't fill the cache.
+	if !app.nonces.use(nonce, now) {
+		return unauthorized("replayed request")
+	}
+	return nil
+}
+
+// requireScope wraps a handler so it only runs for callers allowed the scope.
+// Every accepted request is logged with the caller's name; /status is polled
+// constantly, so its lines are at debug level.
+func (app *App) requireScope(scope string, h httprouter.Handle) httprouter.Handle {
+	class := scopeClass(scope)
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		p, err := app.authenticate(r, class)
+		if err == nil && !p.allows(scope) {
+			err = forbidden(fmt.Sprintf("token %q does not have the %q scope", p.Name, scope))
+		}
+		if err != nil {
+			httpLog.WarnContext(r.Context(), "rejected request", "remote", r.RemoteAddr, "scope", scope)
+			writeError(w, r, err)
+			return
+		}
+
+		level := slog.LevelInfo
+		if scope == scopeStatus {
+			level = slog.LevelDebug
+		}
+		httpLog.Log(r.Context(), level, "request", "caller", p.Name, "auth", p.Method, "scope", scope, "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		h(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), ps)
+	}
+}
+
+// validateTokens checks the APITokens list from the config.
+func validateTokens(tokens []APIToken) error {
+	names := make(map[string]bool)
+	for _, t := range tokens {
+		switch {
+		case t.Name == "" || t.Name == "wordpress" || t.Name == "anonymous":
+			return fmt.Errorf("API token name %q is empty or reserved", t.Name)
+		case names[t.Name]:
+			return fmt.Errorf("API token name %q is used twice", t.Name)
+		case len(t.Token) < 16:
+			return fmt.Errorf("API token %q must be at least 16 characters", t.Name)
+		}
+		names[t.Name] = true
+		for _, sc := range t.Scopes {
+			if !validScopes[sc] {
+				return fmt.Errorf("API token %q has unknown scope %q", t.Name, sc)
+			}
+		}
+	}
+	return nil
+}
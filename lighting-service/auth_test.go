@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The expected signatures were computed outside Go, the way
+// fsbhoa_lighting_service_headers() does in PHP.
+func TestSignRequest(t *testing.T) {
+	tests := []struct {
+		method, uri, timestamp, nonce, body string
+		want                                string
+	}{
+		{"POST", "/api/v1/sync?full=true", "1700000000", "abc123", `{"targets":[]}`, "0d573cc4cbe081a54147f12746011ca33e2ead949ce052d8c92f2d956bb9b187"},
+		{"GET", "/api/v1/status", "1700000000", "n", "", "5ce8230d75981cead252efd50e2cabf5d4532032ca627be8d2a79ed3136a1e10"},
+	}
+	for _, tt := range tests {
+		if got := signRequest("sekrit", tt.method, tt.uri, tt.timestamp, tt.nonce, []byte(tt.body)); got != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.method, tt.uri, got, tt.want)
+		}
+	}
+}
+
+const testToken = "status-token-0123456789"
+
+func TestAuthenticate(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	signed := func(key, timestamp, nonce string) http.Header {
+		return http.Header{
+			timestampHeader: {timestamp},
+			nonceHeader:     {nonce},
+			signatureHeader: {signRequest(key, "POST", "/api/v1/sync", timestamp, nonce, nil)},
+		}
+	}
+	tests := []struct {
+		name        string
+		controlMode string
+		scope       string
+		header      http.Header
+		wantStatus  int // 0 for accepted
+		wantCaller  string
+	}{
+		{name: "key", scope: scopeSync, header: http.Header{apiKeyHeader: {"sekrit"}}, wantCaller: "wordpress"},
+		{name: "bearer token", scope: scopeStatus, header: http.Header{"Authorization": {"Bearer " + testToken}}, wantCaller: "kiosk"},
+		{name: "signature", scope: scopeSync, header: signed("sekrit", now, "n1"), wantCaller: "wordpress"},
+		{name: "signature required", controlMode: "hmac", scope: scopeSync, header: signed("sekrit", now, "n2"), wantCaller: "wordpress"},
+
+		{name: "nothing", scope: scopeStatus, header: http.Header{}, wantStatus: http.StatusUnauthorized},
+		{name: "unknown key", scope: scopeStatus, header: http.Header{apiKeyHeader: {"guess"}}, wantStatus: http.StatusUnauthorized},
+		{name: "unknown bearer", scope: scopeStatus, header: http.Header{"Authorization": {"Bearer guess"}}, wantStatus: http.StatusUnauthorized},
+		{name: "wrong signing key", scope: scopeSync, header: signed("guess", now, "n3"), wantStatus: http.StatusUnauthorized},
+		{name: "stale signature", scope: scopeSync, header: signed("sekrit", stale, "n4"), wantStatus: http.StatusUnauthorized},
+		{name: "replayed signature", scope: scopeSync, header: signed("sekrit", now, "n1"), wantStatus: http.StatusUnauthorized},
+
+		{name: "token without the scope", scope: scopeSync, header: http.Header{"Authorization": {"Bearer " + testToken}}, wantStatus: http.StatusForbidden},
+		{name: "plain key where signing is required", controlMode: "hmac", scope: scopeSync, header: http.Header{apiKeyHeader: {"sekrit"}}, wantStatus: http.StatusForbidden},
+	}
+
+	app := &App{}
+	for _, tt := range tests { // In order: "replayed signature" reuses a nonce
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.WordPressAPIKey = "sekrit"
+			cfg.APITokens = []APIToken{{Name: "kiosk", Token: testToken, Scopes: []string{scopeStatus}}}
+			cfg.AuthReadMode, cfg.AuthControlMode = "key", "key"
+			if tt.controlMode != "" {
+				cfg.AuthControlMode = tt.controlMode
+			}
+			app.cfg = cfg
+
+			var caller string
+			h := app.requireScope(tt.scope, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				p, _ := requestPrincipal(r)
+				caller = p.Name
+			})
+			req := httptest.NewRequest("POST", "/api/v1/sync", strings.NewReader(""))
+			for k, v := range tt.header {
+				req.Header.Set(k, v[0])
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req, nil)
+
+			if tt.wantStatus == 0 {
+				if rec.Code != http.StatusOK || caller != tt.wantCaller {
+					t.Errorf("status %d caller %q, want accepted as %q (%s)", rec.Code, caller, tt.wantCaller, rec.Body)
+				}
+				return
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	var c nonceCache
+	now := time.Now()
+	if !c.use("a", now) || c.use("a", now.Add(time.Minute)) {
+		t.Fatal("a nonce must be accepted once")
+	}
+	if !c.use("a", now.Add(2*signatureWindow+time.Second)) {
+		t.Error("a nonce older than the window should have been forgotten")
+	}
+}
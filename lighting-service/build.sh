@@ -10,8 +10,12 @@ go mod tidy
 echo "Compiling..."
 go build -o lighting-service .
 
+# The technician's CLI is the same binary, run under the name "lightctl"
+ln -sf lighting-service lightctl
+
 echo ""
 echo "✅ Build complete!"
 echo "You can now enable and start the service with:"
 echo "sudo systemctl restart fsbhoa-lighting.service"
+echo "The field CLI is ./lightctl (see ./lightctl -h)."
 
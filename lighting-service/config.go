@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Timezone must load even if the host has no zoneinfo
+)
+
+// Config struct holds all our settings.
+type Config struct {
+	ListenPort          string            `json:"ListenPort"` // ":8085" or "8085"
+	LogFilePath         string            `json:"LogFilePath"`
+	PLCs                map[int]PLCConfig `json:"PLCs"` // See plc_config.go
+	WordPressAPIKey     string            `json:"WordPressAPIKey"`
+	WordPressAPIBaseURL string            `json:"WordPressAPIBaseURL"`
+	AuditLogPath        string            `json:"AuditLogPath"`
+	RuntimeFilePath     string            `json:"RuntimeFilePath"`
+	StatusPollSeconds   int               `json:"StatusPollSeconds"`
+
+	// BindAddress is the interface to listen on; "0.0.0.0" or "::" for all.
+	// With TLSCertFile and TLSKeyFile set the service only speaks HTTPS, and
+	// with TLSClientCAFile set clients also need a certificate from that CA.
+	BindAddress     string `json:"BindAddress"`
+	TLSCertFile     string `json:"TLSCertFile"`
+	TLSKeyFile      string `json:"TLSKeyFile"`
+	TLSClientCAFile string `json:"TLSClientCAFile"`
+
+	// Auth for callers of this service: "none", "key" or "hmac" (see auth.go).
+	// Read covers status and reports; control covers anything that acts.
+	AuthReadMode    string `json:"AuthReadMode"`
+	AuthControlMode string `json:"AuthControlMode"`
+
+	// APITokens are extra named credentials with limited scopes.
+	APITokens []APIToken `json:"APITokens"`
+
+	// Pulse throttling per PLC (see pulse_queue.go): at most PulseBudget queued
+	// ON/OFF requests at once, earning PulseRefillPerMinute back, written
+	// PulseSpacingMs apart.
+	PulseBudget          int     `json:"PulseBudget"`
+	PulseRefillPerMinute float64 `json:"PulseRefillPerMinute"`
+	PulseSpacingMs       int     `json:"PulseSpacingMs"`
+
+	// PhotocellForceMinutes is how long a forced photocell lasts unless the
+	// request gives ?minutes=. The force is saved in PhotocellForcePath.
+	PhotocellForceMinutes int    `json:"PhotocellForceMinutes"`
+	PhotocellForcePath    string `json:"PhotocellForcePath"`
+
+	// Latitude/Longitude (degrees, east positive) let the photocell monitor
+	// compare the sensor with sunrise and sunset; leave both 0 to skip that.
+	// The other settings tune when it raises an alert (see photocell_monitor.go).
+	Latitude                     float64 `json:"Latitude"`
+	Longitude                    float64 `json:"Longitude"`
+	PhotocellStuckHours          int     `json:"PhotocellStuckHours"`
+	PhotocellFlapCount           int     `json:"PhotocellFlapCount"`
+	PhotocellFlapMinutes         int     `json:"PhotocellFlapMinutes"`
+	PhotocellSunToleranceMinutes int     `json:"PhotocellSunToleranceMinutes"`
+	PhotocellLinkSeconds         int     `json:"PhotocellLinkSeconds"` // PLCs may disagree on C154 this long before the link counts as failed
+
+	// Timezone is the IANA zone the PLC clocks and schedules run in, e.g.
+	// "America/Los_Angeles". Empty means the host's zone.
+	Timezone string `json:"Timezone"`
+
+	// The PLC clocks are read every ClockCheckMinutes (0 turns the check off)
+	// and raise an alert when off by ClockAlertSeconds or more. With
+	// ClockCorrectSeconds above 0 the service also sets a clock that is off by
+	// that much, or has the wrong day of the week (see plc_clock.go).
+	ClockCheckMinutes   int `json:"ClockCheckMinutes"`
+	ClockAlertSeconds   int `json:"ClockAlertSeconds"`
+	ClockCorrectSeconds int `json:"ClockCorrectSeconds"`
+
+	// The PLC registers are compared with the WordPress config every
+	// DriftCheckMinutes (0 turns the check off). With DriftRepair the blocks
+	// that differ are rewritten (see drift.go).
+	DriftCheckMinutes int  `json:"DriftCheckMinutes"`
+	DriftRepair       bool `json:"DriftRepair"`
+
+	// ShutdownTimeoutSeconds bounds how long SIGTERM waits for requests and
+	// config pushes to finish. Keep it below systemd's TimeoutStopSec.
+	ShutdownTimeoutSeconds int `json:"ShutdownTimeoutSeconds"`
+
+	// Logging: level is debug/info/warn/error, format is "text" or "json".
+	// LogLevels overrides the level per subsystem, e.g. {"modbus": "debug"}.
+	LogLevel       string            `json:"LogLevel"`
+	LogLevels      map[string]string `json:"LogLevels"`
+	LogFormat      string            `json:"LogFormat"`
+	LogMaxSizeMB   int               `json:"LogMaxSizeMB"`
+	LogMaxBackups  int               `json:"LogMaxBackups"`
+	LogRotateDaily bool              `json:"LogRotateDaily"`
+}
+
+const configFilePath = "/var/lib/fsbhoa/lighting_service.json"
+
+// defaultConfig is used for anything the config file leaves out.
+func defaultConfig() Config {
+	return Config{
+		ListenPort:                   ":8085",
+		BindAddress:                  "127.0.0.1",
+		LogFilePath:                  "~/fsbhoa_light/lighting-service/lighting-service.log",
+		PLCs:                         make(map[int]PLCConfig),
+		AuditLogPath:                 "/var/lib/fsbhoa/lighting_audit.log",
+		RuntimeFilePath:              "/var/lib/fsbhoa/lighting_runtime.json",
+		StatusPollSeconds:            60,
+		AuthReadMode:                 "key",
+		AuthControlMode:              "key",
+		PulseBudget:                  24,
+		PulseRefillPerMinute:         24,
+		PulseSpacingMs:               100,
+		PhotocellForceMinutes:        720,
+		PhotocellForcePath:           "/var/lib/fsbhoa/photocell_force.json",
+		PhotocellStuckHours:          30,
+		PhotocellFlapCount:           6,
+		PhotocellFlapMinutes:         30,
+		PhotocellSunToleranceMinutes: 90,
+		PhotocellLinkSeconds:         120,
+		ClockCheckMinutes:            15,
+		ClockAlertSeconds:            60,
+		DriftCheckMinutes:            30,
+		ShutdownTimeoutSeconds:       20,
+		LogLevel:                     "info",
+		LogFormat:                    "text",
+		LogMaxSizeMB:                 10,
+		LogMaxBackups:                5,
+	}
+}
+
+// loadConfig reads and validates the config file, filling in defaults.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("could not read config file: %w", err)
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse config file: %w", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// validateConfig catches settings that would break the service at runtime.
+func validateConfig(cfg Config) error {
+	port := strings.TrimPrefix(cfg.ListenPort, ":")
+	if _, err := net.LookupPort("tcp", port); err != nil || port == "" {
+		return fmt.Errorf("ListenPort %q is not a valid port", cfg.ListenPort)
+	}
+	if err := validatePLCs(cfg.PLCs); err != nil {
+		return err
+	}
+	if cfg.BindAddress != "" && net.ParseIP(cfg.BindAddress) == nil && cfg.BindAddress != "localhost" {
+		return fmt.Errorf("BindAddress %q must be an IP address", cfg.BindAddress)
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("TLSCertFile and TLSKeyFile must be set together")
+	}
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return fmt.Errorf("TLSClientCAFile needs TLSCertFile and TLSKeyFile")
+	}
+	if cfg.WordPressAPIBaseURL != "" {
+		u, err := url.Parse(cfg.WordPressAPIBaseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("WordPressAPIBaseURL %q is not a valid URL", cfg.WordPressAPIBaseURL)
+		}
+	}
+	if cfg.PhotocellForceMinutes < 1 || time.Duration(cfg.PhotocellForceMinutes)*time.Minute > maxPhotocellForce {
+		return fmt.Errorf("PhotocellForceMinutes must be between 1 and %d", int(maxPhotocellForce.Minutes()))
+	}
+	if cfg.Latitude < -90 || cfg.Latitude > 90 || cfg.Longitude < -180 || cfg.Longitude > 180 {
+		return fmt.Errorf("Latitude must be within ±90 and Longitude within ±180")
+	}
+	if cfg.PhotocellStuckHours < 1 || cfg.PhotocellFlapCount < 2 || cfg.PhotocellFlapMinutes < 1 || cfg.PhotocellSunToleranceMinutes < 1 {
+		return fmt.Errorf("PhotocellStuckHours, PhotocellFlapMinutes and PhotocellSunToleranceMinutes must be at least 1, PhotocellFlapCount at least 2")
+	}
+	if cfg.PhotocellLinkSeconds < 1 {
+		return fmt.Errorf("PhotocellLinkSeconds must be at least 1")
+	}
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return fmt.Errorf("Timezone %q: %w", cfg.Timezone, err)
+	}
+	if cfg.ClockCheckMinutes < 0 || cfg.ClockAlertSeconds < 1 || cfg.ClockCorrectSeconds < 0 {
+		return fmt.Errorf("ClockAlertSeconds must be at least 1, ClockCheckMinutes and ClockCorrectSeconds not negative")
+	}
+	if cfg.DriftCheckMinutes < 0 {
+		return fmt.Errorf("DriftCheckMinutes must not be negative")
+	}
+	if cfg.StatusPollSeconds < 0 {
+		return fmt.Errorf("StatusPollSeconds must not be negative")
+	}
+	for _, mode := range []string{cfg.AuthReadMode, cfg.AuthControlMode} {
+		if !validAuthModes[mode] {
+			return fmt.Errorf("auth mode %q must be none, key or hmac", mode)
+		}
+	}
+	if err := validateTokens(cfg.APITokens); err != nil {
+		return err
+	}
+	if cfg.PulseBudget < 1 || cfg.PulseRefillPerMinute <= 0 || cfg.PulseSpacingMs < 0 {
+		return fmt.Errorf("PulseBudget and PulseRefillPerMinute must be positive and PulseSpacingMs not negative")
+	}
+	if cfg.ShutdownTimeoutSeconds < 0 {
+		return fmt.Errorf("ShutdownTimeoutSeconds must not be negative")
+	}
+	if _, err := parseLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("LogLevel %q: %w", cfg.LogLevel, err)
+	}
+	for sub, s := range cfg.LogLevels {
+		if _, err := parseLevel(s); err != nil {
+			return fmt.Errorf("LogLevels[%s] %q: %w", sub, s, err)
+		}
+	}
+	return nil
+}
+
+// listenAddr joins BindAddress and ListenPort into a host:port for net.Listen.
+func (cfg Config) listenAddr() string {
+	return net.JoinHostPort(cfg.BindAddress, strings.TrimPrefix(cfg.ListenPort, ":"))
+}
+
+// hasLocation reports whether Latitude/Longitude are set for sunrise/sunset.
+func (cfg Config) hasLocation() bool {
+	return cfg.Latitude != 0 || cfg.Longitude != 0
+}
+
+// location returns the Timezone. It was checked when the config was loaded.
+func (cfg Config) location() *time.Location {
+	if cfg.Timezone == "" {
+		return time.Local // LoadLocation("") would give UTC
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// config returns a copy of the current settings. Callers should take one
+// snapshot per request so a reload can't change settings halfway through.
+func (app *App) config() Config {
+	app.configMutex.RLock()
+	defer app.configMutex.RUnlock()
+	return app.cfg
+}
+
+// ConfigReloadStatus is the outcome of the most recent reload, shown on /health.
+type ConfigReloadStatus struct {
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"` // "startup", "SIGHUP" or "file change"
+	OK      bool      `json:"ok"`
+	Error   string    `json:"error,omitempty"`
+	Notes   []string  `json:"notes,omitempty"` // Settings that still need a restart
+}
+
+// reloadConfig re-reads the config file and, if it is valid, swaps it in and
+// rebuilds the PLC sessions. An invalid file leaves the running config alone.
+func (app *App) reloadConfig(trigger string) error {
+	newCfg, err := loadConfig(app.configPath)
+
+	status := ConfigReloadStatus{Time: time.Now(), Trigger: trigger, OK: err == nil}
+	if err != nil {
+		status.Error = err.Error()
+		app.setReloadStatus(status)
+		mainLog.Error("config reload rejected, keeping current settings", "trigger", trigger, "err", err)
+		return err
+	}
+
+	app.configMutex.Lock()
+	oldCfg := app.cfg
+	app.cfg = newCfg
+	app.sessions.Rebuild(newCfg.PLCs)
+	app.configMutex.Unlock()
+
+	// The listener and log file are opened once at startup.
+	if newCfg.listenAddr() != oldCfg.listenAddr() {
+		status.Notes = append(status.Notes, "BindAddress/ListenPort changed; restart the service to use it")
+	}
+	// Renewed certificate files are picked up on their own; new paths are not.
+	if newCfg.TLSCertFile != oldCfg.TLSCertFile || newCfg.TLSKeyFile != oldCfg.TLSKeyFile || newCfg.TLSClientCAFile != oldCfg.TLSClientCAFile {
+		status.Notes = append(status.Notes, "TLS file paths changed; restart the service to use them")
+	}
+	if newCfg.LogFilePath != oldCfg.LogFilePath || newCfg.LogFormat != oldCfg.LogFormat {
+		status.Notes = append(status.Notes, "log file/format changed; restart the service to use it")
+	}
+	applyLogLevels(newCfg)
+
+	app.setReloadStatus(status)
+	mainLog.Info("config reloaded", "trigger", trigger, "plcs", newCfg.plcAddresses(), "wordpress_url", newCfg.WordPressAPIBaseURL, "notes", status.Notes)
+	return nil
+}
+
+func (app *App) setReloadStatus(s ConfigReloadStatus) {
+	app.configMutex.Lock()
+	defer app.configMutex.Unlock()
+	app.lastReload = s
+}
+
+func (app *App) reloadStatus() ConfigReloadStatus {
+	app.configMutex.RLock()
+	defer app.configMutex.RUnlock()
+	return app.lastReload
+}
+
+// watchConfig reloads the config on SIGHUP or when the file changes on disk.
+func (app *App) watchConfig() {
+	changes := make(chan struct{}, 1)
+	go watchConfigFile(app.configPath, changes)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-hup:
+			app.reloadConfig("SIGHUP")
+		case <-changes:
+			// WordPress writes the file in more than one syscall; let it settle.
+			time.Sleep(500 * time.Millisecond)
+			drain(changes)
+			app.reloadConfig("file change")
+		}
+	}
+}
+
+func drain(ch chan struct{}) {
+	for {
+		select {
+		case <-ch:
+		default:
+			return
+		}
+	}
+}
+
+// notify does a non-blocking send; one pending change is enough.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// pollConfigFile is the fallback watcher: it checks the modification time every few seconds.
+func pollConfigFile(path string, changes chan struct{}) {
+	var last time.Time
+	if info, err := os.Stat(path); err == nil {
+		last = info.ModTime()
+	}
+	for range time.Tick(5 * time.Second) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime() != last {
+			last = info.ModTime()
+			notify(changes)
+		}
+	}
+}
+
+// configDirAndName splits the path for directory watches (editors and some
+// writers replace the file rather than rewriting it in place).
+func configDirAndName(path string) (string, string) {
+	return filepath.Dir(path), filepath.Base(path)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	app := newTestApp(t)
+	app.configPath = filepath.Join(t.TempDir(), "lighting_service.json")
+	t.Cleanup(func() { app.sessions.CloseAll() })
+
+	tests := []struct {
+		name      string
+		json      string
+		wantErr   string // Substring; "" for a reload that is applied
+		wantPLCs  []int  // Sessions after the reload
+		wantNotes []string
+	}{
+		{
+			name:     "PLCs added",
+			json:     `{"PLCs": {"1": "192.168.1.201:502", "2": "192.168.1.202:502"}, "WordPressAPIBaseURL": "https://fsbhoa.example"}`,
+			wantPLCs: []int{1, 2},
+		},
+		{
+			name:     "invalid kept out",
+			json:     `{"PLCs": {"1": "192.168.1.201"}}`,
+			wantErr:  `PLC 1 address "192.168.1.201" must be host:port`,
+			wantPLCs: []int{1, 2},
+		},
+		{
+			name:     "unparsable kept out",
+			json:     `{"PLCs": `,
+			wantErr:  "could not parse config file",
+			wantPLCs: []int{1, 2},
+		},
+		{
+			name:      "needs a restart",
+			json:      `{"PLCs": {"2": "192.168.1.202:502"}, "ListenPort": ":9000", "LogFormat": "json"}`,
+			wantPLCs:  []int{2},
+			wantNotes: []string{"BindAddress/ListenPort changed", "log file/format changed"},
+		},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(app.configPath, []byte(tt.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		before := app.config()
+		err := app.reloadConfig("SIGHUP")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.wantErr)
+		}
+
+		status := app.reloadStatus()
+		if status.Trigger != "SIGHUP" || status.OK != (tt.wantErr == "") {
+			t.Errorf("%s: reload status %+v", tt.name, status)
+		}
+		if tt.wantErr != "" && app.config().WordPressAPIBaseURL != before.WordPressAPIBaseURL {
+			t.Errorf("%s: a rejected file changed the running config", tt.name)
+		}
+		var ids []int
+		for _, s := range app.sessions.All() {
+			ids = append(ids, s.ID)
+		}
+		if !slices.Equal(ids, tt.wantPLCs) {
+			t.Errorf("%s: sessions for PLCs %v, want %v", tt.name, ids, tt.wantPLCs)
+		}
+		if len(status.Notes) != len(tt.wantNotes) {
+			t.Errorf("%s: notes %q, want %q", tt.name, status.Notes, tt.wantNotes)
+			continue
+		}
+		for i, note := range tt.wantNotes {
+			if !strings.HasPrefix(status.Notes[i], note) {
+				t.Errorf("%s: note %q, want %q", tt.name, status.Notes[i], note)
+			}
+		}
+	}
+}
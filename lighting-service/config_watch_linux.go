@@ -0,0 +1,54 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+)
+
+// watchConfigFile sends on changes whenever the config file is written or
+// replaced. It watches the directory with inotify and falls back to polling
+// if inotify is unavailable.
+func watchConfigFile(path string, changes chan struct{}) {
+	dir, name := configDirAndName(path)
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		mainLog.Warn("inotify unavailable, polling config file instead", "err", err)
+		pollConfigFile(path, changes)
+		return
+	}
+	defer syscall.Close(fd)
+
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		mainLog.Warn("could not watch config directory, polling config file instead", "dir", dir, "err", err)
+		pollConfigFile(path, changes)
+		return
+	}
+	mainLog.Info("watching config file for changes", "path", path)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			mainLog.Error("inotify read failed, polling config file instead", "err", err)
+			pollConfigFile(path, changes)
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameBytes := buf[nameStart : nameStart+int(event.Len)]
+			if string(bytes.TrimRight(nameBytes, "\x00")) == name {
+				notify(changes)
+			}
+			offset = nameStart + int(event.Len)
+		}
+	}
+}
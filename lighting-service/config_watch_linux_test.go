@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lighting_service.json")
+	changes := make(chan struct{}, 1)
+	go watchConfigFile(path, changes)
+	time.Sleep(100 * time.Millisecond) // Let the watch start
+
+	expect := func(what string, want bool) {
+		t.Helper()
+		select {
+		case <-changes:
+			if !want {
+				t.Errorf("%s: reported a change", what)
+			}
+		case <-time.After(500 * time.Millisecond):
+			if want {
+				t.Errorf("%s: no change reported", what)
+			}
+		}
+	}
+	write := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("other.json")
+	expect("another file written", false)
+	write("lighting_service.json")
+	expect("written in place", true)
+	// Editors and deploy tools save by renaming a temporary file over the config.
+	write("lighting_service.json.tmp")
+	drain(changes)
+	if err := os.Rename(filepath.Join(dir, "lighting_service.json.tmp"), path); err != nil {
+		t.Fatal(err)
+	}
+	expect("renamed into place", true)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// watchConfigFile polls the config file; inotify is Linux-only.
+func watchConfigFile(path string, changes chan struct{}) {
+	pollConfigFile(path, changes)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Registers can be changed behind the service's back, from the CLICK
+// programming software or by a sync that half-failed. Every DriftCheckMinutes
+// the service compiles the WordPress config into the image each PLC should
+// hold (see plc_image.go), reads the live image and reports the registers that
+// differ. With DriftRepair on it rewrites just the blocks that differ, and sets
+// C151 if a loop follows one of them, which drops the manual overrides on that PLC.
+
+// PLCDrift is the last drift check of one PLC.
+type PLCDrift struct {
+	Checked      time.Time      `json:"checked"`
+	InSync       bool           `json:"in_sync"`
+	Diff         []RegisterDiff `json:"diff"`
+	Repaired     *time.Time     `json:"repaired,omitempty"`      // Last time the service rewrote blocks here
+	RepairWrites []string       `json:"repair_writes,omitempty"` // What that repair wrote
+	Error        string         `json:"error,omitempty"`         // The PLC or WordPress could not be read
+}
+
+// DriftMonitor keeps the last drift check of every PLC.
+type DriftMonitor struct {
+	mu       sync.Mutex
+	plcs     map[int]PLCDrift
+	alerting map[int]bool // For logging drift once when it starts
+}
+
+func NewDriftMonitor() *DriftMonitor {
+	return &DriftMonitor{plcs: make(map[int]PLCDrift), alerting: make(map[int]bool)}
+}
+
+// record stores a check, keeping the last repair, and logs drift that starts
+// or clears. A check that failed leaves the alert as it was.
+func (m *DriftMonitor) record(id int, d PLCDrift) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d.Repaired == nil {
+		d.Repaired, d.RepairWrites = m.plcs[id].Repaired, m.plcs[id].RepairWrites
+	}
+	m.plcs[id] = d
+	if d.Error != "" {
+		return
+	}
+	switch {
+	case !d.InSync && !m.alerting[id]:
+		syncLog.Warn("PLC registers differ from the WordPress config", "plc", id, "registers", len(d.Diff), "first", d.Diff[0].Register)
+	case d.InSync && m.alerting[id]:
+		syncLog.Info("PLC registers match the WordPress config again", "plc", id)
+	}
+	m.alerting[id] = !d.InSync
+}
+
+// Report returns the last check of every PLC.
+func (m *DriftMonitor) Report() map[int]PLCDrift {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := make(map[int]PLCDrift, len(m.plcs))
+	for id, d := range m.plcs {
+		report[id] = d
+	}
+	return report
+}
+
+// Alerts describes every PLC whose registers differ, in PLC order.
+func (m *DriftMonitor) Alerts() []string {
+	report := m.Report()
+	ids := make([]int, 0, len(report))
+	for id := range report {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var alerts []string
+	for _, id := range ids {
+		d := report[id]
+		if d.Error != "" || d.InSync {
+			continue
+		}
+		first := d.Diff[0]
+		alerts = append(alerts, fmt.Sprintf("PLC %d has %d register(s) that differ from the WordPress config, first %s (%s) is %s instead of %s",
+			id, len(d.Diff), first.Register, first.Meaning, first.ActualText, first.ExpectedText))
+	}
+	return alerts
+}
+
+// startDriftMonitor checks the PLC images every DriftCheckMinutes.
+func (app *App) startDriftMonitor(ctx context.Context) {
+	interval := time.Duration(app.config().DriftCheckMinutes) * time.Minute
+	if interval <= 0 {
+		syncLog.Info("drift check is off")
+		return
+	}
+	syncLog.Info("starting drift check", "interval", interval, "repair", app.config().DriftRepair)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	app.checkDrift()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.checkDrift()
+		}
+	}
+}
+
+// checkDrift compares every PLC's image with the WordPress config and
+// repairs it if configured to. It holds the push lock throughout, so its
+// config, check and repair can't interleave with a sync.
+func (app *App) checkDrift() {
+	if app.isSimulationMode() {
+		return
+	}
+	// A check during a sync would see it half written, and a repair would
+	// write an older image under it. The next check comes soon enough.
+	done, ok, err := app.tryBeginPush()
+	if err != nil {
+		return
+	}
+	if !ok {
+		syncLog.Info("drift check skipped, a sync is in progress")
+		return
+	}
+	defer done()
+	cfg := app.config()
+
+	data, err := FetchConfigurationFromAPI(cfg)
+	if err != nil {
+		syncLog.Warn("drift check skipped, WordPress config unavailable", "err", err)
+		for _, session := range app.sessions.With(capSchedules) {
+			app.drift.record(session.ID, PLCDrift{Checked: time.Now(), Error: fmt.Sprintf("WordPress config unavailable: %v", err)})
+		}
+		return
+	}
+	for _, session := range app.sessions.With(capSchedules) {
+		app.drift.record(session.ID, app.checkPLCDrift(cfg, session, compileImage(data, session.ID)))
+	}
+}
+
+func (app *App) checkPLCDrift(cfg Config, session *PLCSession, expected PLCImage) PLCDrift {
+	actual, err := readPLCImage(session)
+	if err != nil {
+		syncLog.Warn("could not read PLC image", "plc", session.ID, "err", err)
+		return PLCDrift{Checked: time.Now(), Error: err.Error()}
+	}
+	d := driftBetween(expected, actual)
+	if d.InSync || !cfg.DriftRepair {
+		return d
+	}
+
+	slots, mapChanged := changedBlocks(expected, actual)
+	syncLog.Warn("repairing PLC registers", "plc", session.ID, "slots", slots, "map", mapChanged)
+	audit := AuditEntry{Time: time.Now(), Caller: "drift", Action: "repair"}
+	var writes []string
+	err = session.Do(func(client modbus.Client) error {
+		var err error
+		if writes, err = writeImageBlocks(client, session.ID, expected, slots, mapChanged); err != nil || !needsResync(expected, actual, slots, mapChanged) {
+			return err
+		}
+		resync, err := requestResync(client, session.ID)
+		writes = append(writes, resync...)
+		return err
+	})
+	app.finishAudit(audit, writes, err)
+	if err != nil {
+		syncLog.Error("PLC repair incomplete", "plc", session.ID, "err", err)
+	}
+	repaired := time.Now()
+
+	// Read it back so the report shows the result, not the drift that was fixed.
+	if actual, err = readPLCImage(session); err != nil {
+		d = PLCDrift{Checked: time.Now(), Error: err.Error()}
+	} else {
+		d = driftBetween(expected, actual)
+	}
+	d.Repaired, d.RepairWrites = &repaired, writes
+	return d
+}
+
+func driftBetween(expected, actual PLCImage) PLCDrift {
+	diff := diffImages(expected, actual)
+	return PLCDrift{Checked: time.Now(), InSync: len(diff) == 0, Diff: diff}
+}
+
+// handleDrift returns the last drift check of every PLC.
+func (app *App) handleDrift(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, app.drift.Report())
+}
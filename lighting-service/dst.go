@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The PLCs have no idea of time zones: a TIME span fires when SD24/SD25 equal
+// its HHMM, and NTP moves the clock an hour at each DST change. A span set
+// inside the hour that is skipped in spring never fires that night, and one
+// inside the hour that repeats in autumn fires twice. dstWarnings finds those
+// so a sync can say so.
+
+// dstChange is one change of UTC offset in the configured zone.
+type dstChange struct {
+	At    time.Time // First instant with the new offset, in the zone
+	Shift int       // New offset minus old, in seconds; positive skips wall time
+}
+
+// dstChanges lists the offset changes in loc between from and to. Changes are
+// found hour by hour and then narrowed to the second.
+func dstChanges(loc *time.Location, from, to time.Time) []dstChange {
+	var changes []dstChange
+	_, prev := from.In(loc).Zone()
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		next := t.Add(time.Hour)
+		_, off := next.In(loc).Zone()
+		if off == prev {
+			continue
+		}
+		lo, hi := t, next // Offset is prev at lo and off at hi
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, o := mid.In(loc).Zone(); o == prev {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		changes = append(changes, dstChange{At: hi.In(loc), Shift: off - prev})
+		prev = off
+	}
+	return changes
+}
+
+// dstWarnings checks every TIME span against the DST changes in the next year.
+func dstWarnings(data *FullConfigurationData, loc *time.Location, now time.Time) []string {
+	var warnings []string
+	for _, change := range dstChanges(loc, now, now.AddDate(1, 0, 0)) {
+		// Wall-clock minutes of the day that are skipped or repeated.
+		after := change.At.Hour()*60 + change.At.Minute()
+		start, end, what := after-change.Shift/60, after, "does not exist"
+		if change.Shift < 0 {
+			start, end, what = after, after-change.Shift/60, "happens twice"
+		}
+		day := strings.ToLower(change.At.Format("Mon"))
+		window := fmt.Sprintf("%02d:%02d-%02d:%02d", start/60, start%60, end/60, end%60)
+
+		for _, schedule := range data.Schedules {
+			for i, span := range schedule.Spans {
+				if !slices.Contains(span.DaysOfWeek, day) {
+					continue
+				}
+				for _, edge := range []struct {
+					name    string
+					trigger string
+					at      *string
+				}{{"on", span.OnTrigger, span.OnTime}, {"off", span.OffTrigger, span.OffTime}} {
+					m, ok := spanMinute(edge.trigger, edge.at)
+					if !ok || m < start || m >= end {
+						continue
+					}
+					warnings = append(warnings, fmt.Sprintf(
+						"schedule %q span %d turns %s at %s, but on %s %s local time %s; move it out of that hour",
+						schedule.ScheduleName, i+1, edge.name, *edge.at, change.At.Format("Mon Jan 2"), window, what))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// spanMinute returns a TIME trigger's minute of the day, as the PLC will
+// compare it (see triggerToPLCData).
+func spanMinute(trigger string, t *string) (int, bool) {
+	if trigger == "SUNDOWN" || trigger == "SUNRISE" || t == nil {
+		return 0, false
+	}
+	hhmm := strings.ReplaceAll(*t, ":", "")
+	if len(hhmm) < 4 {
+		return 0, false
+	}
+	v, err := strconv.Atoi(hhmm[:4])
+	if err != nil {
+		return 0, false
+	}
+	return v/100*60 + v%100, true
+}
+
+// resolveOverlap picks which of the two instants an ambiguous wall-clock
+// reading means (the repeated hour in autumn): the one closer to now.
+// time.Date always takes the first.
+func resolveOverlap(t, now time.Time) time.Time {
+	for _, shift := range []time.Duration{-time.Hour, time.Hour} {
+		alt := t.Add(shift)
+		if alt.Hour() != t.Hour() || alt.Minute() != t.Minute() || alt.Second() != t.Second() {
+			continue
+		}
+		if alt.Sub(now).Abs() < t.Sub(now).Abs() {
+			return alt
+		}
+	}
+	return t
+}
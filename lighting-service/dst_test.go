@@ -0,0 +1,107 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDSTWarnings(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In 2026 Pacific time skips 02:00-03:00 on Sun Mar 8 and repeats
+	// 01:00-02:00 on Sun Nov 1.
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, pacific)
+	at := func(s string) *string { return &s }
+	span := func(days []string, onTrigger string, on *string, offTrigger string, off *string) FullConfigSchedule {
+		return FullConfigSchedule{ScheduleName: "Test", Spans: []FullConfigSpan{{DaysOfWeek: days, OnTrigger: onTrigger, OnTime: on, OffTrigger: offTrigger, OffTime: off}}}
+	}
+	sun, mon := []string{"sun"}, []string{"mon"}
+
+	tests := []struct {
+		name     string
+		loc      *time.Location
+		schedule FullConfigSchedule
+		want     []string
+	}{
+		{
+			name:     "in the skipped hour",
+			loc:      pacific,
+			schedule: span(sun, "TIME", at("02:30"), "SUNRISE", nil),
+			want:     []string{`schedule "Test" span 1 turns on at 02:30, but on Sun Mar 8 02:00-03:00 local time does not exist; move it out of that hour`},
+		},
+		{
+			name:     "start of the skipped hour",
+			loc:      pacific,
+			schedule: span(sun, "TIME", at("02:00"), "SUNRISE", nil),
+			want:     []string{`schedule "Test" span 1 turns on at 02:00, but on Sun Mar 8 02:00-03:00 local time does not exist; move it out of that hour`},
+		},
+		{
+			name:     "in the repeated hour",
+			loc:      pacific,
+			schedule: span(sun, "SUNDOWN", nil, "TIME", at("01:15")),
+			want:     []string{`schedule "Test" span 1 turns off at 01:15, but on Sun Nov 1 01:00-02:00 local time happens twice; move it out of that hour`},
+		},
+		{name: "end of the skipped hour", loc: pacific, schedule: span(sun, "TIME", at("03:00"), "TIME", at("05:00"))},
+		{name: "other day", loc: pacific, schedule: span(mon, "TIME", at("02:30"), "TIME", at("01:30"))},
+		{name: "photocell edge", loc: pacific, schedule: span(sun, "SUNDOWN", at("02:30"), "SUNRISE", at("01:30"))},
+		{name: "no DST", loc: time.UTC, schedule: span(sun, "TIME", at("02:30"), "TIME", at("01:30"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &FullConfigurationData{Schedules: []FullConfigSchedule{tt.schedule}}
+			if got := dstWarnings(data, tt.loc, now); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpanMinute(t *testing.T) {
+	at := func(s string) *string { return &s }
+	tests := []struct {
+		trigger string
+		at      *string
+		want    int
+		wantOK  bool
+	}{
+		{"TIME", at("00:00"), 0, true},
+		{"TIME", at("23:59"), 23*60 + 59, true},
+		{"TIME", at("06:30:00"), 6*60 + 30, true},
+		{"TIME", nil, 0, false},
+		{"TIME", at("6:3"), 0, false},
+		{"SUNDOWN", at("18:00"), 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := spanMinute(tt.trigger, tt.at)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s %v: got %d %v, want %d %v", tt.trigger, tt.at, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestResolveOverlap(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2026, 11, 1, 1, 30, 0, 0, pacific) // 01:30 PDT
+	second := first.Add(time.Hour)                        // 01:30 PST
+	tests := []struct {
+		name string
+		t    time.Time
+		now  time.Time
+		want time.Time
+	}{
+		{"before the change", first, first.Add(-time.Minute), first},
+		{"after the change", first, second.Add(time.Minute), second},
+		{"not ambiguous", first.Add(-time.Hour), second, first.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		if got := resolveOverlap(tt.t, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
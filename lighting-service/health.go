@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// HealthReport is returned by GET /health.
+type HealthReport struct {
+	Status       string               `json:"status"` // "ok" or "degraded"
+	Time         time.Time            `json:"time"`
+	Simulation   bool                 `json:"simulation"`
+	PLCs         map[int]string       `json:"plcs"`
+	ConfigReload ConfigReloadStatus   `json:"config_reload"`
+	Photocell    *PhotocellForce      `json:"photocell_force,omitempty"`           // Set while C154 is forced
+	Releasing    []int                `json:"photocell_release_pending,omitempty"` // PLCs that may still have C155 set after a release
+	Link         *PhotocellLinkReport `json:"photocell_link,omitempty"`            // With two or more PLCs
+	Clocks       map[int]PLCClock     `json:"plc_clocks,omitempty"`                // Last clock check of each PLC
+	Drift        map[int]PLCDrift     `json:"drift,omitempty"`                     // Last drift check of each PLC
+	Alerts       []string             `json:"alerts,omitempty"`                    // Hardware problems noticed by the monitors
+	Warnings     []string             `json:"warnings,omitempty"`                  // Config problems from the last sync; not "degraded"
+}
+
+// health collects the service's own view of its state. It does not talk to the PLCs.
+func (app *App) health() HealthReport {
+	cfg := app.config()
+	report := HealthReport{
+		Status:       "ok",
+		Time:         time.Now(),
+		Simulation:   app.isSimulationMode(),
+		PLCs:         cfg.plcAddresses(),
+		ConfigReload: app.reloadStatus(),
+		Photocell:    app.photocell.Current(),
+		Releasing:    app.photocell.Pending(),
+		Clocks:       app.clocks.Clocks(),
+		Drift:        app.drift.Report(),
+	}
+	if len(report.Releasing) > 0 {
+		report.Alerts = append(report.Alerts, fmt.Sprintf("photocell force not yet released on PLCs %v; retrying every poll", report.Releasing))
+	}
+	for _, a := range app.photocellMonitor.Alerts() {
+		report.Alerts = append(report.Alerts, a.Message)
+	}
+	if link := app.photocellLink.Report(); !link.Checked.IsZero() {
+		report.Link = &link
+		for _, a := range link.Alerts {
+			report.Alerts = append(report.Alerts, a.Message)
+		}
+	}
+	report.Alerts = append(report.Alerts, app.clocks.Alerts(cfg)...)
+	report.Alerts = append(report.Alerts, app.drift.Alerts()...)
+	if w := app.scheduleWarnings.Load(); w != nil {
+		report.Warnings = *w
+	}
+	if !report.ConfigReload.OK || len(report.Alerts) > 0 {
+		report.Status = "degraded"
+	}
+	return report
+}
+
+// handleHealth reports service health. It answers 200 even when degraded so
+// monitoring can read the details; "status" says whether all is well.
+func (app *App) handleHealth(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.health())
+}
@@ -1,135 +1,228 @@
 package main
 
 import (
-	"encoding/json"
-        "fmt"
-	"log"
+	"context"
+	"fmt"
 	"net/http"
-	"strconv"
-        "sync"
-        "time"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 // App holds our application state, like the config.
 type App struct {
-	Config Config
-        simulatedState      map[string]bool
+	cfg                 Config // Guarded by configMutex; use app.config()
+	configPath          string // The file reloadConfig reads
+	configMutex         sync.RWMutex
+	lastReload          ConfigReloadStatus
+	sessions            *PLCSessions // One Modbus session per configured PLC
+	Audit               *AuditLog
+	Runtime             *RuntimeTracker
+	simulatedState      map[string]bool
 	simulatedStateMutex sync.RWMutex
+
+	// Shutdown: PLC work in progress is tracked so SIGTERM can drain it.
+	// hardStop is canceled shortly before the shutdown deadline, leaving a
+	// push time to roll back; pushes are waited for even past the deadline.
+	shutdownMutex  sync.Mutex
+	shuttingDown   bool
+	inflight       sync.WaitGroup
+	pushes         sync.WaitGroup
+	pushMutex      sync.Mutex // Held through a whole push or drift repair, so they never interleave
+	hardStop       context.Context
+	cancelHardStop context.CancelFunc
+
+	// pollProgress is when the status poller last went round its loop (unix
+	// nanoseconds); the systemd watchdog is only pinged while it moves.
+	pollProgress atomic.Int64
+
+	nonces nonceCache // Signed-request nonces seen recently
+
+	pulses *PulseQueues // Throttled per-PLC queues for ON/OFF requests
+
+	photocell        *PhotocellOverride    // Manual force of C154, if any
+	photocellMonitor *PhotocellMonitor     // History and health of C154
+	photocellLink    *PhotocellLinkMonitor // Master/slave agreement on C154
+	clocks           *ClockMonitor         // Last clock check of each PLC
+	drift            *DriftMonitor         // Last drift check of each PLC
+
+	scheduleWarnings atomic.Pointer[[]string] // DST warnings from the last sync
 }
 
 // isSimulationMode checks if PLC addresses are configured. If not, we're in sim mode.
 func (app *App) isSimulationMode() bool {
-	// Check if a PLC is configured. If not, we're in simulation mode.
-	if plc1Addr, ok := app.Config.PLCs[1]; !ok || plc1Addr == "" {
-		return true
-	}
-	return false
+	// Check if any PLC is configured. If not, we're in simulation mode.
+	return len(app.config().configuredPLCs()) == 0
 }
 
-// RunServer starts the main HTTP server.
-func (app *App) RunServer() error {
-	router := httprouter.New()
-	// Renamed handler to clarify it just *triggers* the sync now
-	router.POST("/sync", app.handleSyncTrigger)
-	router.POST("/override/zone/:id/:state", app.handleOverride)
-	router.GET("/status", app.handleStatus)
-        router.POST("/test/mapping/:id/:state", app.handleTestMapping)
-
-	// Use ListenPort from config
-	return http.ListenAndServe(app.Config.ListenPort, router)
+// RunServer starts the main HTTP server and runs until ctx is canceled.
+func (app *App) RunServer(ctx context.Context) error {
+	// Routes and their scopes live in routes.go.
+	router := app.newRouter()
+
+	cfg := app.config()
+	tlsCfg, err := buildTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: cfg.listenAddr(), Handler: withRequestID(router), TLSConfig: tlsCfg}
+	return app.serveUntil(ctx, server)
 }
 
 // handleSyncTrigger is triggered by WordPress when config changes.
 // It will fetch the *latest* config from WP and push it.
 func (app *App) handleSyncTrigger(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	log.Println("Received /sync trigger. Fetching latest config from WordPress API and pushing to PLCs.")
+	httpLog.InfoContext(r.Context(), "received /sync trigger, fetching latest config from WordPress", "caller", callerFromRequest(r))
+	full, err := queryBool(r.URL.Query(), "full")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit := newAuditEntry(r, "sync")
+
+	// Wait for a push or drift repair in progress before fetching the config,
+	// so this one writes the newest config on top of it. WordPress doesn't
+	// wait for the reply, so a sync turned away here would be lost.
+	simulated := app.isSimulationMode()
+	if !simulated {
+		done, err := app.beginPush()
+		if err != nil {
+			app.finishAudit(audit, nil, err)
+			writeError(w, r, err)
+			return
+		}
+		defer done()
+	}
 
 	// Fetch the full configuration from WordPress API
-	configData, err := FetchConfigurationFromAPI(app.Config) // NEW function call
+	configData, err := FetchConfigurationFromAPI(app.config()) // NEW function call
 	if err != nil {
-		log.Printf("Error fetching config from API: %v", err)
-		http.Error(w, "Failed to fetch config from WordPress", http.StatusInternalServerError)
+		app.finishAudit(audit, nil, err)
+		writeError(w, r, configUnavailable(err))
 		return
 	}
 
+	warnings := dstWarnings(configData, app.config().location(), time.Now())
+	for _, warning := range warnings {
+		syncLog.WarnContext(r.Context(), "schedule falls in a DST change", "warning", warning)
+	}
+	app.scheduleWarnings.Store(&warnings)
+
+	var writes []string
+	var results []PLCPushResult
 	// Translate the config into PLC data and push it.
-        if !app.isSimulationMode() {
-		log.Println("Pushing config to PLCs...")
+	if !simulated {
+		syncLog.InfoContext(r.Context(), "pushing config to PLCs")
 		// Translate the config into PLC data and push it.
-		err = PushConfigurationToPLCs(app.Config, configData) // Existing function call
+		writes, results, err = PushConfigurationToPLCs(app.hardStop, app.sessions, configData, full)
+		app.finishAudit(audit, writes, err)
 		if err != nil {
-			log.Printf("Error pushing config to PLCs: %v", err)
-			http.Error(w, "Failed to push config to PLCs", http.StatusInternalServerError)
+			writeCommandError(w, r, err, commandResponse{Writes: append([]string{}, writes...), Sync: results, Warnings: warnings})
 			return
 		}
 	} else {
-		log.Println("Simulation mode: Skipping PLC config push.")
+		syncLog.InfoContext(r.Context(), "simulation mode, skipping PLC config push")
+		app.finishAudit(audit, nil, nil)
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Sync successful."))
+	writeJSON(w, http.StatusOK, commandResponse{
+		OK:        true,
+		Simulated: app.isSimulationMode(),
+		Writes:    append([]string{}, writes...),
+		Warnings:  warnings,
+		Sync:      results,
+		RequestID: requestID(r.Context()),
+	})
 }
 
 // handleOverride needs the config to know which outputs to pulse.
 func (app *App) handleOverride(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	zoneID, _ := strconv.Atoi(ps.ByName("id"))
-	state := ps.ByName("state") // "on" or "off"
-	log.Printf("Received override request for Zone %d to state %s", zoneID, state)
+	zoneID, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	state, err := parseState(ps) // "on" or "off"
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpLog.InfoContext(r.Context(), "received override request", "zone", zoneID, "state", state, "caller", callerFromRequest(r))
+	audit := newAuditEntry(r, "override")
+	audit.ZoneID = zoneID
+	audit.State = state
 
 	// Fetch the config *each time* an override happens to ensure we have the latest mappings.
-	configData, err := FetchConfigurationFromAPI(app.Config)
+	configData, err := FetchConfigurationFromAPI(app.config())
 	if err != nil {
-		log.Printf("Error fetching config for override: %v", err)
-		http.Error(w, "Failed to fetch config for override", http.StatusInternalServerError)
+		app.finishAudit(audit, nil, err)
+		writeError(w, r, configUnavailable(err))
+		return
+	}
+	if !slices.ContainsFunc(configData.Zones, func(z FullConfigZone) bool { return z.ID == zoneID }) {
+		err := apiError(http.StatusNotFound, codeZoneNotFound, "zone %d not found", zoneID)
+		app.finishAudit(audit, nil, err)
+		writeError(w, r, err)
 		return
 	}
 
-        if app.isSimulationMode() {
+	var writes []string
+	if app.isSimulationMode() {
 		err = app.setSimulatedState(configData, zoneID, state)
+	} else if done, werr := app.beginPLCWork(); werr != nil {
+		err = werr
 	} else {
-		err = PulseZone(app.Config, configData, zoneID, state) // Pass configData
+		defer done()
+		writes, err = PulseZone(app.pulses, app.sessions, configData, zoneID, state) // Pass configData
 	}
+	app.finishAudit(audit, writes, err)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	app.writeCommandOK(w, r, writes)
 }
 
 // handleStatus needs the config to know which outputs/inputs to read.
+// The body is the flat status map the monitor page reads, e.g. {"PLC1-Y101": true, ...}.
 func (app *App) handleStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	//log.Println("Received /status request. Fetching config and polling PLCs.")
+	httpLog.DebugContext(r.Context(), "received /status request")
 
-        var status map[string]interface{}
+	var status map[string]interface{}
 	var err error
 
 	// --- Check for simulation mode ---
 	if app.isSimulationMode() {
-		log.Println("Simulation mode: Reading from in-memory state.")
+		httpLog.DebugContext(r.Context(), "simulation mode, reading from in-memory state")
 		status, err = app.getSimulatedState()
 	} else {
-		//log.Println("Live mode: Fetching config and polling PLCs.")
+		httpLog.DebugContext(r.Context(), "live mode, fetching config and polling PLCs")
 		// Fetch the config *each time* status is requested.
-		configData, err := FetchConfigurationFromAPI(app.Config)
-		if err != nil {
-			//log.Printf("Error fetching config for status: %v", err)
-			http.Error(w, "Failed to fetch config for status", http.StatusInternalServerError)
+		configData, ferr := FetchConfigurationFromAPI(app.config())
+		if ferr != nil {
+			writeError(w, r, configUnavailable(ferr))
 			return
 		}
-		status, err = ReadStatusFromPLCs(app.Config, configData) // Pass configData
+		status, err = app.readStatus(configData)
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	if force := app.photocell.Current(); force != nil {
+		status["PhotocellForced"] = force.State
+		status["PhotocellForcedUntil"] = force.Until
+	}
+	if pending := app.photocell.Pending(); len(pending) > 0 {
+		status["PhotocellReleasePending"] = pending
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	writeJSON(w, http.StatusOK, status)
 }
 
-
 // getSimulatedState reads from the internal map in a thread-safe way.
 func (app *App) getSimulatedState() (map[string]interface{}, error) {
 	app.simulatedStateMutex.RLock()         // Lock for reading
@@ -165,79 +258,66 @@ func (app *App) setSimulatedState(configData *FullConfigurationData, zoneID int,
 
 		if isForThisZone && len(mapping.PLCOutputs) > 0 {
 			outputToToggle := mapping.PLCOutputs[0] // Get the "ON" output
-                        plcID := mapping.PLCID
-			
-                        uniqueKey := fmt.Sprintf("PLC%d-%s", plcID, outputToToggle) // e.g., "PLC1-Y101"
-			app.simulatedStateMutex.Lock() // Lock for writing
+			plcID := mapping.PLCID
+
+			uniqueKey := fmt.Sprintf("PLC%d-%s", plcID, outputToToggle) // e.g., "PLC1-Y101"
+			app.simulatedStateMutex.Lock()                              // Lock for writing
 			if state == "on" {
 				app.simulatedState[uniqueKey] = true
 			} else {
 				app.simulatedState[uniqueKey] = false
 			}
-			log.Printf("SIMULATOR: Set %s = %t", outputToToggle, app.simulatedState[uniqueKey])
+			httpLog.Info("simulator set output", "output", uniqueKey, "on", app.simulatedState[uniqueKey])
 			app.simulatedStateMutex.Unlock() // Unlock
 		}
 	}
 	return nil
 }
 
-
-// startTimeSyncer runs a continuous loop to keep PLC clocks in sync.
-func (app *App) startTimeSyncer() {
-	if app.isSimulationMode() {
-		log.Println("Simulation mode: Skipping background time sync.")
+// handleTestMapping pulses a single mapping, for checking the wiring.
+func (app *App) handleTestMapping(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	mappingID, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-
-	log.Println("Starting background time sync service (runs every hour)...")
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
-
-	// Run once immediately on startup
-	app.syncAllPLCsTime()
-
-	// Run on every tick
-	for range ticker.C {
-		app.syncAllPLCsTime()
-	}
-}
-
-// syncAllPLCsTime iterates over all configured PLCs and sets their time.
-func (app *App) syncAllPLCsTime() {
-	log.Println("Running hourly time sync for all PLCs...")
-	for plcID, host := range app.Config.PLCs {
-		if host == "" {
-			continue // Skip unconfigured PLCs
-		}
-		log.Printf("Syncing time for PLC %d at %s...", plcID, host)
-		if err := SetPLCTime(host); err != nil {
-			// Just log the error, don't stop the service
-			log.Printf("ERROR: Failed to sync time for PLC %d: %v", plcID, err)
-		}
+	state, err := parseState(ps)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
-}
-
+	audit := newAuditEntry(r, "test")
+	audit.MappingID = mappingID
+	audit.State = state
 
-func (app *App) handleTestMapping(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	mappingID, _ := strconv.Atoi(ps.ByName("id"))
-	state := ps.ByName("state")
-	
 	// Fetch config to ensure we have latest mappings
-	configData, err := FetchConfigurationFromAPI(app.Config)
+	configData, err := FetchConfigurationFromAPI(app.config())
 	if err != nil {
-		http.Error(w, "Failed to fetch config", http.StatusInternalServerError)
+		app.finishAudit(audit, nil, err)
+		writeError(w, r, configUnavailable(err))
+		return
+	}
+	if !slices.ContainsFunc(configData.Mappings, func(m FullConfigMapping) bool { return m.ID == mappingID }) {
+		err := apiError(http.StatusNotFound, codeMappingNotFound, "mapping %d not found", mappingID)
+		app.finishAudit(audit, nil, err)
+		writeError(w, r, err)
 		return
 	}
 
+	var writes []string
 	if app.isSimulationMode() {
-		log.Println("Simulation: Test Pulse ignored.")
+		httpLog.InfoContext(r.Context(), "simulation mode, test pulse ignored", "mapping", mappingID)
+	} else if done, werr := app.beginPLCWork(); werr != nil {
+		err = werr
 	} else {
-		err = PulseMapping(app.Config, configData, mappingID, state)
+		defer done()
+		writes, err = PulseMapping(app.pulses, app.sessions, configData, mappingID, state)
 	}
+	app.finishAudit(audit, writes, err)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	app.writeCommandOK(w, r, writes)
 }
@@ -0,0 +1,796 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// lightctl is a command-line client for technicians at the panel. It is the
+// same binary as the service, run as "lightctl" (build.sh makes the symlink)
+// or as "lighting-service lightctl". It reads the service config for the PLC
+// addresses and the API key. status, pulse, zone, sync and image go through
+// the running service unless --direct is given; the register commands always
+// talk Modbus to the PLC. Direct writes are added to the audit log.
+
+const lightctlUsage = `usage: lightctl [flags] <command> [args]
+
+Commands:
+  status                               Live output, schedule and photocell bits
+  pulse --mapping ID on|off            Pulse one mapping to test the wiring
+  zone ID on|off                       Turn every light in a zone on or off
+  sync [--dry-run] [--full]            Push the changes in the WordPress config; --dry-run shows the whole
+                                       compiled config, --full rewrites all of it
+  dump-registers [--plc N] DS100:939   Read DS or SD registers
+  read-coils [--plc N] C101:24         Read C bits
+  set-time [--plc N]                   Set PLC clocks to the time in Timezone (all PLCs by default)
+  decode-schedule --slot N [--plc N]   Show the spans stored in schedule slot N (1-12)
+  image [--plc N]                      Decode every schedule slot and the map, and diff them against WordPress
+
+A range is FIRST:LAST, or FIRST:COUNT when the second number is smaller
+(DS100:939 is DS100-DS939, C101:24 is C101-C124).
+
+Flags may come before or after the command:
+`
+
+// lightctlArgs returns the arguments for lightctl if the binary was run as it.
+func lightctlArgs(args []string) ([]string, bool) {
+	if len(args) > 0 && filepath.Base(args[0]) == "lightctl" {
+		return args[1:], true
+	}
+	if len(args) > 1 && args[1] == "lightctl" {
+		return args[2:], true
+	}
+	return nil, false
+}
+
+// ctl holds the flags and connections shared by the lightctl commands.
+type ctl struct {
+	configPath string
+	service    string
+	json       bool
+	direct     bool
+	verbose    bool
+
+	cfg      Config
+	sessions *PLCSessions
+	out      io.Writer
+}
+
+var ctlCommands = map[string]func(c *ctl, args []string) error{
+	"status":          ctlStatus,
+	"pulse":           ctlPulse,
+	"zone":            ctlZone,
+	"sync":            ctlSync,
+	"dump-registers":  ctlDumpRegisters,
+	"read-coils":      ctlReadCoils,
+	"set-time":        ctlSetTime,
+	"decode-schedule": ctlDecodeSchedule,
+	"image":           ctlImage,
+}
+
+// runLightctl runs one command and returns the exit code: 0 on success, 1 if
+// the command failed and 2 for bad usage.
+func runLightctl(args []string) int {
+	c := &ctl{configPath: configFilePath, out: os.Stdout}
+	global := c.flagSet("lightctl")
+	if err := global.Parse(args); err != nil {
+		return usageExit(err)
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return 2
+	}
+	run, ok := ctlCommands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "lightctl: unknown command %q\n", global.Arg(0))
+		global.Usage()
+		return 2
+	}
+	err := run(c, global.Args()[1:])
+	var usage *usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usage):
+		fmt.Fprintf(os.Stderr, "lightctl %s: %v\n", global.Arg(0), err)
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "lightctl %s: %v\n", global.Arg(0), err)
+		return 1
+	}
+}
+
+func usageExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// usageError is a mistake in the command line rather than a failure.
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// flagSet returns a flag set with the flags every command accepts.
+func (c *ctl) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.configPath, "config", c.configPath, "service config file")
+	fs.StringVar(&c.service, "service", c.service, "URL of the running service (default from the config)")
+	fs.BoolVar(&c.json, "json", c.json, "print JSON instead of a table")
+	fs.BoolVar(&c.direct, "direct", c.direct, "talk Modbus to the PLCs instead of going through the service")
+	fs.BoolVar(&c.verbose, "v", c.verbose, "log Modbus and HTTP details to stderr")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), lightctlUsage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse reads the command's flags, which may follow its arguments, checks it
+// got want arguments, and loads the config.
+func (c *ctl) parse(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != want {
+		return nil, usagef("expected %d argument(s), got %d (see lightctl -h)", want, len(positional))
+	}
+
+	setupCLILogging(c.verbose)
+	cfg, err := loadConfig(c.configPath)
+	if err != nil {
+		return nil, err
+	}
+	c.cfg = cfg
+	if c.service == "" {
+		c.service = serviceURL(cfg)
+	}
+	c.sessions = NewPLCSessions(cfg.PLCs)
+	return positional, nil
+}
+
+// serviceURL is where the service listens, as seen from the same machine.
+func serviceURL(cfg Config) string {
+	host := cfg.BindAddress
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if cfg.TLSCertFile != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strings.TrimPrefix(cfg.ListenPort, ":"))
+}
+
+// caller names the technician in the audit log.
+func (c *ctl) caller() string {
+	user := cmp.Or(os.Getenv("SUDO_USER"), os.Getenv("USER"))
+	if user == "" {
+		return "lightctl"
+	}
+	return "lightctl:" + user
+}
+
+// call sends a signed request to the service and decodes the JSON answer.
+func (c *ctl) call(method, path string, body []byte, out any) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.service, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(nonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(signatureHeader, signRequest(c.cfg.WordPressAPIKey, method, req.URL.RequestURI(), timestamp, hex.EncodeToString(nonce), body))
+	req.Header.Set(callerHeader, c.caller())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	if c.cfg.TLSCertFile != "" {
+		// The service's certificate is usually self-signed.
+		pool, _ := x509.SystemCertPool()
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if pem, err := os.ReadFile(c.cfg.TLSCertFile); err == nil {
+			pool.AppendCertsFromPEM(pem)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	httpLog.Debug("calling service", "method", method, "url", req.URL)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach the service at %s (is it running? try --direct): %w", c.service, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Some failures (a sync) also fill in out; decode it too.
+		body, _ := io.ReadAll(resp.Body)
+		var e errorResponse
+		if json.Unmarshal(body, &e) == nil && e.Error.Code != "" {
+			json.Unmarshal(body, out)
+			return fmt.Errorf("%s: %s (request %s)", e.Error.Code, e.Error.Message, e.RequestID)
+		}
+		return fmt.Errorf("service answered %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// print writes v as JSON with --json, or as the table that fill writes.
+func (c *ctl) print(v any, fill func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fill(tw)
+	return tw.Flush()
+}
+
+func (c *ctl) printCommand(resp commandResponse) error {
+	return c.print(resp, func(w io.Writer) {
+		for _, write := range resp.Writes {
+			fmt.Fprintf(w, "wrote\t%s\n", write)
+		}
+		for _, r := range resp.Sync {
+			fmt.Fprintf(w, "PLC %d\t%s\n", r.PLC, r.summary())
+		}
+		for _, warning := range resp.Warnings {
+			fmt.Fprintf(w, "warning\t%s\n", warning)
+		}
+		if resp.Simulated {
+			fmt.Fprintln(w, "simulated\t(no PLC configured)")
+		}
+	})
+}
+
+// audit records a direct write, as the service does for its own.
+func (c *ctl) audit(entry AuditEntry, writes []string, err error) {
+	entry.Time = time.Now()
+	entry.Caller = c.caller()
+	entry.Writes = writes
+	entry.Outcome = "ok"
+	if err != nil {
+		entry.Outcome = "error"
+		entry.Error = err.Error()
+	}
+	NewAuditLog(c.cfg.AuditLogPath).Append(entry)
+}
+
+func (c *ctl) session(id int) (*PLCSession, error) {
+	session, ok := c.sessions.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("PLC %d has no address in %s", id, c.configPath)
+	}
+	return session, nil
+}
+
+func (c *ctl) fetchConfig() (*FullConfigurationData, error) {
+	data, err := FetchConfigurationFromAPI(c.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch the config from WordPress: %w", err)
+	}
+	return data, nil
+}
+
+func parseOnOff(s string) (string, error) {
+	if s != "on" && s != "off" {
+		return "", usagef("state %q must be on or off", s)
+	}
+	return s, nil
+}
+
+// --- Commands ---
+
+func ctlStatus(c *ctl, args []string) error {
+	if _, err := c.parse(c.flagSet("status"), args, 0); err != nil {
+		return err
+	}
+	var status map[string]any
+	if c.direct {
+		data, err := c.fetchConfig()
+		if err != nil {
+			return err
+		}
+		if status, err = ReadStatusFromPLCs(c.sessions, data); err != nil {
+			return err
+		}
+	} else if err := c.call("GET", apiV1+"/status", nil, &status); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(status))
+	for k := range status {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return c.print(status, func(w io.Writer) {
+		fmt.Fprintln(w, "KEY\tVALUE")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%v\n", k, status[k])
+		}
+	})
+}
+
+func ctlPulse(c *ctl, args []string) error {
+	fs := c.flagSet("pulse")
+	mappingID := fs.Int("mapping", 0, "mapping ID")
+	pos, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *mappingID < 1 {
+		return usagef("--mapping is required")
+	}
+	state, err := parseOnOff(pos[0])
+	if err != nil {
+		return err
+	}
+
+	var resp commandResponse
+	if !c.direct {
+		if err := c.call("POST", fmt.Sprintf("%s/mappings/%d/test/%s", apiV1, *mappingID, state), nil, &resp); err != nil {
+			return err
+		}
+		return c.printCommand(resp)
+	}
+	data, err := c.fetchConfig()
+	if err != nil {
+		return err
+	}
+	p, err := mappingPulse(c.sessions, data, *mappingID, state)
+	if err != nil {
+		return err
+	}
+	writes, err := c.pulses().Submit([]pulse{p})
+	c.audit(AuditEntry{Action: "test", MappingID: *mappingID, State: state}, writes, err)
+	if err != nil {
+		return err
+	}
+	return c.printCommand(commandResponse{OK: true, Writes: writes})
+}
+
+func ctlZone(c *ctl, args []string) error {
+	pos, err := c.parse(c.flagSet("zone"), args, 2)
+	if err != nil {
+		return err
+	}
+	zoneID, err := strconv.Atoi(pos[0])
+	if err != nil || zoneID < 1 {
+		return usagef("zone ID %q must be a positive number", pos[0])
+	}
+	state, err := parseOnOff(pos[1])
+	if err != nil {
+		return err
+	}
+
+	var resp commandResponse
+	if !c.direct {
+		if err := c.call("POST", fmt.Sprintf("%s/zones/%d/override/%s", apiV1, zoneID, state), nil, &resp); err != nil {
+			return err
+		}
+		return c.printCommand(resp)
+	}
+	data, err := c.fetchConfig()
+	if err != nil {
+		return err
+	}
+	pulses, err := zonePulses(c.sessions, data, zoneID, state)
+	if err != nil {
+		return err
+	}
+	writes, err := c.pulses().Submit(pulses)
+	c.audit(AuditEntry{Action: "override", ZoneID: zoneID, State: state}, writes, err)
+	if err != nil {
+		return err
+	}
+	return c.printCommand(commandResponse{OK: true, Writes: writes})
+}
+
+// pulses returns queues with the service's throttling, so a direct zone
+// command is as gentle on the relay supply as one sent through the service.
+func (c *ctl) pulses() *PulseQueues {
+	cfg := c.cfg
+	return NewPulseQueues(c.sessions, func() Config { return cfg })
+}
+
+// syncPlan is what a sync would write, for --dry-run.
+type syncPlan struct {
+	Slots    []syncPlanSlot   `json:"slots"`
+	Maps     map[int][]uint16 `json:"maps"` // PLC ID -> DS1000-DS1023 (slot per loop index)
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+type syncPlanSlot struct {
+	Slot       int              `json:"slot"`
+	Registers  string           `json:"registers"`
+	ScheduleID int              `json:"schedule_id,omitempty"`
+	Name       string           `json:"name,omitempty"`
+	Spans      []FullConfigSpan `json:"spans"` // Decoded back from the block, as the PLC will see them
+}
+
+func ctlSync(c *ctl, args []string) error {
+	fs := c.flagSet("sync")
+	dryRun := fs.Bool("dry-run", false, "show what would be written without writing it")
+	full := fs.Bool("full", false, "write every block and set C151 even if nothing changed")
+	if _, err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if !*dryRun && !c.direct {
+		var resp commandResponse
+		path := apiV1 + "/sync"
+		if *full {
+			path += "?full=true"
+		}
+		if err := c.call("POST", path, nil, &resp); err != nil {
+			if len(resp.Sync) > 0 {
+				c.printCommand(resp)
+			}
+			return err
+		}
+		return c.printCommand(resp)
+	}
+
+	data, err := c.fetchConfig()
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		writes, results, err := PushConfigurationToPLCs(context.Background(), c.sessions, data, *full)
+		c.audit(AuditEntry{Action: "sync"}, writes, err)
+		if perr := c.printCommand(commandResponse{OK: err == nil, Writes: writes, Sync: results}); err == nil {
+			err = perr
+		}
+		return err
+	}
+
+	blocks, maps := compileConfiguration(data)
+	plan := syncPlan{Maps: maps, Warnings: dstWarnings(data, c.cfg.location(), time.Now())}
+	for slot := 1; slot <= 12; slot++ {
+		first := int(scheduleIDToModbusAddress(slot)) + 1
+		s := syncPlanSlot{Slot: slot, Registers: fmt.Sprintf("DS%d-DS%d", first, first+69), Spans: []FullConfigSpan{}}
+		if block, ok := blocks[slot]; ok {
+			s.ScheduleID, s.Name = data.Schedules[slot-1].ID, data.Schedules[slot-1].ScheduleName
+			s.Spans = decodeScheduleBlock(block)
+		}
+		plan.Slots = append(plan.Slots, s)
+	}
+	return c.print(plan, func(w io.Writer) {
+		fmt.Fprintln(w, "SLOT\tREGISTERS\tSCHEDULE\tDAYS\tON\tOFF")
+		for _, s := range plan.Slots {
+			name := "(empty)"
+			if s.Name != "" {
+				name = fmt.Sprintf("%s (#%d)", s.Name, s.ScheduleID)
+			}
+			if len(s.Spans) == 0 {
+				fmt.Fprintf(w, "%d\t%s\t%s\t\t\t\n", s.Slot, s.Registers, name)
+			}
+			for i, span := range s.Spans {
+				if i > 0 {
+					fmt.Fprintf(w, "\t\t\t%s\t%s\t%s\n", daysLabel(span.DaysOfWeek), spanEdge(span.OnTrigger, span.OnTime), spanEdge(span.OffTrigger, span.OffTime))
+					continue
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", s.Slot, s.Registers, name, daysLabel(span.DaysOfWeek), spanEdge(span.OnTrigger, span.OnTime), spanEdge(span.OffTrigger, span.OffTime))
+			}
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "PLC\tDS1000-DS1023 (slot per loop index 0-23)")
+		ids := make([]int, 0, len(plan.Maps))
+		for id := range plan.Maps {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			fmt.Fprintf(w, "%d\t%v\n", id, plan.Maps[id])
+		}
+		for _, warning := range plan.Warnings {
+			fmt.Fprintf(w, "\nwarning: %s\n", warning)
+		}
+	})
+}
+
+// daysLabel lists a span's days, e.g. "mon,tue".
+func daysLabel(days []string) string {
+	if len(days) == 0 {
+		return "(no days)"
+	}
+	return strings.Join(days, ",")
+}
+
+// spanEdge shows one end of a span, e.g. "TIME 18:30" or "SUNDOWN".
+func spanEdge(trigger string, t *string) string {
+	if t == nil {
+		return trigger
+	}
+	return trigger + " " + *t
+}
+
+// maxAddress bounds each kind of address on the CLICK.
+var maxAddress = map[string]int{"DS": 4500, "SD": 1000, "C": 2000}
+
+// parseRange reads "DS100:939", "C101:24" or a single address like "SD23".
+func parseRange(spec string, kinds ...string) (registerRange, error) {
+	spec = strings.ToUpper(strings.TrimSpace(spec))
+	var r registerRange
+	for _, kind := range kinds {
+		if rest, ok := strings.CutPrefix(spec, kind); ok && rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+			r.Kind, spec = kind, rest
+			break
+		}
+	}
+	if r.Kind == "" {
+		return r, usagef("range %q must start with %s", spec, strings.Join(kinds, " or "))
+	}
+	firstStr, secondStr, hasSecond := strings.Cut(spec, ":")
+	first, err := strconv.Atoi(firstStr)
+	if err != nil || first < 1 {
+		return r, usagef("bad start address %q", firstStr)
+	}
+	r.First, r.Count = first, 1
+	if hasSecond {
+		second, err := strconv.Atoi(secondStr)
+		if err != nil || second < 1 {
+			return r, usagef("bad end or count %q", secondStr)
+		}
+		if second >= first {
+			r.Count = second - first + 1
+		} else {
+			r.Count = second
+		}
+	}
+	if last := r.First + r.Count - 1; last > maxAddress[r.Kind] {
+		return r, usagef("%s%d is past the last %s address (%d)", r.Kind, last, r.Kind, maxAddress[r.Kind])
+	}
+	return r, nil
+}
+
+type registerValue struct {
+	Address string `json:"address"`
+	Value   uint16 `json:"value"`
+}
+
+func ctlDumpRegisters(c *ctl, args []string) error {
+	fs := c.flagSet("dump-registers")
+	plcID := fs.Int("plc", 1, "PLC ID")
+	pos, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	r, err := parseRange(pos[0], "DS", "SD")
+	if err != nil {
+		return err
+	}
+	session, err := c.session(*plcID)
+	if err != nil {
+		return err
+	}
+	values, err := readRegisters(session, r)
+	if err != nil {
+		return err
+	}
+	rows := make([]registerValue, len(values))
+	for i, v := range values {
+		rows[i] = registerValue{Address: fmt.Sprintf("%s%d", r.Kind, r.First+i), Value: v}
+	}
+	return c.print(rows, func(w io.Writer) {
+		fmt.Fprintln(w, "ADDRESS\tDEC\tHEX")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s\t%d\t0x%04X\n", row.Address, row.Value, row.Value)
+		}
+	})
+}
+
+type coilValue struct {
+	Address string `json:"address"`
+	On      bool   `json:"on"`
+}
+
+func ctlReadCoils(c *ctl, args []string) error {
+	fs := c.flagSet("read-coils")
+	plcID := fs.Int("plc", 1, "PLC ID")
+	pos, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	r, err := parseRange(pos[0], "C")
+	if err != nil {
+		return err
+	}
+	session, err := c.session(*plcID)
+	if err != nil {
+		return err
+	}
+	var raw []byte
+	err = session.Do(func(client modbus.Client) error {
+		var err error
+		raw, err = client.ReadCoils(r.modbusAddress(r.First), uint16(r.Count))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	rows := make([]coilValue, r.Count)
+	for i := range rows {
+		rows[i] = coilValue{Address: fmt.Sprintf("C%d", r.First+i), On: i/8 < len(raw) && raw[i/8]&(1<<(i%8)) != 0}
+	}
+	return c.print(rows, func(w io.Writer) {
+		fmt.Fprintln(w, "ADDRESS\tSTATE")
+		for _, row := range rows {
+			state := "off"
+			if row.On {
+				state = "ON"
+			}
+			fmt.Fprintf(w, "%s\t%s\n", row.Address, state)
+		}
+	})
+}
+
+type setTimeResult struct {
+	PLC   int      `json:"plc"`
+	Clock PLCClock `json:"clock"` // Read back after setting
+}
+
+func ctlSetTime(c *ctl, args []string) error {
+	fs := c.flagSet("set-time")
+	plcID := fs.Int("plc", 0, "PLC ID (default all)")
+	if _, err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+	sessions := c.sessions.With(capClock)
+	if *plcID != 0 {
+		session, err := c.session(*plcID)
+		if err != nil {
+			return err
+		}
+		sessions = []*PLCSession{session}
+	}
+	if len(sessions) == 0 {
+		return fmt.Errorf("no PLC with the %s capability in %s", capClock, c.configPath)
+	}
+
+	loc := c.cfg.location()
+	var results []setTimeResult
+	var lastErr error
+	for _, session := range sessions {
+		err := SetPLCTime(session, time.Now().In(loc))
+		var writes []string
+		if err == nil {
+			writes = []string{fmt.Sprintf("PLC%d:SD29-SD35", session.ID)}
+		}
+		c.audit(AuditEntry{Action: "time"}, writes, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "PLC %d: %v\n", session.ID, err)
+			lastErr = err
+			continue
+		}
+		results = append(results, setTimeResult{PLC: session.ID, Clock: readClockCheck(session, loc)})
+	}
+	if err := c.print(results, func(w io.Writer) {
+		fmt.Fprintln(w, "PLC\tTIME\tDRIFT\tDAY OF WEEK")
+		for _, res := range results {
+			if res.Clock.Time == nil {
+				fmt.Fprintf(w, "%d\tset, but could not read back: %s\t\t\n", res.PLC, res.Clock.Error)
+				continue
+			}
+			day := "ok"
+			if !res.Clock.WeekdayOK {
+				day = fmt.Sprintf("wrong (%d)", res.Clock.Weekday)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%ds\t%s\n", res.PLC, res.Clock.Time.Format("Mon 2006-01-02 15:04:05 MST"), res.Clock.DriftSeconds, day)
+		}
+	}); err != nil {
+		return err
+	}
+	return lastErr
+}
+
+type decodedSchedule struct {
+	PLC       int              `json:"plc"`
+	Slot      int              `json:"slot"`
+	Registers string           `json:"registers"`
+	Spans     []FullConfigSpan `json:"spans"`
+}
+
+func ctlDecodeSchedule(c *ctl, args []string) error {
+	fs := c.flagSet("decode-schedule")
+	slot := fs.Int("slot", 0, "schedule slot 1-12")
+	plcID := fs.Int("plc", 1, "PLC ID")
+	if _, err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *slot < 1 || *slot > 12 {
+		return usagef("--slot must be 1-12")
+	}
+	session, err := c.session(*plcID)
+	if err != nil {
+		return err
+	}
+	first := int(scheduleIDToModbusAddress(*slot)) + 1
+	block, err := readRegisters(session, registerRange{Kind: "DS", First: first, Count: 70})
+	if err != nil {
+		return err
+	}
+	out := decodedSchedule{PLC: *plcID, Slot: *slot, Registers: fmt.Sprintf("DS%d-DS%d", first, first+69), Spans: decodeScheduleBlock(block)}
+	return c.print(out, func(w io.Writer) {
+		fmt.Fprintf(w, "PLC %d slot %d (%s)\n", out.PLC, out.Slot, out.Registers)
+		if len(out.Spans) == 0 {
+			fmt.Fprintln(w, "no spans")
+			return
+		}
+		fmt.Fprintln(w, "SPAN\tDAYS\tON\tOFF")
+		for i, span := range out.Spans {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, daysLabel(span.DaysOfWeek), spanEdge(span.OnTrigger, span.OnTime), spanEdge(span.OffTrigger, span.OffTime))
+		}
+	})
+}
+
+func ctlImage(c *ctl, args []string) error {
+	fs := c.flagSet("image")
+	plcID := fs.Int("plc", 1, "PLC ID")
+	if _, err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+	var out DecodedImage
+	if c.direct {
+		session, err := c.session(*plcID)
+		if err != nil {
+			return err
+		}
+		if !session.Can(capSchedules) {
+			return fmt.Errorf("PLC %d does not have the %s capability", *plcID, capSchedules)
+		}
+		img, err := readPLCImage(session)
+		if err != nil {
+			return err
+		}
+		data, err := c.fetchConfig()
+		out = decodeImage(*plcID, img, data)
+		if err != nil {
+			out.ConfigError = err.Error()
+		}
+	} else if err := c.call("GET", fmt.Sprintf("%s/plcs/%d/image", apiV1, *plcID), nil, &out); err != nil {
+		return err
+	}
+	return c.print(out, func(w io.Writer) { printDecodedImage(w, out) })
+}
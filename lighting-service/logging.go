@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Subsystem loggers. They are rebound by setupLogging once the config is loaded;
+// until then they write plain text to stderr.
+var (
+	mainLog      = slog.Default().With("subsystem", "main")
+	httpLog      = slog.Default().With("subsystem", "http")
+	modbusLog    = slog.Default().With("subsystem", "modbus")
+	syncLog      = slog.Default().With("subsystem", "sync")
+	auditLog     = slog.Default().With("subsystem", "audit")
+	runtimeLog   = slog.Default().With("subsystem", "runtime")
+	photocellLog = slog.Default().With("subsystem", "photocell")
+)
+
+// logSubsystems lists the names accepted in LogLevels and by /admin/loglevel.
+var logSubsystems = []string{"main", "http", "modbus", "sync", "audit", "runtime", "photocell"}
+
+// logLevels holds the default level plus optional per-subsystem overrides.
+// They can be changed at runtime without rebuilding any loggers.
+type logLevels struct {
+	mu   sync.RWMutex
+	def  slog.Level
+	subs map[string]slog.Level
+}
+
+var levels = &logLevels{def: slog.LevelInfo, subs: make(map[string]slog.Level)}
+
+func (l *logLevels) level(subsystem string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if lvl, ok := l.subs[subsystem]; ok {
+		return lvl
+	}
+	return l.def
+}
+
+func (l *logLevels) set(subsystem string, lvl slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if subsystem == "" {
+		l.def = lvl
+		return
+	}
+	l.subs[subsystem] = lvl
+}
+
+func (l *logLevels) clear(subsystem string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.subs, subsystem)
+}
+
+func (l *logLevels) snapshot() map[string]string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	out := map[string]string{"default": strings.ToLower(l.def.String())}
+	for sub, lvl := range l.subs {
+		out[sub] = strings.ToLower(lvl.String())
+	}
+	return out
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return lvl, err
+}
+
+// subsystemHandler filters records by the level configured for the logger's
+// "subsystem" attribute before passing them to the real handler.
+type subsystemHandler struct {
+	next      slog.Handler
+	subsystem string
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= levels.level(h.subsystem)
+}
+
+// Handle adds the request ID (see withRequestID) to lines logged with a request's context.
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sub := h.subsystem
+	for _, a := range attrs {
+		if a.Key == "subsystem" {
+			sub = a.Value.String()
+		}
+	}
+	return &subsystemHandler{next: h.next.WithAttrs(attrs), subsystem: sub}
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return &subsystemHandler{next: h.next.WithGroup(name), subsystem: h.subsystem}
+}
+
+// setupLogging builds the slog handler from the config and rebinds the
+// subsystem loggers. The standard "log" package is routed through it too.
+func setupLogging(cfg Config) {
+	applyLogLevels(cfg)
+
+	// If LogFilePath is "stdout" or empty, log to console only.
+	var out io.Writer = os.Stdout
+	var fileErr error
+	if cfg.LogFilePath != "" && cfg.LogFilePath != "stdout" {
+		rf, err := newRotatingFile(cfg.LogFilePath, int64(cfg.LogMaxSizeMB)*1024*1024, cfg.LogMaxBackups, cfg.LogRotateDaily)
+		if err != nil {
+			fileErr = err
+		} else {
+			out = io.MultiWriter(os.Stdout, rf)
+		}
+	}
+
+	// Level filtering is done by subsystemHandler, so the inner handler lets everything through.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var base slog.Handler
+	if strings.EqualFold(cfg.LogFormat, "json") {
+		base = slog.NewJSONHandler(out, opts)
+	} else {
+		base = slog.NewTextHandler(out, opts)
+	}
+	bindLoggers(slog.New(&subsystemHandler{next: base}))
+
+	if fileErr != nil {
+		mainLog.Error("could not open log file, logging to standard output only", "path", cfg.LogFilePath, "err", fileErr)
+	}
+}
+
+// setupCLILogging sends the subsystem loggers to stderr for lightctl: warnings
+// and errors only, or everything with verbose.
+func setupCLILogging(verbose bool) {
+	levels.set("", slog.LevelWarn)
+	if verbose {
+		levels.set("", slog.LevelDebug)
+	}
+	bindLoggers(slog.New(&subsystemHandler{next: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})}))
+}
+
+func bindLoggers(root *slog.Logger) {
+	slog.SetDefault(root)
+	mainLog = root.With("subsystem", "main")
+	httpLog = root.With("subsystem", "http")
+	modbusLog = root.With("subsystem", "modbus")
+	syncLog = root.With("subsystem", "sync")
+	auditLog = root.With("subsystem", "audit")
+	runtimeLog = root.With("subsystem", "runtime")
+	photocellLog = root.With("subsystem", "photocell")
+}
+
+// applyLogLevels replaces the current levels with the ones in the config.
+// Invalid entries are skipped; validateConfig reports them.
+func applyLogLevels(cfg Config) {
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+	levels.def = slog.LevelInfo
+	if lvl, err := parseLevel(cfg.LogLevel); err == nil {
+		levels.def = lvl
+	}
+	levels.subs = make(map[string]slog.Level)
+	for sub, s := range cfg.LogLevels {
+		if lvl, err := parseLevel(s); err == nil {
+			levels.subs[sub] = lvl
+		}
+	}
+}
+
+// --- Rotation ---
+
+// rotatingFile is an io.Writer that rolls the log over when it passes maxSize
+// bytes or (optionally) when the local date changes. Old files are kept as
+// path.1 (newest) .. path.N (oldest).
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	daily   bool
+	file    *os.File
+	size    int64
+	day     string
+}
+
+func newRotatingFile(path string, maxSize int64, backups int, daily bool) (*rotatingFile, error) {
+	// The default path starts with "~/", which the OS won't expand for us.
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	if backups <= 0 {
+		backups = 5
+	}
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups, daily: daily}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.day = info.ModTime().Format("2006-01-02")
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	today := time.Now().Format("2006-01-02")
+	needRotate := (rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize) || (rf.daily && today != rf.day)
+	if needRotate && rf.size > 0 {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	rf.day = today
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	// Shift path.(N-1) -> path.N ... path -> path.1; the oldest falls off the end.
+	for i := rf.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return err // Keep writing to the old file rather than losing log lines
+	}
+	// The old file stays open until the new one is, so a failure leaves the
+	// log going to path.1 instead of a closed file. Its size is reset so the
+	// next try comes at the next rollover rather than on every line.
+	old := rf.file
+	if err := rf.open(); err != nil {
+		rf.size = 0
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// --- Admin endpoint ---
+
+// handleGetLogLevel returns the default and per-subsystem log levels.
+func (app *App) handleGetLogLevel(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"levels":     levels.snapshot(),
+		"subsystems": logSubsystems,
+	})
+}
+
+type logLevelRequest struct {
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem"`
+}
+
+// handleSetLogLevel changes a log level at runtime.
+// Body: {"level":"debug","subsystem":"modbus"}. Omit subsystem to change the default;
+// send "level":"default" for a subsystem to drop its override.
+func (app *App) handleSetLogLevel(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "invalid JSON body: %v", err))
+		return
+	}
+	if req.Subsystem != "" && !slices.Contains(logSubsystems, req.Subsystem) {
+		writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "unknown subsystem %q", req.Subsystem))
+		return
+	}
+
+	if req.Subsystem != "" && strings.EqualFold(req.Level, "default") {
+		levels.clear(req.Subsystem)
+	} else {
+		lvl, err := parseLevel(req.Level)
+		if err != nil {
+			writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "invalid level %q (want debug, info, warn or error)", req.Level))
+			return
+		}
+		levels.set(req.Subsystem, lvl)
+	}
+	mainLog.WarnContext(r.Context(), "log level changed", "target", req.Subsystem, "level", req.Level, "caller", callerFromRequest(r))
+	app.handleGetLogLevel(w, r, nil)
+}
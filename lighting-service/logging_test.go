@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+	rf, err := newRotatingFile(path, 10, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rf.file.Close() })
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("write %q: %v", line, err)
+		}
+	}
+	// Each file holds what fits in 10 bytes; "one\ntwo\n" fell off the end.
+	want := map[string]string{path: "six\n", path + ".1": "four\nfive\n", path + ".2": "three\n"}
+	for name, content := range want {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s holds %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept a third backup: %v", err)
+	}
+}
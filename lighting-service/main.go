@@ -1,76 +1,95 @@
 package main
 
 import (
-	"encoding/json"
-        "io"
-        "io/ioutil" // Use ioutil for simple file reading
-	"log"
-        "os"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-// Config struct holds all our settings.
-type Config struct {
-	ListenPort string         `json:"ListenPort"`
-        LogFilePath         string            `json:"LogFilePath"`
-	PLCs       map[int]string `json:"PLCs"`
-        WordPressAPIKey     string         `json:"WordPressAPIKey"`
-	WordPressAPIBaseURL string         `json:"WordPressAPIBaseURL"`
-}
-
-const configFilePath = "/var/lib/fsbhoa/lighting_service.json"
-
 func main() {
-	log.Println("Starting FSBHOA Lighting Service...")
+	// Run as "lightctl" (or "lighting-service lightctl") for the technician's CLI.
+	if args, ok := lightctlArgs(os.Args); ok {
+		os.Exit(runLightctl(args))
+	}
 
 	// --- Load Configuration from JSON file ---
-        cfg := Config{ 
-            ListenPort: ":8085", 
-            LogFilePath: "~/fsbhoa_light/lighting-service/lighting-service.log",
-            PLCs: make(map[int]string),
-        }
-        configData, err := ioutil.ReadFile(configFilePath)
-	if err != nil {
-		log.Printf("WARNING: Could not read config file '%s': %v. Using defaults.", configFilePath, err)
-	} else {
-		err = json.Unmarshal(configData, &cfg)
-		if err != nil {
-	             log.Printf("WARNING: Could not parse config file '%s': %v. Using defaults.", configFilePath, err)
-		     // Reset cfg to defaults if JSON parsing fails to avoid partial config
-                     cfg = Config{
-                         ListenPort: ":8085", 
-                         LogFilePath: "~/fsbhoa_light/lighting-service/lighting-service.log",
-                         PLCs: make(map[int]string),
-                     }
-		}
-	}
-        // --- CONFIGURE THE LOGGER BASED ON THE CONFIG ---
-	// If LogFilePath is "stdout" or empty, log to console (which is the default)
-	if cfg.LogFilePath != "" && cfg.LogFilePath != "stdout" {
-		logFile, err := os.OpenFile(cfg.LogFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
-		if err != nil {
-			log.Printf("ERROR: could not open log file '%s': %v", cfg.LogFilePath, err)
-			log.Println("Logging to standard output instead.")
-		} else {
-			// Set the logger to write to both the file and standard output
-			mw := io.MultiWriter(os.Stdout, logFile)
-			log.SetOutput(mw)
-		}
+	// Problems are reported once the logger is configured from the (default) config.
+	cfg, configErr := loadConfig(configFilePath)
+	if configErr != nil {
+		// Fall back to defaults rather than running on a partial config
+		cfg = defaultConfig()
 	}
 
-	log.Printf("Loaded configuration: %+v", cfg) // Log the loaded config
+	// --- CONFIGURE THE LOGGER BASED ON THE CONFIG ---
+	setupLogging(cfg)
+	mainLog.Info("starting FSBHOA Lighting Service")
+	if configErr != nil {
+		mainLog.Warn("using default configuration", "path", configFilePath, "err", configErr)
+	}
+	// Don't log the whole struct; it contains the API key.
+	mainLog.Info("loaded configuration",
+		"listen_addr", cfg.listenAddr(),
+		"tls", cfg.TLSCertFile != "",
+		"mtls", cfg.TLSClientCAFile != "",
+		"plcs", cfg.plcAddresses(),
+		"wordpress_url", cfg.WordPressAPIBaseURL,
+		"log_level", cfg.LogLevel,
+		"log_levels", cfg.LogLevels,
+		"log_file", cfg.LogFilePath,
+		"auth_read", cfg.AuthReadMode,
+		"auth_control", cfg.AuthControlMode)
+	if cfg.WordPressAPIKey == "" && (cfg.AuthReadMode != "none" || cfg.AuthControlMode != "none") {
+		mainLog.Warn("no API key configured; authenticated routes will reject every request")
+	}
 
 	// --- Start the HTTP Server ---
-        app := &App{
-		Config:         cfg,
+	app := &App{
+		cfg:            cfg,
+		configPath:     configFilePath,
+		sessions:       NewPLCSessions(cfg.PLCs),
+		Audit:          NewAuditLog(cfg.AuditLogPath),
+		Runtime:        NewRuntimeTracker(cfg.RuntimeFilePath),
 		simulatedState: make(map[string]bool), // Initialize the state map
 		// The mutex is fine with its zero-value
 	}
+	startup := ConfigReloadStatus{Time: time.Now(), Trigger: "startup", OK: configErr == nil}
+	if configErr != nil {
+		startup.Error = configErr.Error()
+	}
+	app.setReloadStatus(startup)
+	app.pulses = NewPulseQueues(app.sessions, app.config)
+	app.photocell = NewPhotocellOverride(cfg.PhotocellForcePath)
+	app.photocellMonitor = NewPhotocellMonitor()
+	app.photocellLink = NewPhotocellLinkMonitor()
+	app.clocks = NewClockMonitor()
+	app.drift = NewDriftMonitor()
+	app.hardStop, app.cancelHardStop = context.WithCancel(context.Background())
+
+	// systemd sends SIGTERM on stop/restart; Ctrl-C when run by hand.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Pick up config changes from WordPress without a restart.
+	go app.watchConfig()
+
+	// The PLCs set their clocks with NTP; this only checks them, and corrects
+	// them if ClockCorrectSeconds is set.
+	go app.startClockMonitor(ctx)
+
+	// Compare the PLC registers with the WordPress config, and repair them if
+	// DriftRepair is set.
+	go app.startDriftMonitor(ctx)
 
-        // Since the PLC has nstp service, we no longer need to force the time.
-        //go app.startTimeSyncer()
+	// Sample relay states in the background for the runtime-hours report.
+	go app.startStatusPoller(ctx)
+	go app.runWatchdog(ctx)
+	go app.restorePhotocell()
 
-	log.Printf("Starting HTTP server on %s...", cfg.ListenPort)
-	if err := app.RunServer(); err != nil { // Use ListenPort from config
-		log.Fatalf("Could not start server: %v", err)
+	mainLog.Info("starting HTTP server", "addr", cfg.listenAddr())
+	if err := app.RunServer(ctx); err != nil {
+		mainLog.Error("could not start server", "err", err)
+		os.Exit(1)
 	}
 }
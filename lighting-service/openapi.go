@@ -0,0 +1,209 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// buildOpenAPI generates an OpenAPI 3.0 document from the route table.
+// Response schemas come from the Go types the handlers encode, so they follow
+// the structs without being kept up to date by hand.
+func buildOpenAPI(routes []route) map[string]any {
+	paths := map[string]map[string]any{}
+	add := func(rt route, path string, deprecated bool) {
+		p := openAPIPath(path)
+		if paths[p] == nil {
+			paths[p] = map[string]any{}
+		}
+		paths[p][strings.ToLower(rt.Method)] = operation(rt, path, deprecated)
+	}
+	for _, rt := range routes {
+		add(rt, rt.Path, false)
+		if rt.Alias != "" {
+			add(rt, rt.Alias, true)
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "FSBHOA Lighting Service",
+			"version":     "1",
+			"description": "Controls the community lighting PLCs. Routes outside /api/v1 are the original paths, kept for existing callers.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Error": schemaFor(reflect.TypeOf(errorResponse{})),
+			},
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"bearer": map[string]any{"type": "http", "scheme": "bearer", "description": "A named API token from APITokens in the service config."},
+				"hmac": map[string]any{
+					"type": "apiKey", "in": "header", "name": signatureHeader,
+					"description": "Hex HMAC-SHA256, keyed with the shared key, of METHOD\\nURI\\nTIMESTAMP\\nNONCE\\nhex(SHA256(body)). Also send " + timestampHeader + " and " + nonceHeader + ".",
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath turns /zones/:id into /zones/{id}.
+func openAPIPath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if name, ok := strings.CutPrefix(seg, ":"); ok {
+			segs[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func operation(rt route, path string, deprecated bool) map[string]any {
+	op := map[string]any{
+		"summary":     rt.Summary,
+		"operationId": operationID(rt.Method, path),
+		"tags":        []string{rt.Tag},
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": "OK",
+				"content":     map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(rt.Response))}},
+			},
+			"default": map[string]any{
+				"description": "Error",
+				"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}}},
+			},
+		},
+	}
+	if deprecated {
+		op["deprecated"] = true
+		op["description"] = "Use " + rt.Method + " " + openAPIPath(rt.Path) + "."
+	}
+	if rt.Scope == "" {
+		op["security"] = []any{}
+	} else {
+		op["x-scope"] = rt.Scope
+		op["security"] = []any{
+			map[string][]string{"apiKey": {}},
+			map[string][]string{"bearer": {}},
+			map[string][]string{"hmac": {}},
+		}
+	}
+
+	var params []any
+	for _, name := range pathParams(path) {
+		schema := map[string]any{"type": "string"}
+		switch name {
+		case "id":
+			schema = map[string]any{"type": "integer", "minimum": 1}
+		case "state":
+			schema = map[string]any{"type": "string", "enum": []string{"on", "off"}}
+		}
+		params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": schema})
+	}
+	for _, q := range rt.Query {
+		schema := map[string]any{"type": q.Type}
+		if q.Type == "date-time" {
+			schema = map[string]any{"type": "string", "format": "date-time"}
+		}
+		params = append(params, map[string]any{"name": q.Name, "in": "query", "description": q.Description, "schema": schema})
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+
+	if rt.Body != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(rt.Body))}},
+		}
+	}
+	return op
+}
+
+// operationID builds e.g. getApiV1ZonesById from GET /api/v1/zones/:id.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(seg, ":"); ok {
+			seg = "by_" + name
+		}
+		for _, word := range strings.FieldsFunc(seg, func(r rune) bool { return r == '_' || r == '.' || r == '-' }) {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor describes a Go type as a JSON schema, following encoding/json's rules
+// for field names, omitempty, "-" and embedded structs.
+func schemaFor(t reflect.Type) map[string]any {
+	if t == nil {
+		return map[string]any{}
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaFor(t.Elem())
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		addStructFields(t, props, &required)
+		s := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default: // interface{}
+		return map[string]any{}
+	}
+}
+
+func addStructFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addStructFields(f.Type, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxOverrideTargets caps one bulk override; the whole community has far fewer zones.
+const maxOverrideTargets = 100
+
+// overrideRequest is the body of POST /api/v1/overrides, e.g.
+// {"targets":[{"zone_id":3,"state":"off"},{"mapping_id":12,"state":"on"}]}.
+type overrideRequest struct {
+	Targets []overrideTarget `json:"targets"`
+}
+
+// overrideTarget names exactly one zone or mapping.
+type overrideTarget struct {
+	ZoneID    int    `json:"zone_id,omitempty"`
+	MappingID int    `json:"mapping_id,omitempty"`
+	State     string `json:"state"` // "on" or "off"
+}
+
+type overrideResult struct {
+	ZoneID    int          `json:"zone_id,omitempty"`
+	MappingID int          `json:"mapping_id,omitempty"`
+	State     string       `json:"state"`
+	OK        bool         `json:"ok"`
+	Writes    []string     `json:"writes"`
+	Error     *errorDetail `json:"error,omitempty"`
+}
+
+type overridesResponse struct {
+	OK        bool             `json:"ok"` // Every target succeeded
+	Simulated bool             `json:"simulated,omitempty"`
+	Results   []overrideResult `json:"results"` // One per target, in request order
+	RequestID string           `json:"request_id"`
+}
+
+func (t overrideTarget) validate() error {
+	if (t.ZoneID > 0) == (t.MappingID > 0) {
+		return fmt.Errorf("give exactly one positive zone_id or mapping_id")
+	}
+	if t.State != "on" && t.State != "off" {
+		return fmt.Errorf("state %q must be \"on\" or \"off\"", t.State)
+	}
+	return nil
+}
+
+// targetPulses looks up the pulses for one target in the config.
+func (app *App) targetPulses(data *FullConfigurationData, t overrideTarget) ([]pulse, error) {
+	if t.ZoneID > 0 {
+		if !slices.ContainsFunc(data.Zones, func(z FullConfigZone) bool { return z.ID == t.ZoneID }) {
+			return nil, apiError(http.StatusNotFound, codeZoneNotFound, "zone %d not found", t.ZoneID)
+		}
+		return zonePulses(app.sessions, data, t.ZoneID, t.State)
+	}
+	if !slices.ContainsFunc(data.Mappings, func(m FullConfigMapping) bool { return m.ID == t.MappingID }) {
+		return nil, apiError(http.StatusNotFound, codeMappingNotFound, "mapping %d not found", t.MappingID)
+	}
+	p, err := mappingPulse(app.sessions, data, t.MappingID, t.State)
+	if err != nil {
+		return nil, err
+	}
+	return []pulse{p}, nil
+}
+
+// handleOverrides switches several zones and mappings in one request. The
+// config is fetched once and every pulse goes through the per-PLC queues in a
+// single all-or-nothing admission, so a throttled request changes nothing.
+// Targets that don't exist fail on their own without stopping the rest.
+// If two targets share a light, the later one's state wins.
+func (app *App) handleOverrides(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req overrideRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "invalid JSON body: %v", err))
+		return
+	}
+	if len(req.Targets) == 0 || len(req.Targets) > maxOverrideTargets {
+		writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "targets must list 1 to %d zones or mappings", maxOverrideTargets))
+		return
+	}
+	for i, t := range req.Targets {
+		if err := t.validate(); err != nil {
+			writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "targets[%d]: %v", i, err))
+			return
+		}
+	}
+	httpLog.InfoContext(r.Context(), "received bulk override request", "targets", len(req.Targets), "caller", callerFromRequest(r))
+
+	audits := make([]AuditEntry, len(req.Targets))
+	results := make([]overrideResult, len(req.Targets))
+	for i, t := range req.Targets {
+		audits[i] = newAuditEntry(r, "override")
+		audits[i].ZoneID, audits[i].MappingID, audits[i].State = t.ZoneID, t.MappingID, t.State
+		results[i] = overrideResult{ZoneID: t.ZoneID, MappingID: t.MappingID, State: t.State, Writes: []string{}}
+	}
+	failAll := func(err error) {
+		for _, a := range audits {
+			app.finishAudit(a, nil, err)
+		}
+		writeError(w, r, err)
+	}
+
+	configData, err := FetchConfigurationFromAPI(app.config())
+	if err != nil {
+		failAll(configUnavailable(err))
+		return
+	}
+
+	// Collect every target's pulses; owner[i] is the target of pulses[i].
+	var pulses []pulse
+	var owner []int
+	errs := make([]error, len(req.Targets))
+	for i, t := range req.Targets {
+		if app.isSimulationMode() {
+			errs[i] = app.simulateOverride(configData, t)
+			continue
+		}
+		ps, err := app.targetPulses(configData, t)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		for _, p := range ps {
+			pulses = append(pulses, p)
+			owner = append(owner, i)
+		}
+	}
+
+	if len(pulses) > 0 {
+		done, err := app.beginPLCWork()
+		if err != nil {
+			failAll(err)
+			return
+		}
+		defer done()
+		pulseResults, err := app.pulses.SubmitEach(pulses)
+		if err != nil {
+			failAll(err)
+			return
+		}
+		for j, res := range pulseResults {
+			i := owner[j]
+			if res.err != nil {
+				errs[i] = res.err
+				continue
+			}
+			results[i].Writes = append(results[i].Writes, res.write)
+		}
+	}
+
+	resp := overridesResponse{OK: true, Simulated: app.isSimulationMode(), Results: results, RequestID: requestID(r.Context())}
+	for i := range results {
+		app.finishAudit(audits[i], results[i].Writes, errs[i])
+		if errs[i] != nil {
+			ae := toAPIError(errs[i])
+			results[i].Error = &errorDetail{Code: ae.Code, Message: ae.Message}
+			resp.OK = false
+			continue
+		}
+		results[i].OK = true
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// simulateOverride applies one target to the simulator's outputs.
+func (app *App) simulateOverride(configData *FullConfigurationData, t overrideTarget) error {
+	if t.ZoneID > 0 {
+		if !slices.ContainsFunc(configData.Zones, func(z FullConfigZone) bool { return z.ID == t.ZoneID }) {
+			return apiError(http.StatusNotFound, codeZoneNotFound, "zone %d not found", t.ZoneID)
+		}
+		return app.setSimulatedState(configData, t.ZoneID, t.State)
+	}
+	i := slices.IndexFunc(configData.Mappings, func(m FullConfigMapping) bool { return m.ID == t.MappingID })
+	if i < 0 {
+		return apiError(http.StatusNotFound, codeMappingNotFound, "mapping %d not found", t.MappingID)
+	}
+	m := configData.Mappings[i]
+	if len(m.PLCOutputs) == 0 {
+		return apiError(http.StatusUnprocessableEntity, codeMappingHasNoOutputs, "mapping %d has no outputs defined", m.ID)
+	}
+	app.simulatedStateMutex.Lock()
+	app.simulatedState[fmt.Sprintf("PLC%d-%s", m.PLCID, m.PLCOutputs[0])] = t.State == "on"
+	app.simulatedStateMutex.Unlock()
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHandleOverrides(t *testing.T) {
+	data := &FullConfigurationData{
+		Zones: []FullConfigZone{{ID: 1, ZoneName: "Lodge"}, {ID: 2, ZoneName: "Tennis"}, {ID: 3, ZoneName: "Cabana"}},
+		Mappings: []FullConfigMapping{
+			{ID: 11, PLCID: 1, PLCOutputs: []string{"Y101"}, LinkedZoneIDs: []int{1}}, // Loop 0: C201/C251
+			{ID: 12, PLCID: 1, PLCOutputs: []string{"Y203"}, LinkedZoneIDs: []int{1}}, // Loop 9: C210/C260
+			{ID: 13, PLCID: 1, PLCOutputs: []string{"Y105"}},                          // Loop 2: C203/C253
+			{ID: 14, PLCID: 1},
+			{ID: 21, PLCID: 9, PLCOutputs: []string{"Y101"}, LinkedZoneIDs: []int{2}},
+			{ID: 31, PLCID: 2, PLCOutputs: []string{"Y101"}, LinkedZoneIDs: []int{3}},
+		},
+	}
+	const body = `{"targets":[
+		{"zone_id":1,"state":"off"},
+		{"mapping_id":13,"state":"on"},
+		{"zone_id":7,"state":"on"},
+		{"mapping_id":14,"state":"on"},
+		{"zone_id":2,"state":"on"},
+		{"zone_id":3,"state":"on"}]}`
+
+	tests := []struct {
+		name        string
+		budget      int
+		wantStatus  int
+		wantOK      bool
+		wantResults []string // Per target: the writes, or the error code
+		wantCoils   []int    // Written on PLC 1
+	}{
+		{
+			name:       "per-target results",
+			budget:     10,
+			wantStatus: http.StatusOK,
+			wantResults: []string{
+				"PLC1:C251 PLC1:C260",
+				"PLC1:C203",
+				codeZoneNotFound,
+				codeMappingHasNoOutputs,
+				codeZoneHasNoLights,
+				codePLCUnreachable,
+			},
+			wantCoils: []int{251, 260, 203},
+		},
+		{
+			name:       "over the pulse budget",
+			budget:     2, // PLC 1 needs 3
+			wantStatus: http.StatusTooManyRequests,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			plc1, addr1 := startFakeCoilPLC(t)
+			plc2, addr2 := startFakeCoilPLC(t)
+			plc2.failing.Store(true)
+			app.cfg.PLCs = map[int]PLCConfig{1: {Address: addr1}, 2: {Address: addr2}}
+			app.cfg.WordPressAPIBaseURL = fakeWordPress(t, data)
+			app.cfg.AuthControlMode = "none"
+			app.cfg.PulseBudget = tt.budget
+			app.cfg.PulseRefillPerMinute = 0
+			app.cfg.PulseSpacingMs = 0
+			app.sessions.Rebuild(app.cfg.PLCs)
+			t.Cleanup(app.sessions.CloseAll)
+			app.pulses = NewPulseQueues(app.sessions, app.config)
+
+			rec := httptest.NewRecorder()
+			app.newRouter().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/overrides", bytes.NewBufferString(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := plc1.coils(); !slices.Equal(got, tt.wantCoils) {
+				t.Errorf("PLC 1 coils %v, want %v", got, tt.wantCoils)
+			}
+			if rec.Code != http.StatusOK {
+				var resp errorResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Error.Code != codeThrottled {
+					t.Errorf("error code %q, want %s", resp.Error.Code, codeThrottled)
+				}
+				return
+			}
+
+			var resp overridesResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.OK != tt.wantOK {
+				t.Errorf("ok %v, want %v", resp.OK, tt.wantOK)
+			}
+			var got []string
+			for _, res := range resp.Results {
+				if res.Error != nil {
+					got = append(got, res.Error.Code)
+				} else {
+					slices.Sort(res.Writes)
+					got = append(got, strings.Join(res.Writes, " "))
+				}
+			}
+			if !slices.Equal(got, tt.wantResults) {
+				t.Errorf("results %q, want %q", got, tt.wantResults)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The photocell bit C154 drives every SUNDOWN/SUNRISE span. The Cabana PLC
+// copies it from X001 and the Lodge PLC mirrors it over RECV. A dirty or
+// failed sensor can be overridden with two ladder bits (see the address map in
+// LadderLogicForAI.txt), written to every PLC so the force holds even if the
+// RECV link is down:
+//
+//	C155  Photocell_Force        ON = C154 follows C156 instead of X001/RECV
+//	C156  Photocell_Force_Value  ON = dark (night), OFF = daylight
+//
+// C bits are not retained, so a PLC that restarts goes back to the sensor.
+// The service re-applies an active force on every status poll and releases it
+// when it times out. A PLC that misses a release keeps C155 set, so it is
+// retried on every poll until that PLC takes it.
+
+const (
+	photocellForceBit = 155
+	photocellValueBit = 156
+
+	maxPhotocellForce = 7 * 24 * time.Hour
+)
+
+// PhotocellForce is an active manual override of the photocell.
+type PhotocellForce struct {
+	State string    `json:"state"` // "on" (dark) or "off" (daylight)
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	By    string    `json:"by"`
+}
+
+// PhotocellOverride holds the force, persisted so it survives a restart.
+// After a release it also holds the PLCs that have not yet accepted the
+// C155 clear; the status poller keeps retrying them.
+type PhotocellOverride struct {
+	path string
+
+	mu      sync.Mutex
+	force   *PhotocellForce
+	pending []int // PLC IDs that may still have C155 set after a release
+	timer   *time.Timer
+}
+
+// savedPhotocell is the file format. A bare PhotocellForce, as older
+// versions wrote, still loads.
+type savedPhotocell struct {
+	*PhotocellForce
+	ReleasePending []int `json:"release_pending,omitempty"`
+}
+
+func NewPhotocellOverride(path string) *PhotocellOverride {
+	return &PhotocellOverride{path: path}
+}
+
+// Current returns the active force, or nil when C154 follows the sensor.
+func (p *PhotocellOverride) Current() *PhotocellForce {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.force == nil {
+		return nil
+	}
+	f := *p.force
+	return &f
+}
+
+// Pending returns the PLCs a release has not reached yet.
+func (p *PhotocellOverride) Pending() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.pending)
+}
+
+// set records a force and arms the timeout. It replaces any release still
+// pending, since the force is written to every PLC.
+func (p *PhotocellOverride) set(force *PhotocellForce, expire func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopTimer()
+	p.force, p.pending = force, nil
+	p.timer = time.AfterFunc(time.Until(force.Until), expire)
+	return p.save()
+}
+
+// release clears the force and marks the given PLCs as still to release.
+func (p *PhotocellOverride) release(plcs []int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopTimer()
+	p.force, p.pending = nil, slices.Clone(plcs)
+	return p.save()
+}
+
+// releaseExpired is release for the timeout: it does nothing and returns
+// false if the force was replaced by one that has not expired.
+func (p *PhotocellOverride) releaseExpired(now time.Time, plcs []int) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.force != nil && now.Before(p.force.Until) {
+		return false, nil
+	}
+	p.stopTimer()
+	p.force, p.pending = nil, slices.Clone(plcs)
+	return true, p.save()
+}
+
+// released records that a PLC accepted the C155 clear. It does nothing if a
+// force was set since.
+func (p *PhotocellOverride) released(plc int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := slices.Index(p.pending, plc)
+	if p.force != nil || i < 0 {
+		return nil
+	}
+	p.pending = slices.Delete(p.pending, i, i+1)
+	return p.save()
+}
+
+func (p *PhotocellOverride) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// save writes the force and pending releases, or removes the file when
+// there are neither. The caller holds p.mu.
+func (p *PhotocellOverride) save() error {
+	if p.force == nil && len(p.pending) == 0 {
+		if err := os.Remove(p.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	raw, err := json.MarshalIndent(savedPhotocell{PhotocellForce: p.force, ReleasePending: p.pending}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// load reads a force, or releases still pending, saved by an earlier run.
+func (p *PhotocellOverride) load() (*PhotocellForce, []int, error) {
+	raw, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var saved savedPhotocell
+	if err := json.Unmarshal(raw, &saved); err != nil {
+		return nil, nil, fmt.Errorf("could not parse %s: %w", p.path, err)
+	}
+	return saved.PhotocellForce, saved.ReleasePending, nil
+}
+
+// writePhotocellBits writes C156 and then C155 to one PLC, so C154 never
+// briefly follows a stale value.
+func writePhotocellBits(session *PLCSession, force *PhotocellForce) error {
+	forceAddr, _ := cBitToModbusAddress(photocellForceBit)
+	valueAddr, _ := cBitToModbusAddress(photocellValueBit)
+	return session.Do(func(client modbus.Client) error {
+		if force == nil {
+			_, err := client.WriteSingleCoil(forceAddr, 0x0000)
+			return err
+		}
+		value := uint16(0x0000)
+		if force.State == "on" {
+			value = 0xFF00
+		}
+		if _, err := client.WriteSingleCoil(valueAddr, value); err != nil {
+			return err
+		}
+		_, err := client.WriteSingleCoil(forceAddr, 0xFF00)
+		return err
+	})
+}
+
+// applyPhotocell writes the force (or its release) to every PLC. It returns
+// the coils written and the last error. A PLC that misses a force gets it on
+// the next poll; one that misses a release stays pending and is retried.
+func (app *App) applyPhotocell(force *PhotocellForce) ([]string, error) {
+	if app.isSimulationMode() {
+		app.simulatedStateMutex.Lock()
+		if force == nil {
+			delete(app.simulatedState, "Photocell")
+		} else {
+			app.simulatedState["Photocell"] = force.State == "on"
+		}
+		app.simulatedStateMutex.Unlock()
+		return nil, nil
+	}
+	var writes []string
+	var lastErr error
+	for _, session := range app.sessions.With(capPhotocell) {
+		if err := writePhotocellBits(session, force); err != nil {
+			modbusLog.Error("could not write photocell force", "plc", session.ID, "host", session.Host, "err", err)
+			lastErr = err
+			continue
+		}
+		if force == nil {
+			writes = append(writes, fmt.Sprintf("PLC%d:C%d", session.ID, photocellForceBit))
+			app.markPhotocellReleased(session.ID)
+		} else {
+			writes = append(writes, fmt.Sprintf("PLC%d:C%d-C%d", session.ID, photocellForceBit, photocellValueBit))
+		}
+	}
+	return writes, lastErr
+}
+
+// photocellPLCs lists the PLCs a force is written to, and so the ones a
+// release has to reach.
+func (app *App) photocellPLCs() []int {
+	if app.isSimulationMode() {
+		return nil
+	}
+	var ids []int
+	for _, session := range app.sessions.With(capPhotocell) {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+func (app *App) markPhotocellReleased(plc int) {
+	if err := app.photocell.released(plc); err != nil {
+		photocellLog.Error("could not save photocell release", "plc", plc, "err", err)
+	}
+}
+
+// releasePhotocell hands C154 back to the sensor when a force times out.
+func (app *App) releasePhotocell() {
+	done, err := app.beginPLCWork()
+	if err != nil {
+		return // Shutting down; the next start releases an expired force
+	}
+	defer done()
+
+	// Checked under the store's lock, so a force set meanwhile is kept.
+	released, err := app.photocell.releaseExpired(time.Now(), app.photocellPLCs())
+	if err != nil {
+		photocellLog.Error("could not save photocell release", "err", err)
+	}
+	if !released {
+		return // Replaced by a newer force while the timer fired
+	}
+
+	photocellLog.Info("photocell force timed out, returning to the sensor")
+	writes, err := app.applyPhotocell(nil)
+	app.finishAudit(AuditEntry{Time: time.Now(), Caller: "timeout", Action: "photocell", State: "auto"}, writes, err)
+}
+
+// restorePhotocell re-applies a force saved by an earlier run, releases it
+// if it expired while the service was down, or goes on retrying a release
+// that had not reached every PLC.
+func (app *App) restorePhotocell() {
+	force, pending, err := app.photocell.load()
+	if err != nil {
+		photocellLog.Error("could not load saved photocell force", "err", err)
+		return
+	}
+	switch {
+	case force == nil && len(pending) == 0:
+		return
+	case force == nil:
+		photocellLog.Warn("photocell release still pending from the last run", "plcs", pending)
+		if err := app.photocell.release(pending); err != nil {
+			photocellLog.Error("could not save photocell release", "err", err)
+		}
+	case !time.Now().Before(force.Until):
+		app.releasePhotocell()
+		return
+	default:
+		photocellLog.Info("restoring photocell force", "state", force.State, "until", force.Until)
+		if err := app.photocell.set(force, app.releasePhotocell); err != nil {
+			photocellLog.Error("could not save photocell force", "err", err)
+		}
+	}
+	app.reassertPhotocell()
+}
+
+// reassertPhotocell writes an active force again, in case a PLC restarted and
+// lost it, or retries a release on the PLCs that missed it. Called from the
+// status poller.
+func (app *App) reassertPhotocell() {
+	if app.isSimulationMode() {
+		return
+	}
+	if force := app.photocell.Current(); force != nil {
+		for _, session := range app.sessions.With(capPhotocell) {
+			if err := writePhotocellBits(session, force); err != nil {
+				modbusLog.Warn("could not re-apply photocell force", "plc", session.ID, "err", err)
+			}
+		}
+		return
+	}
+
+	pending := app.photocell.Pending()
+	if len(pending) == 0 {
+		return
+	}
+	var writes []string
+	var lastErr error
+	for _, id := range pending {
+		session, ok := app.sessions.Get(id)
+		if !ok || !session.Can(capPhotocell) {
+			photocellLog.Warn("dropping photocell release for a PLC no longer configured", "plc", id)
+			app.markPhotocellReleased(id)
+			continue
+		}
+		if err := writePhotocellBits(session, nil); err != nil {
+			modbusLog.Warn("could not release photocell force, will retry", "plc", id, "err", err)
+			lastErr = err
+			continue
+		}
+		photocellLog.Info("photocell force released", "plc", id)
+		writes = append(writes, fmt.Sprintf("PLC%d:C%d", id, photocellForceBit))
+		app.markPhotocellReleased(id)
+	}
+	if len(writes) > 0 {
+		app.finishAudit(AuditEntry{Time: time.Now(), Caller: "retry", Action: "photocell", State: "auto"}, writes, lastErr)
+	}
+}
+
+// handlePhotocell reports the photocell override, history and alerts.
+func (app *App) handlePhotocell(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, photocellResponse{
+		Forced:          app.photocell.Current(),
+		ReleasePending:  app.photocell.Pending(),
+		PhotocellReport: app.photocellMonitor.Report(time.Now(), app.config()),
+		Link:            app.photocellLink.Report(),
+	})
+}
+
+type photocellResponse struct {
+	Forced         *PhotocellForce `json:"forced"`                    // null when C154 follows the sensor
+	ReleasePending []int           `json:"release_pending,omitempty"` // PLCs a release has not reached yet
+	PhotocellReport
+	Link PhotocellLinkReport `json:"link"` // Master/slave agreement; empty with one PLC
+}
+
+// handleForcePhotocell forces C154 on (night) or off (day) on every PLC.
+// ?minutes= sets the timeout (default PhotocellForceMinutes, at most 7 days).
+func (app *App) handleForcePhotocell(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	state, err := parseState(ps)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	minutes, err := queryInt(r.URL.Query(), "minutes")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if minutes == 0 {
+		minutes = app.config().PhotocellForceMinutes
+	}
+	timeout := time.Duration(minutes) * time.Minute
+	if timeout > maxPhotocellForce {
+		writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "minutes must be at most %d", int(maxPhotocellForce.Minutes())))
+		return
+	}
+
+	now := time.Now()
+	force := &PhotocellForce{State: state, Since: now, Until: now.Add(timeout), By: callerFromRequest(r)}
+	httpLog.InfoContext(r.Context(), "forcing photocell", "state", state, "until", force.Until, "caller", force.By)
+	audit := newAuditEntry(r, "photocell")
+	audit.State = state
+	app.applyPhotocellRequest(w, r, audit, force)
+}
+
+// handleReleasePhotocell hands C154 back to the sensor.
+func (app *App) handleReleasePhotocell(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	httpLog.InfoContext(r.Context(), "releasing photocell force", "caller", callerFromRequest(r))
+	audit := newAuditEntry(r, "photocell")
+	audit.State = "auto"
+	app.applyPhotocellRequest(w, r, audit, nil)
+}
+
+func (app *App) applyPhotocellRequest(w http.ResponseWriter, r *http.Request, audit AuditEntry, force *PhotocellForce) {
+	done, err := app.beginPLCWork()
+	if err != nil {
+		app.finishAudit(audit, nil, err)
+		writeError(w, r, err)
+		return
+	}
+	defer done()
+
+	// Save first, so a PLC that misses the write is fixed by the next poll.
+	save := func() error { return app.photocell.set(force, app.releasePhotocell) }
+	if force == nil {
+		save = func() error { return app.photocell.release(app.photocellPLCs()) }
+	}
+	if err := save(); err != nil {
+		app.finishAudit(audit, nil, err)
+		writeError(w, r, err)
+		return
+	}
+	writes, err := app.applyPhotocell(force)
+	app.finishAudit(audit, writes, err)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	app.writeCommandOK(w, r, writes)
+}
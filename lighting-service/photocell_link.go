@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PhotocellLinkMonitor checks the RECV link that copies C154 from the master
+// PLC (the Cabana, with the sensor on X001 and C153 set) to the slave (the
+// Lodge) every 0.2s. If the link breaks, the slave keeps its last value with
+// no error of its own, so the service compares C154 on every PLC it reads.
+// Disagreement for longer than PhotocellLinkSeconds means the link has failed.
+type PhotocellLinkMonitor struct {
+	mu             sync.Mutex
+	report         PhotocellLinkReport
+	disagreeSince  time.Time
+	lastAlertKinds []string
+}
+
+// PhotocellLinkReport is the link's state as of the last reading of every PLC.
+type PhotocellLinkReport struct {
+	Checked       time.Time          `json:"checked"`
+	Master        int                `json:"master"` // PLC ID with C153 set; 0 if none or several
+	PLCs          []PhotocellLinkPLC `json:"plcs"`
+	Agree         *bool              `json:"agree"` // null until every PLC has been read
+	DisagreeSince *time.Time         `json:"disagree_since,omitempty"`
+	Alerts        []PhotocellAlert   `json:"alerts"` // Kinds: "link_failed", "no_master", "multiple_masters", "wrong_master"
+}
+
+// PhotocellLinkPLC is what one PLC reported.
+type PhotocellLinkPLC struct {
+	ID     int   `json:"id"`
+	Master *bool `json:"master"` // C153; null if the PLC could not be read
+	Dark   *bool `json:"dark"`   // C154
+}
+
+func NewPhotocellLinkMonitor() *PhotocellLinkMonitor {
+	return &PhotocellLinkMonitor{report: PhotocellLinkReport{PLCs: []PhotocellLinkPLC{}, Alerts: []PhotocellAlert{}}}
+}
+
+// Observe takes the PLCn-Master/PLCn-Photocell keys from a status reading of
+// the configured PLCs with a photocell and logs alerts that start or clear.
+func (m *PhotocellLinkMonitor) Observe(now time.Time, cfg Config, status map[string]interface{}) {
+	var ids []int
+	for _, id := range cfg.configuredPLCs() {
+		if cfg.PLCs[id].Can(capPhotocell) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 {
+		return // Nothing to compare
+	}
+
+	report := PhotocellLinkReport{Checked: now, PLCs: []PhotocellLinkPLC{}, Alerts: []PhotocellAlert{}}
+	var masters []int
+	readAll := true
+	for _, id := range ids {
+		plc := PhotocellLinkPLC{ID: id}
+		if v, ok := status[fmt.Sprintf("PLC%d-Master", id)].(bool); ok {
+			plc.Master = &v
+			if v {
+				masters = append(masters, id)
+			}
+		}
+		if v, ok := status[fmt.Sprintf("PLC%d-Photocell", id)].(bool); ok {
+			plc.Dark = &v
+		} else {
+			readAll = false
+		}
+		report.PLCs = append(report.PLCs, plc)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch len(masters) {
+	case 1:
+		report.Master = masters[0]
+		if want := cfg.photocellMaster(); want != 0 && want != masters[0] {
+			report.Alerts = append(report.Alerts, PhotocellAlert{Kind: "wrong_master", Message: fmt.Sprintf(
+				"PLC %d has C153 set but the config makes %s the photocell master", masters[0], cfg.PLCs[want].label(want))})
+		}
+	case 0:
+		if readAll {
+			report.Alerts = append(report.Alerts, PhotocellAlert{Kind: "no_master", Message: "no PLC has C153 set; nothing reads the photocell on X001"})
+		}
+	default:
+		report.Alerts = append(report.Alerts, PhotocellAlert{Kind: "multiple_masters", Message: fmt.Sprintf("PLCs %v all have C153 set; only %s should", masters, cfg.photocellMasterLabel())})
+	}
+
+	if readAll {
+		agree := true
+		for _, plc := range report.PLCs[1:] {
+			agree = agree && *plc.Dark == *report.PLCs[0].Dark
+		}
+		report.Agree = &agree
+		if agree {
+			m.disagreeSince = time.Time{}
+		} else if m.disagreeSince.IsZero() {
+			m.disagreeSince = now
+		}
+	}
+	// A PLC that can't be read keeps the timer running; its session reports that on its own.
+	if !m.disagreeSince.IsZero() {
+		since := m.disagreeSince
+		report.DisagreeSince = &since
+		if limit := time.Duration(cfg.PhotocellLinkSeconds) * time.Second; now.Sub(since) >= limit {
+			report.Alerts = append(report.Alerts, PhotocellAlert{Kind: "link_failed", Message: fmt.Sprintf(
+				"photocell link failed: PLCs have disagreed on C154 for %s; %s may be acting on a stale photocell",
+				now.Sub(since).Round(time.Second), cfg.photocellSlaveLabels(report.Master))})
+		}
+	}
+
+	for _, a := range report.Alerts {
+		if !slices.Contains(m.lastAlertKinds, a.Kind) {
+			photocellLog.Warn("photocell link alert", "kind", a.Kind, "alert", a.Message, "master", report.Master)
+		}
+	}
+	kinds := make([]string, 0, len(report.Alerts))
+	for _, a := range report.Alerts {
+		kinds = append(kinds, a.Kind)
+	}
+	for _, k := range m.lastAlertKinds {
+		if !slices.Contains(kinds, k) {
+			photocellLog.Info("photocell link alert cleared", "kind", k)
+		}
+	}
+	m.lastAlertKinds = kinds
+	m.report = report
+}
+
+// Report returns the state as of the last reading.
+func (m *PhotocellLinkMonitor) Report() PhotocellLinkReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.report
+	r.PLCs = slices.Clone(r.PLCs)
+	r.Alerts = slices.Clone(r.Alerts)
+	return r
+}
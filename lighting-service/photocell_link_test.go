@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPhotocellLinkMonitor(t *testing.T) {
+	cfg := defaultConfig() // PhotocellLinkSeconds 120
+	cfg.PLCs = map[int]PLCConfig{
+		1: {Address: "192.168.1.201:502", Name: "Lodge", Roles: []string{rolePhotocellSlave}},
+		2: {Address: "192.168.1.202:502", Name: "Cabana", Roles: []string{rolePhotocellMaster}},
+		3: {Address: "192.168.1.203:502", Capabilities: []string{capSchedules}}, // No photocell
+	}
+	// reading builds a status map; "" leaves a PLC out as if it could not be read.
+	reading := func(plc1, plc2 string) map[string]interface{} {
+		status := map[string]interface{}{"PLC3-Photocell": false}
+		for id, s := range map[int]string{1: plc1, 2: plc2} {
+			if s == "" {
+				continue
+			}
+			status[fmt.Sprintf("PLC%d-Master", id)] = s[0] == 'M'
+			status[fmt.Sprintf("PLC%d-Photocell", id)] = s[1] == 'D'
+		}
+		return status
+	}
+
+	t0 := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at           time.Duration
+		plc1, plc2   string // Master (M/-) and dark (D/L)
+		wantMaster   int
+		wantAgree    string // "yes", "no" or "" for unknown
+		wantDisagree time.Duration
+		wantAlerts   []string
+	}{
+		{at: 0, plc1: "-D", plc2: "MD", wantMaster: 2, wantAgree: "yes"},
+		{at: 10 * time.Second, plc1: "-L", plc2: "MD", wantMaster: 2, wantAgree: "no", wantDisagree: 10 * time.Second},
+		{at: 60 * time.Second, plc1: "", plc2: "MD", wantMaster: 2, wantDisagree: 10 * time.Second},
+		{at: 130 * time.Second, plc1: "-L", plc2: "MD", wantMaster: 2, wantAgree: "no", wantDisagree: 10 * time.Second, wantAlerts: []string{"link_failed"}},
+		{at: 140 * time.Second, plc1: "-D", plc2: "MD", wantMaster: 2, wantAgree: "yes"},
+		{at: 150 * time.Second, plc1: "MD", plc2: "-D", wantMaster: 1, wantAgree: "yes", wantAlerts: []string{"wrong_master"}},
+		{at: 160 * time.Second, plc1: "MD", plc2: "MD", wantAgree: "yes", wantAlerts: []string{"multiple_masters"}},
+		{at: 170 * time.Second, plc1: "-D", plc2: "-D", wantAgree: "yes", wantAlerts: []string{"no_master"}},
+		{at: 180 * time.Second, plc1: "", plc2: "-D"}, // No master alert without every PLC read
+	}
+	m := NewPhotocellLinkMonitor()
+	for _, s := range steps {
+		m.Observe(t0.Add(s.at), cfg, reading(s.plc1, s.plc2))
+		r := m.Report()
+		if r.Master != s.wantMaster {
+			t.Errorf("at %s: master %d, want %d", s.at, r.Master, s.wantMaster)
+		}
+		agree := ""
+		if r.Agree != nil {
+			agree = map[bool]string{true: "yes", false: "no"}[*r.Agree]
+		}
+		if agree != s.wantAgree {
+			t.Errorf("at %s: agree %q, want %q", s.at, agree, s.wantAgree)
+		}
+		var disagree time.Duration
+		if r.DisagreeSince != nil {
+			disagree = r.DisagreeSince.Sub(t0)
+		}
+		if disagree != s.wantDisagree {
+			t.Errorf("at %s: disagreeing since %s, want %s", s.at, disagree, s.wantDisagree)
+		}
+		var kinds []string
+		for _, a := range r.Alerts {
+			kinds = append(kinds, a.Kind)
+		}
+		if !slices.Equal(kinds, s.wantAlerts) {
+			t.Errorf("at %s: alerts %v, want %v", s.at, r.Alerts, s.wantAlerts)
+		}
+		if len(r.PLCs) != 2 {
+			t.Errorf("at %s: reported %d PLCs, want the 2 with a photocell", s.at, len(r.PLCs))
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PhotocellMonitor keeps a history of the photocell bit C154 as the service
+// reads it (from the status poller and /status) and flags a sensor that looks
+// broken:
+//
+//   - stuck: no change for PhotocellStuckHours (a day has a dusk and a dawn)
+//   - flapping: PhotocellFlapCount changes within PhotocellFlapMinutes, which
+//     makes SUNDOWN/SUNRISE spans pulse relays on and off at dusk
+//   - off-sun: with Latitude/Longitude set, daylight well after sunset or dark
+//     well after sunrise, or a change far from either
+//
+// The reading comes from the schedule reporter (the Lodge), which mirrors the
+// Cabana sensor over RECV, so a stale link looks the same as a stuck sensor
+// here; PhotocellLinkMonitor tells the two apart.
+// Nothing is recorded while the photocell is forced.
+type PhotocellMonitor struct {
+	mu          sync.Mutex
+	dark        *bool
+	since       time.Time // When dark last changed, or the first reading
+	transitions []PhotocellTransition
+	alerts      []PhotocellAlert // As of the last reading, for logging changes
+}
+
+// PhotocellAlert is one problem with the photocell. Kind is stable while the
+// problem lasts: "stuck", "flapping", "off_sun" or "off_sun_change".
+type PhotocellAlert struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// PhotocellTransition is one change of C154.
+type PhotocellTransition struct {
+	Time time.Time `json:"time"`
+	Dark bool      `json:"dark"`
+}
+
+// PhotocellReport is the monitor's view for /api/v1/photocell.
+type PhotocellReport struct {
+	Dark        *bool                 `json:"dark"` // null before the first reading
+	Since       time.Time             `json:"since"`
+	Sunrise     *time.Time            `json:"sunrise,omitempty"` // Today's, with Latitude/Longitude set
+	Sunset      *time.Time            `json:"sunset,omitempty"`
+	Transitions []PhotocellTransition `json:"transitions"` // Oldest first
+	Alerts      []PhotocellAlert      `json:"alerts"`
+}
+
+// maxPhotocellHistory bounds the transition history; a flapping sensor can
+// change hundreds of times a night.
+const maxPhotocellHistory = 500
+
+func NewPhotocellMonitor() *PhotocellMonitor {
+	return &PhotocellMonitor{}
+}
+
+// Observe records a reading of C154 and logs alerts that start or clear.
+func (m *PhotocellMonitor) Observe(now time.Time, cfg Config, dark bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dark == nil {
+		m.since = now
+	} else if *m.dark != dark {
+		m.since = now
+		m.transitions = append(m.transitions, PhotocellTransition{Time: now, Dark: dark})
+		if len(m.transitions) > maxPhotocellHistory {
+			m.transitions = slices.Delete(m.transitions, 0, len(m.transitions)-maxPhotocellHistory)
+		}
+		photocellLog.Info("photocell changed", "dark", dark)
+	}
+	m.dark = &dark
+
+	alerts := m.evaluate(now, cfg)
+	for _, a := range alerts {
+		if !slices.ContainsFunc(m.alerts, func(old PhotocellAlert) bool { return old.Kind == a.Kind }) {
+			photocellLog.Warn("photocell alert", "kind", a.Kind, "alert", a.Message)
+		}
+	}
+	for _, old := range m.alerts {
+		if !slices.ContainsFunc(alerts, func(a PhotocellAlert) bool { return a.Kind == old.Kind }) {
+			photocellLog.Info("photocell alert cleared", "kind", old.Kind)
+		}
+	}
+	m.alerts = alerts
+}
+
+// evaluate works out the active alerts. Call with m.mu held.
+func (m *PhotocellMonitor) evaluate(now time.Time, cfg Config) []PhotocellAlert {
+	var alerts []PhotocellAlert
+	alert := func(kind, format string, args ...any) {
+		alerts = append(alerts, PhotocellAlert{Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+	if m.dark == nil {
+		return nil
+	}
+	state := "daylight"
+	if *m.dark {
+		state = "dark"
+	}
+
+	stuck := time.Duration(cfg.PhotocellStuckHours) * time.Hour
+	if held := now.Sub(m.since); held >= stuck {
+		cause := "the sensor or the RECV link from " + cfg.photocellMasterLabel()
+		if master := cfg.photocellMaster(); master != 0 && master == cfg.scheduleReporter() {
+			cause = "the sensor" // The reading comes straight from the master
+		}
+		alert("stuck", "photocell stuck: %s for %s; %s may have failed", state, held.Round(time.Minute), cause)
+	}
+
+	window := time.Duration(cfg.PhotocellFlapMinutes) * time.Minute
+	recent := 0
+	for _, t := range m.transitions {
+		if now.Sub(t.Time) <= window {
+			recent++
+		}
+	}
+	if recent >= cfg.PhotocellFlapCount {
+		alert("flapping", "photocell flapping: %d changes in the last %d minutes", recent, cfg.PhotocellFlapMinutes)
+	}
+
+	if !cfg.hasLocation() {
+		return alerts
+	}
+	tolerance := time.Duration(cfg.PhotocellSunToleranceMinutes) * time.Minute
+	rise, set, ok := sunTimes(now.In(cfg.location()), cfg.Latitude, cfg.Longitude)
+	if !ok {
+		return alerts
+	}
+	switch {
+	case !*m.dark && now.After(set.Add(tolerance)):
+		alert("off_sun", "photocell still reads daylight %s after sunset (%s)", now.Sub(set).Round(time.Minute), set.Format("15:04"))
+	case !*m.dark && now.Before(rise.Add(-tolerance)):
+		alert("off_sun", "photocell reads daylight before sunrise (%s)", rise.Format("15:04"))
+	case *m.dark && now.After(rise.Add(tolerance)) && now.Before(set.Add(-tolerance)):
+		alert("off_sun", "photocell still reads dark %s after sunrise (%s)", now.Sub(rise).Round(time.Minute), rise.Format("15:04"))
+	}
+
+	// The latest change in the last day that was far from its sunrise/sunset.
+	for _, t := range slices.Backward(m.transitions) {
+		if now.Sub(t.Time) > 24*time.Hour {
+			break
+		}
+		rise, set, ok := sunTimes(t.Time.In(cfg.location()), cfg.Latitude, cfg.Longitude)
+		if !ok {
+			continue
+		}
+		expected, name, went := rise, "sunrise", "light"
+		if t.Dark {
+			expected, name, went = set, "sunset", "dark"
+		}
+		if off := t.Time.Sub(expected); off > tolerance || off < -tolerance {
+			alert("off_sun_change", "photocell went %s at %s, %s was %s", went, t.Time.Format("Jan 2 15:04"), name, expected.Format("15:04"))
+			break
+		}
+	}
+	return alerts
+}
+
+// Alerts returns the alerts as of the last reading.
+func (m *PhotocellMonitor) Alerts() []PhotocellAlert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.alerts)
+}
+
+// Report returns the history, today's sun times and the active alerts.
+func (m *PhotocellMonitor) Report(now time.Time, cfg Config) PhotocellReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := PhotocellReport{
+		Since:       m.since,
+		Transitions: slices.Clone(m.transitions),
+		Alerts:      m.evaluate(now, cfg),
+	}
+	if report.Transitions == nil {
+		report.Transitions = []PhotocellTransition{}
+	}
+	if report.Alerts == nil {
+		report.Alerts = []PhotocellAlert{}
+	}
+	if m.dark != nil {
+		dark := *m.dark
+		report.Dark = &dark
+	}
+	if cfg.hasLocation() {
+		if rise, set, ok := sunTimes(now.In(cfg.location()), cfg.Latitude, cfg.Longitude); ok {
+			report.Sunrise, report.Sunset = &rise, &set
+		}
+	}
+	return report
+}
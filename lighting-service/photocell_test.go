@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testPhotocellApp has one fake PLC per entry, all with the photocell.
+func testPhotocellApp(t *testing.T, plcs ...*fakeCoilPLC) *App {
+	t.Helper()
+	app := newTestApp(t)
+	app.cfg.PLCs = map[int]PLCConfig{}
+	for i := range plcs {
+		var addr string
+		plcs[i], addr = startFakeCoilPLC(t)
+		app.cfg.PLCs[i+1] = PLCConfig{Address: addr}
+	}
+	app.sessions.Rebuild(app.cfg.PLCs)
+	t.Cleanup(app.sessions.CloseAll)
+	return app
+}
+
+// released reports whether C155 was cleared on a fake PLC, the only write
+// to C155 a release makes.
+func released(plc *fakeCoilPLC) bool {
+	return slices.Contains(plc.coils(), photocellForceBit)
+}
+
+func TestPhotocellReleaseRetried(t *testing.T) {
+	plcs := make([]*fakeCoilPLC, 2)
+	app := testPhotocellApp(t, plcs...)
+
+	if err := app.photocell.release(app.photocellPLCs()); err != nil {
+		t.Fatal(err)
+	}
+	plcs[1].failing.Store(true)
+	if _, err := app.applyPhotocell(nil); err == nil {
+		t.Fatal("release to a failing PLC reported no error")
+	}
+	if got := app.photocell.Pending(); !slices.Equal(got, []int{2}) {
+		t.Fatalf("pending %v, want [2]", got)
+	}
+	if got := app.health(); !slices.Equal(got.Releasing, []int{2}) || got.Status != "degraded" {
+		t.Errorf("health %s with release pending %v, want degraded with [2]", got.Status, got.Releasing)
+	}
+
+	// The poller retries until the PLC takes it.
+	app.reassertPhotocell()
+	if got := app.photocell.Pending(); !slices.Equal(got, []int{2}) {
+		t.Fatalf("pending %v after a failed retry, want [2]", got)
+	}
+	plcs[1].failing.Store(false)
+	app.reassertPhotocell()
+	if got := app.photocell.Pending(); len(got) != 0 {
+		t.Errorf("pending %v after a good retry, want none", got)
+	}
+	if !released(plcs[1]) {
+		t.Error("PLC 2 never had C155 cleared")
+	}
+	if _, err := os.Stat(app.photocell.path); !os.IsNotExist(err) {
+		t.Errorf("saved state still there after the release: %v", err)
+	}
+}
+
+func TestPhotocellReleaseKeepsNewerForce(t *testing.T) {
+	plcs := make([]*fakeCoilPLC, 1)
+	app := testPhotocellApp(t, plcs...)
+
+	now := time.Now()
+	force := &PhotocellForce{State: "on", Since: now, Until: now.Add(time.Hour)}
+	if err := app.photocell.set(force, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	// A timer from an older force fires after this one was set.
+	app.releasePhotocell()
+	if app.photocell.Current() == nil {
+		t.Fatal("an expired timer cleared a newer force")
+	}
+	if released(plcs[0]) {
+		t.Error("an expired timer wrote C155 off under a newer force")
+	}
+
+	// A pending release doesn't undo a force set afterwards.
+	if err := app.photocell.release([]int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.photocell.set(force, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.photocell.released(1); err != nil || app.photocell.Current() == nil || len(app.photocell.Pending()) != 0 {
+		t.Errorf("force %v pending %v after set, want the force and nothing pending", app.photocell.Current(), app.photocell.Pending())
+	}
+}
+
+func TestPhotocellOverrideLoad(t *testing.T) {
+	until := time.Date(2026, 10, 20, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		file        string // "" for no file
+		wantState   string // "" for no force
+		wantPending []int
+	}{
+		{name: "no file"},
+		{name: "force from an older version", file: `{"state": "on", "since": "2026-10-19T18:00:00Z", "until": "2026-10-20T06:00:00Z", "by": "admin"}`, wantState: "on"},
+		{name: "release pending", file: `{"release_pending": [2, 3]}`, wantPending: []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPhotocellOverride(filepath.Join(t.TempDir(), "photocell_force.json"))
+			if tt.file != "" {
+				if err := os.WriteFile(p.path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			force, pending, err := p.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantState == "" && force != nil:
+				t.Errorf("force %+v, want none", force)
+			case tt.wantState != "" && (force == nil || force.State != tt.wantState || !force.Until.Equal(until)):
+				t.Errorf("force %+v, want %s until %v", force, tt.wantState, until)
+			}
+			if !slices.Equal(pending, tt.wantPending) {
+				t.Errorf("pending %v, want %v", pending, tt.wantPending)
+			}
+		})
+	}
+}
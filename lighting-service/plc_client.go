@@ -1,11 +1,13 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -20,10 +22,13 @@ type FullConfigZone struct {
 	ScheduleID int    `json:"schedule_id"`
 }
 type FullConfigMapping struct {
-	ID            int      `json:"id"`
-	PLCID         int      `json:"plc_id"`
-	PLCOutputs    []string `json:"plc_outputs"`
-	LinkedZoneIDs []int    `json:"linked_zone_ids"`
+	ID             int      `json:"id"`
+	PLCID          int      `json:"plc_id"`
+	Description    string   `json:"description"`
+	PLCOutputs     []string `json:"plc_outputs"`
+	LinkedZoneIDs  []int    `json:"linked_zone_ids"`
+	LampWattage    float64  `json:"lamp_wattage"`     // Optional, total watts on this circuit
+	RatedLifeHours float64  `json:"rated_life_hours"` // Optional, for "replace soon" warnings
 }
 type FullConfigSchedule struct {
 	ID           int              `json:"id"`
@@ -45,7 +50,7 @@ type FullConfigurationData struct {
 
 // --- Main Functions ---
 
-// FetchConfigurationFromAPI 
+// FetchConfigurationFromAPI
 func FetchConfigurationFromAPI(cfg Config) (*FullConfigurationData, error) {
 	// ... (This function is identical to your last version) ...
 	url := fmt.Sprintf("%s/wp-json/fsbhoa-lighting/v1/full-config", cfg.WordPressAPIBaseURL)
@@ -79,10 +84,10 @@ func calculateLoopIndex(yOutput string) int {
 		return -1 // Invalid output
 	}
 	moduleGroup := (yNum - (yNum % 100)) / 100 // e.g., 1
-	outputOnModule := (yNum % 100)           // e.g., 1
+	outputOnModule := (yNum % 100)             // e.g., 1
 	outputPairIndex := (outputOnModule - 1) / 2
 	loopIndex := (moduleGroup-1)*8 + outputPairIndex
-	
+
 	if loopIndex < 0 || loopIndex > 23 {
 		return -1 // Invalid index
 	}
@@ -105,10 +110,10 @@ func generateScheduleBlock(schedule FullConfigSchedule) []uint16 {
 	return scheduleBlock
 }
 
-// ---  PushConfigurationToPLCs ---
-func PushConfigurationToPLCs(cfg Config, data *FullConfigurationData) error {
-	log.Println("Starting configuration push to all PLCs...")
-
+// compileConfiguration translates the WordPress config into register data:
+// the 70-register block for each schedule slot 1-12 (DS100-DS939) and each
+// PLC's 24-register map of loop index to slot (DS1000-DS1023).
+func compileConfiguration(data *FullConfigurationData) (map[int][]uint16, map[int][]uint16) {
 	// 1. --- Schedule Remapping ---
 	// Create a map of [WordPress_DB_ID] -> [PLC_ID_1_to_12]
 	dbID_to_plcID := make(map[int]int)
@@ -118,10 +123,10 @@ func PushConfigurationToPLCs(cfg Config, data *FullConfigurationData) error {
 	for i, schedule := range data.Schedules {
 		plcID := i + 1 // 1-based index
 		if plcID > 12 {
-			log.Printf("Warning: More than 12 schedules in WordPress. Ignoring schedule '%s' (ID %d) and beyond.", schedule.ScheduleName, schedule.ID)
+			syncLog.Warn("more than 12 schedules in WordPress, ignoring the rest", "first_ignored", schedule.ScheduleName, "schedule_id", schedule.ID)
 			break
 		}
-		log.Printf("Mapping DB Sched ID %d (%s) -> PLC Sched Slot %d", schedule.ID, schedule.ScheduleName, plcID)
+		syncLog.Debug("schedule slot assigned", "schedule_id", schedule.ID, "schedule", schedule.ScheduleName, "slot", plcID)
 		dbID_to_plcID[schedule.ID] = plcID
 		plcScheduleBlocks[plcID] = generateScheduleBlock(schedule)
 	}
@@ -134,10 +139,8 @@ func PushConfigurationToPLCs(cfg Config, data *FullConfigurationData) error {
 	}
 
 	// Create a map for each PLC's schedule map
-	// map[plcID 1 or 2] -> [24-register-array]
+	// map[plcID] -> [24-register-array], for every PLC a mapping names
 	plcMaps := make(map[int][]uint16)
-	plcMaps[1] = make([]uint16, 24) // Map for PLC 1
-	plcMaps[2] = make([]uint16, 24) // Map for PLC 2
 
 	// Populate the 24-register maps for each PLC
 	for _, mapping := range data.Mappings {
@@ -147,7 +150,7 @@ func PushConfigurationToPLCs(cfg Config, data *FullConfigurationData) error {
 
 		loopIndex := calculateLoopIndex(mapping.PLCOutputs[0])
 		if loopIndex == -1 {
-			log.Printf("Warning: Skipping mapping '%s' with invalid output '%s'", mapping.ID, mapping.PLCOutputs[0])
+			syncLog.Warn("skipping mapping with invalid output", "mapping", mapping.ID, "output", mapping.PLCOutputs[0])
 			continue
 		}
 
@@ -158,87 +161,50 @@ func PushConfigurationToPLCs(cfg Config, data *FullConfigurationData) error {
 		zoneID := mapping.LinkedZoneIDs[0]
 		schedDB_ID := zone_to_schedDB_ID[zoneID]
 		plcSchedID := dbID_to_plcID[schedDB_ID] // This is the new ID (1-12) or 0
-		
-		if _, ok := plcMaps[mapping.PLCID]; ok {
-			plcMaps[mapping.PLCID][loopIndex] = uint16(plcSchedID)
-		}
-	}
 
-	// 3. --- Write Blocks to PLCs ---
-	for plcID, host := range cfg.PLCs {
-		log.Printf("Connecting to PLC %d at %s...", plcID, host)
-		handler := modbus.NewTCPClientHandler(host)
-		handler.Timeout = 10 * time.Second
-		client := modbus.NewClient(handler)
-		err := handler.Connect()
-		if err != nil {
-			log.Printf("  - ERROR connecting to PLC %d: %v", plcID, err)
+		if mapping.PLCID < 1 {
+			syncLog.Warn("skipping mapping with invalid PLC ID", "mapping", mapping.ID, "plc", mapping.PLCID)
 			continue
 		}
-		defer handler.Close()
-
-		// A. Write all 12 Schedule Blocks
-		log.Printf("  - Writing 12 schedule blocks to PLC %d...", plcID)
-		for i := 1; i <= 12; i++ {
-			startAddress := scheduleIDToModbusAddress(i) // Gets 99, 169, 239...
-			blockData, ok := plcScheduleBlocks[i]
-			if !ok {
-				blockData = make([]uint16, 70) // Send an empty block
-			}
-			
-			_, err := client.WriteMultipleRegisters(startAddress, uint16(len(blockData)), u16SliceToBytes(blockData))
-			if err != nil {
-				log.Printf("  - ERROR writing schedule slot %d to PLC %d: %v", i, plcID, err)
-			}
+		if _, ok := plcMaps[mapping.PLCID]; !ok {
+			plcMaps[mapping.PLCID] = make([]uint16, 24)
 		}
+		plcMaps[mapping.PLCID][loopIndex] = uint16(plcSchedID)
+	}
+	return plcScheduleBlocks, plcMaps
+}
 
-		// B. Write the 24-register Map Block
-		mapBlock, ok := plcMaps[plcID]
-		if !ok {
-			log.Printf("  - ERROR: No map block found for PLC %d", plcID)
-			continue
-		}
-		
-		log.Printf("  - Writing 24-register map block to PLC %d...", plcID)
-		const mapStartAddress = 999 // DS1000
-		_, err = client.WriteMultipleRegisters(mapStartAddress, uint16(len(mapBlock)), u16SliceToBytes(mapBlock))
-		if err != nil {
-			log.Printf("  - ERROR writing map block to PLC %d: %v", plcID, err)
-		}
+// errZoneHasNoLights means a zone exists but none of its mappings can be pulsed.
+var errZoneHasNoLights = errors.New("no valid, mapped lights found")
 
-		// C. Set the Sync Request Bit (C151)
-		log.Println("  - All data written. Requesting PLC re-sync...")
-		syncRequestAddr, _ := cBitToModbusAddress(151) // C151
-		_, err = client.WriteSingleCoil(syncRequestAddr, 0xFF00)
-		if err != nil {
-			log.Printf("  - ERROR requesting re-sync (SET C151) on PLC %d: %v", plcID, err)
-		}
+// PulseZone
+// Returns the coils that were written (e.g. "PLC1:C203"), for the audit log.
+// The pulses go through the per-PLC queues, which may refuse them with a *ThrottledError.
+func PulseZone(pulses *PulseQueues, sessions *PLCSessions, configData *FullConfigurationData, zoneID int, state string) ([]string, error) {
+	targets, err := zonePulses(sessions, configData, zoneID, state)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Println("Configuration push finished.")
-	return nil
-}
+	modbusLog.Info("sending zone pulses", "zone", zoneID, "lights", len(targets))
 
+	// --- Queue every light and wait for the writes ---
+	return pulses.Submit(targets) // Returns the last error encountered, if any
+}
 
-// PulseZone
-func PulseZone(cfg Config, configData *FullConfigurationData, zoneID int, state string) error {
-	log.Printf("Received override for Zone %d. Finding ALL associated lights...", zoneID)
+// zonePulses lists the pulses that switch every light in a zone.
+func zonePulses(sessions *PLCSessions, configData *FullConfigurationData, zoneID int, state string) ([]pulse, error) {
+	modbusLog.Debug("finding all lights for zone", "zone", zoneID)
 
 	// --- Create a list of all lights to pulse ---
-	type pulseTarget struct {
-		host      string
-		loopIndex int
-		outputs   []string // For logging
-	}
-	var targets []pulseTarget
+	var targets []pulse
 
 	for _, mapping := range configData.Mappings {
 		for _, linkedZoneID := range mapping.LinkedZoneIDs {
 			if linkedZoneID == zoneID {
 				// Found a match. Get its info.
-				host, ok := cfg.PLCs[mapping.PLCID]
-				if !ok {
-					log.Printf("Warning: Skipping pulse for Zone %d. Mapping %d has invalid PLCID %d.", zoneID, mapping.ID, mapping.PLCID)
+				if _, ok := sessions.Get(mapping.PLCID); !ok {
+					modbusLog.Warn("skipping pulse, mapping has invalid PLC ID", "zone", zoneID, "mapping", mapping.ID, "plc", mapping.PLCID)
 					continue // Skip this mapping
 				}
 
@@ -248,11 +214,11 @@ func PulseZone(cfg Config, configData *FullConfigurationData, zoneID int, state
 
 				loopIndex := calculateLoopIndex(mapping.PLCOutputs[0])
 				if loopIndex == -1 {
-					log.Printf("Warning: Skipping pulse for Zone %d. Mapping %d has invalid output %s.", zoneID, mapping.ID, mapping.PLCOutputs[0])
+					modbusLog.Warn("skipping pulse, mapping has invalid output", "zone", zoneID, "mapping", mapping.ID, "output", mapping.PLCOutputs[0])
 					continue // Skip this mapping
 				}
 
-				targets = append(targets, pulseTarget{host: host, loopIndex: loopIndex, outputs: mapping.PLCOutputs})
+				targets = append(targets, pulse{PLCID: mapping.PLCID, LoopIndex: loopIndex, State: state, Output: mapping.PLCOutputs[0]})
 
 				// Do NOT break; continue searching for more mappings for this zone
 			}
@@ -260,58 +226,29 @@ func PulseZone(cfg Config, configData *FullConfigurationData, zoneID int, state
 	}
 
 	if len(targets) == 0 {
-		return fmt.Errorf("no valid, mapped lights found for ZoneID %d", zoneID)
+		return nil, fmt.Errorf("%w: ZoneID %d", errZoneHasNoLights, zoneID)
 	}
-
-	log.Printf("Zone %d is linked to %d lights. Sending pulses...", zoneID, len(targets))
-
-	var lastErr error
-
-	// --- Iterate and pulse every light ---
-	for _, target := range targets {
-		onCbitAddr, _ := cBitToModbusAddress(201 + target.loopIndex)
-		offCbitAddr, _ := cBitToModbusAddress(251 + target.loopIndex)
-		var addrToSet uint16
-		var stateStr string
-
-		if state == "on" {
-			addrToSet = onCbitAddr
-			stateStr = fmt.Sprintf("RequestON (C%d)", 201+target.loopIndex)
-		} else {
-			addrToSet = offCbitAddr
-			stateStr = fmt.Sprintf("RequestOFF (C%d)", 251+target.loopIndex)
-		}
-
-		log.Printf("  -> Pulsing %s (%s) on PLC %s (Loop %d)", stateStr, target.outputs[0], target.host, target.loopIndex+1)
-
-		// Send the pulse
-		err := setPLCBit(target.host, addrToSet)
-		if err != nil {
-			log.Printf("  -> ERROR pulsing %s: %v", target.host, err)
-			lastErr = err // Store the last error we saw
-		}
-	}
-
-	return lastErr // Return nil if no errors, or the last error encountered
+	return targets, nil
 }
 
-
 // cBitToModbusAddress
 func cBitToModbusAddress(cBit int) (uint16, error) {
-    // On CLICK PLC, C Control Relays start at Modbus address 16384 (0x4000).
-    const cBitBaseAddress = 16384
-
-    if cBit < 1 {
-        return 0, fmt.Errorf("c bit %d is out of supported range", cBit)
-    }
-    
-    // C1 -> 16384, C101 -> 16484
-    return uint16(cBitBaseAddress + cBit - 1), nil
+	// On CLICK PLC, C Control Relays start at Modbus address 16384 (0x4000).
+	const cBitBaseAddress = 16384
+
+	if cBit < 1 {
+		return 0, fmt.Errorf("c bit %d is out of supported range", cBit)
+	}
+
+	// C1 -> 16384, C101 -> 16484
+	return uint16(cBitBaseAddress + cBit - 1), nil
 }
 
-// ReadStatusFromPLCs
-func ReadStatusFromPLCs(cfg Config, configData *FullConfigurationData) (map[string]interface{}, error) {
-	// log.Println("Reading real-time status from all PLCs.")
+// ReadStatusFromPLCs reads every PLC into the flat status map. PLCs that
+// can't be read are listed under UnreachablePLCs; if none can be read it
+// returns the first *PLCError instead.
+func ReadStatusFromPLCs(sessions *PLCSessions, configData *FullConfigurationData) (map[string]interface{}, error) {
+	modbusLog.Debug("reading real-time status from all PLCs")
 	fullStatus := make(map[string]interface{})
 
 	// 1. Build Output Lookup (PLC-Index -> UI Key)
@@ -319,13 +256,17 @@ func ReadStatusFromPLCs(cfg Config, configData *FullConfigurationData) (map[stri
 	plcLoopIndices := make(map[int][]int)
 
 	for _, mapping := range configData.Mappings {
-		if len(mapping.PLCOutputs) == 0 { continue }
+		if len(mapping.PLCOutputs) == 0 {
+			continue
+		}
 		loopIndex := calculateLoopIndex(mapping.PLCOutputs[0])
-		if loopIndex == -1 { continue }
+		if loopIndex == -1 {
+			continue
+		}
 
 		yName := mapping.PLCOutputs[0]
 		uniqueKey := fmt.Sprintf("PLC%d-%s", mapping.PLCID, yName)
-		
+
 		lookupID := fmt.Sprintf("%d-%d", mapping.PLCID, loopIndex)
 		loopIndexToMapKey[lookupID] = uniqueKey
 		plcLoopIndices[mapping.PLCID] = append(plcLoopIndices[mapping.PLCID], loopIndex)
@@ -335,7 +276,7 @@ func ReadStatusFromPLCs(cfg Config, configData *FullConfigurationData) (map[stri
 	// We must replicate the sorting logic from PushConfigurationToPLCs
 	// to know which DB ID landed in which Slot.
 	plcSlotToDBID := make(map[int]int)
-	
+
 	// Create a copy of schedules to sort without affecting original
 	// Actually, the original is already sorted by FetchConfigurationFromAPI query?
 	// The API returns "ORDER BY schedule_name ASC".
@@ -348,75 +289,106 @@ func ReadStatusFromPLCs(cfg Config, configData *FullConfigurationData) (map[stri
 		}
 	}
 
-	for plcID, host := range cfg.PLCs {
-		// log.Printf("Polling PLC %d at %s", plcID, host)
-		handler := modbus.NewTCPClientHandler(host)
-		handler.Timeout = 5 * time.Second
-		client := modbus.NewClient(handler)
-		err := handler.Connect()
+	reporter := sessions.Reporter()
+	all := sessions.All()
+	var unreachable []int
+	var firstErr error
+	for _, session := range all {
+		plcID := session.ID
+		info := session.Info()
+		modbusLog.Debug("polling PLC", "plc", plcID, "host", session.Host)
+		err := session.Do(func(client modbus.Client) error {
+			return readPLCStatus(client, plcID, info, plcID == reporter, loopIndexToMapKey, plcSlotToDBID, fullStatus)
+		})
 		if err != nil {
-			log.Printf("  - ERROR connecting to PLC %d: %v", plcID, err)
-			continue
+			modbusLog.Error("could not read PLC status", "plc", plcID, "host", session.Host, "err", err)
+			unreachable = append(unreachable, plcID)
+			firstErr = cmp.Or(firstErr, err)
 		}
-		defer handler.Close()
-
-		// Read C101-C124 (Outputs)
-		stateBitsAddr, _ := cBitToModbusAddress(101) 
-		numStateBits := uint16(24)
-		resultBytes, err := client.ReadCoils(stateBitsAddr, numStateBits)
-		
-		if err == nil {
-			for i := 0; i < int(numStateBits); i++ {
-				lookupID := fmt.Sprintf("%d-%d", plcID, i)
-				uiKey, ok := loopIndexToMapKey[lookupID]
-				if !ok { continue }
+	}
 
-				byteIndex := i / 8
-				bitIndex := uint(i % 8)
-				if len(resultBytes) > byteIndex {
-					bitValue := (resultBytes[byteIndex] >> bitIndex) & 1
-					fullStatus[uiKey] = (bitValue == 1)
-				}
+	// With some PLCs read, the others' lights are left out and listed, so the
+	// monitor page still shows what it can. With none, there is no status.
+	if len(all) > 0 && len(unreachable) == len(all) {
+		return nil, firstErr // A *PLCError from the session
+	}
+	if len(unreachable) > 0 {
+		fullStatus["UnreachablePLCs"] = unreachable
+	}
+	return fullStatus, nil
+}
+
+// readPLCStatus reads the output state bits, schedule bits and photocell from one PLC
+// into fullStatus. Only the schedule reporter's schedule bits and photocell are the
+// Sched and Photocell keys. It returns the first read error so the session can reconnect.
+func readPLCStatus(client modbus.Client, plcID int, info PLCConfig, reporter bool, loopIndexToMapKey map[string]string, plcSlotToDBID map[int]int, fullStatus map[string]interface{}) error {
+	// Read C101-C124 (Outputs)
+	stateBitsAddr, _ := cBitToModbusAddress(101)
+	numStateBits := uint16(24)
+	resultBytes, err := client.ReadCoils(stateBitsAddr, numStateBits)
+
+	if err == nil {
+		for i := 0; i < int(numStateBits); i++ {
+			lookupID := fmt.Sprintf("%d-%d", plcID, i)
+			uiKey, ok := loopIndexToMapKey[lookupID]
+			if !ok {
+				continue
+			}
+
+			byteIndex := i / 8
+			bitIndex := uint(i % 8)
+			if len(resultBytes) > byteIndex {
+				bitValue := (resultBytes[byteIndex] >> bitIndex) & 1
+				fullStatus[uiKey] = (bitValue == 1)
 			}
 		}
+	}
+
+	// Read C1-C12 (Schedules) - schedule reporter only
+	var schedErr, photoErr error
+	if reporter {
+		schedBitsAddr, _ := cBitToModbusAddress(1)
+		numSchedBits := uint16(12)
+		var schedResults []byte
+		schedResults, schedErr = client.ReadCoils(schedBitsAddr, numSchedBits)
+
+		if schedErr == nil {
+			for i := 0; i < int(numSchedBits); i++ {
+				byteIndex := i / 8
+				bitIndex := uint(i % 8)
+				if len(schedResults) > byteIndex {
+					val := (schedResults[byteIndex] >> bitIndex) & 1
 
-		// Read C1-C12 (Schedules) - Lodge Only
-		if plcID == 1 {
-			schedBitsAddr, _ := cBitToModbusAddress(1)
-			numSchedBits := uint16(12)
-			schedResults, err := client.ReadCoils(schedBitsAddr, numSchedBits)
-			
-			if err == nil {
-				for i := 0; i < int(numSchedBits); i++ {
-					byteIndex := i / 8
-					bitIndex := uint(i % 8)
-					if len(schedResults) > byteIndex {
-						val := (schedResults[byteIndex] >> bitIndex) & 1
-						
-						// FIX: Map Slot ID (i+1) back to DB ID
-						slotID := i + 1
-						if dbID, ok := plcSlotToDBID[slotID]; ok {
-							fullStatus[fmt.Sprintf("Sched%d", dbID)] = (val == 1)
-						}
+					// FIX: Map Slot ID (i+1) back to DB ID
+					slotID := i + 1
+					if dbID, ok := plcSlotToDBID[slotID]; ok {
+						fullStatus[fmt.Sprintf("Sched%d", dbID)] = (val == 1)
 					}
 				}
 			}
 		}
+	}
 
-		// Read Photocell
-		if plcID == 1 {
-			photocellAddr, _ := cBitToModbusAddress(154)
-			result, err := client.ReadCoils(photocellAddr, 1)
-			if err == nil && len(result) > 0 {
-				fullStatus["Photocell"] = (result[0] & 1) == 1
-			}
+	// Read the master flag (C153) and photocell (C154) from every PLC with a
+	// photocell, so the Cabana -> Lodge RECV link can be checked. "Photocell"
+	// is the schedule reporter's for the UI.
+	if !info.Can(capPhotocell) {
+		return cmp.Or(err, schedErr)
+	}
+	masterAddr, _ := cBitToModbusAddress(153)
+	var result []byte
+	result, photoErr = client.ReadCoils(masterAddr, 2)
+	if photoErr == nil && len(result) > 0 {
+		dark := (result[0]>>1)&1 == 1
+		fullStatus[fmt.Sprintf("PLC%d-Master", plcID)] = result[0]&1 == 1
+		fullStatus[fmt.Sprintf("PLC%d-Photocell", plcID)] = dark
+		if reporter {
+			fullStatus["Photocell"] = dark
 		}
 	}
-
-	return fullStatus, nil
+	return cmp.Or(err, schedErr, photoErr)
 }
 
-
 // --- Helper Functions ---
 
 // yOutputToModbusAddress ( used by simulator)
@@ -493,6 +465,50 @@ func triggerToPLCData(trigger string, t *string) (uint16, uint16) {
 	return triggerCode, timeCode
 }
 
+// decodeScheduleBlock turns a 70-register schedule block back into spans, the
+// inverse of generateScheduleBlock. Spans that are all zero are unused and
+// left out.
+// The PLC stores a photocell trigger as a non-zero code without saying which,
+// so it reads back as SUNDOWN for "on" and SUNRISE for "off".
+func decodeScheduleBlock(block []uint16) []FullConfigSpan {
+	spans := []FullConfigSpan{}
+	for offset := 0; offset+5 <= len(block); offset += 5 {
+		if slices.Equal(block[offset:offset+5], []uint16{0, 0, 0, 0, 0}) {
+			continue
+		}
+		span := FullConfigSpan{DaysOfWeek: bitmaskToDays(block[offset])}
+		span.OnTrigger, span.OnTime = plcDataToTrigger(block[offset+1], block[offset+2], "SUNDOWN")
+		span.OffTrigger, span.OffTime = plcDataToTrigger(block[offset+3], block[offset+4], "SUNRISE")
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// bitmaskToDays is the inverse of daysToBitmask.
+func bitmaskToDays(mask uint16) []string {
+	days := []string{}
+	for i, day := range []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"} {
+		if mask&(1<<i) != 0 {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// plcDataToTrigger is the inverse of triggerToPLCData. The time is "HH:MM",
+// or nil for a photocell trigger with no time.
+func plcDataToTrigger(triggerCode, timeCode uint16, photocell string) (string, *string) {
+	trigger := "TIME"
+	if triggerCode != 0 {
+		trigger = photocell
+	}
+	if trigger != "TIME" && timeCode == 0 {
+		return trigger, nil
+	}
+	t := fmt.Sprintf("%02d:%02d", timeCode/100, timeCode%100)
+	return trigger, &t
+}
+
 func u16SliceToBytes(data []uint16) []byte {
 	bytes := make([]byte, len(data)*2)
 	for i, v := range data {
@@ -502,26 +518,24 @@ func u16SliceToBytes(data []uint16) []byte {
 	return bytes
 }
 
-func SetPLCTime(host string) error {
-	handler := modbus.NewTCPClientHandler(host)
-	handler.Timeout = 5 * time.Second
-	client := modbus.NewClient(handler)
-	err := handler.Connect()
-	if err != nil {
-		return fmt.Errorf("SetPLCTime connect error: %w", err)
-	}
-	defer handler.Close()
+// SetPLCTime sets the PLC's clock and day of the week to now.
+func SetPLCTime(session *PLCSession, now time.Time) error {
+	return session.Do(func(client modbus.Client) error {
+		return writePLCTime(client, session.Host, now)
+	})
+}
 
-	now := time.Now()
+// writePLCTime writes now into the CLICK "new date/time" registers and latches them.
+func writePLCTime(client modbus.Client, host string, now time.Time) error {
 
-	// Mapping for CLICK PLC (Contiguous Registers):
-	// SD29 (Addr 28): New Year
-	// SD30 (Addr 29): New Month
-	// SD31 (Addr 30): New Day
-	// SD32 (Addr 31): New Day of Week (Required!)
-	// SD33 (Addr 32): New Hour
-	// SD34 (Addr 33): New Minute
-	// SD35 (Addr 34): New Second
+	// Mapping for CLICK PLC (Contiguous Registers, SD1 = Modbus 61440):
+	// SD29 (Addr 61468): New Year
+	// SD30 (Addr 61469): New Month
+	// SD31 (Addr 61470): New Day
+	// SD32 (Addr 61471): New Day of Week (Required!)
+	// SD33 (Addr 61472): New Hour
+	// SD34 (Addr 61473): New Minute
+	// SD35 (Addr 61474): New Second
 
 	data := []uint16{
 		uint16(now.Year()),        // SD29
@@ -534,51 +548,53 @@ func SetPLCTime(host string) error {
 	}
 
 	byteData := u16SliceToBytes(data)
-	
-	// Write to SD29 (Address 28)
-	_, err = client.WriteMultipleRegisters(28, uint16(len(data)), byteData)
+
+	// Write to SD29. Address 28 would be DS29.
+	_, err := client.WriteMultipleRegisters(sdToModbusAddress(29), uint16(len(data)), byteData)
 	if err != nil {
 		return fmt.Errorf("failed to write new time registers: %w", err)
 	}
 
 	// Trigger Date Update (SC53 at 61492)
-	_, err = client.WriteSingleCoil(61492, 0xFF00) 
+	_, err = client.WriteSingleCoil(61492, 0xFF00)
 	if err != nil {
 		return fmt.Errorf("failed to set SC53 (Date Update): %w", err)
 	}
-	
+
 	// Trigger Time Update (SC55 at 61494)
 	_, err = client.WriteSingleCoil(61494, 0xFF00)
 	if err != nil {
 		return fmt.Errorf("failed to set SC55 (Time Update): %w", err)
 	}
 
-	log.Printf("Successfully set time on %s to: %v", host, now.Format(time.RFC3339))
+	modbusLog.Info("set PLC time", "host", host, "time", now.Format(time.RFC3339))
 	return nil
 }
 
+func setPLCBit(session *PLCSession, address uint16) error {
+	return session.Do(func(client modbus.Client) error {
+		_, err := client.WriteSingleCoil(address, 0xFF00)
+		if err != nil {
+			return fmt.Errorf("failed to write bit: %w", err)
+		}
+		return nil
+	})
+}
 
-func setPLCBit(host string, address uint16) error {
-	handler := modbus.NewTCPClientHandler(host)
-	handler.Timeout = 5 * time.Second
-	client := modbus.NewClient(handler)
-	err := handler.Connect()
-	if err != nil {
-		return fmt.Errorf("setPLCBit connect error: %w", err)
-	}
-	defer handler.Close()
-	_, err = client.WriteSingleCoil(address, 0xFF00)
+// PulseMapping triggers a specific mapping (single light) for testing hardware.
+// Returns the coil that was written, for the audit log.
+func PulseMapping(pulses *PulseQueues, sessions *PLCSessions, configData *FullConfigurationData, mappingID int, state string) ([]string, error) {
+	modbusLog.Debug("received test command", "mapping", mappingID)
+	p, err := mappingPulse(sessions, configData, mappingID, state)
 	if err != nil {
-		return fmt.Errorf("failed to write bit: %w", err)
+		return nil, err
 	}
-	return nil
+	modbusLog.Info("test pulse", "mapping", mappingID, "plc", p.PLCID, "state", state)
+	return pulses.Submit([]pulse{p})
 }
 
-
-
-// PulseMapping triggers a specific mapping (single light) for testing hardware.
-func PulseMapping(cfg Config, configData *FullConfigurationData, mappingID int, state string) error {
-	log.Printf("Received TEST command for Mapping ID %d...", mappingID)
+// mappingPulse returns the pulse that switches one mapping.
+func mappingPulse(sessions *PLCSessions, configData *FullConfigurationData, mappingID int, state string) (pulse, error) {
 
 	var targetMapping *FullConfigMapping
 	for _, m := range configData.Mappings {
@@ -589,38 +605,22 @@ func PulseMapping(cfg Config, configData *FullConfigurationData, mappingID int,
 	}
 
 	if targetMapping == nil {
-		return fmt.Errorf("mapping ID %d not found", mappingID)
+		return pulse{}, apiError(http.StatusNotFound, codeMappingNotFound, "mapping %d not found", mappingID)
 	}
 
+	// The rest are mistakes in the WordPress config, not in the request.
 	if len(targetMapping.PLCOutputs) == 0 {
-		return fmt.Errorf("mapping ID %d has no outputs defined", mappingID)
+		return pulse{}, apiError(http.StatusUnprocessableEntity, codeMappingHasNoOutputs, "mapping %d has no outputs defined", mappingID)
 	}
 
-	host, ok := cfg.PLCs[targetMapping.PLCID]
-	if !ok {
-		return fmt.Errorf("invalid PLCID %d", targetMapping.PLCID)
+	if _, ok := sessions.Get(targetMapping.PLCID); !ok {
+		return pulse{}, apiError(http.StatusUnprocessableEntity, codePLCNotConfigured, "mapping %d is on PLC %d, which the service has no address for", mappingID, targetMapping.PLCID)
 	}
 
 	loopIndex := calculateLoopIndex(targetMapping.PLCOutputs[0])
 	if loopIndex == -1 {
-		return fmt.Errorf("invalid output %s", targetMapping.PLCOutputs[0])
-	}
-
-	// Calculate addresses
-	onCbitAddr, _ := cBitToModbusAddress(201 + loopIndex)
-	offCbitAddr, _ := cBitToModbusAddress(251 + loopIndex)
-	var addrToSet uint16
-	var stateStr string
-
-	if state == "on" {
-		addrToSet = onCbitAddr
-		stateStr = fmt.Sprintf("RequestON (C%d)", 201+loopIndex)
-	} else {
-		addrToSet = offCbitAddr
-		stateStr = fmt.Sprintf("RequestOFF (C%d)", 251+loopIndex)
+		return pulse{}, apiError(http.StatusUnprocessableEntity, codeInvalidOutput, "mapping %d output %q is not a Y output the PLC can switch", mappingID, targetMapping.PLCOutputs[0])
 	}
 
-	log.Printf("  -> TEST PULSE: %s on PLC %s", stateStr, host)
-	return setPLCBit(host, addrToSet)
+	return pulse{PLCID: targetMapping.PLCID, LoopIndex: loopIndex, State: state, Output: targetMapping.PLCOutputs[0]}, nil
 }
-
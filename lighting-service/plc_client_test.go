@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// fakeWordPress serves data as the full-config endpoint.
+func fakeWordPress(t *testing.T, data *FullConfigurationData) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wp-json/fsbhoa-lighting/v1/full-config" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestReadStatusFromPLCs(t *testing.T) {
+	data := testImageConfig() // Y101 and Y203 on PLC 1, slots 1 and 2
+	data.Mappings = append(data.Mappings, FullConfigMapping{ID: 13, PLCID: 2, PLCOutputs: []string{"Y101"}, LinkedZoneIDs: []int{3}})
+
+	tests := []struct {
+		name            string
+		down            []int // PLCs that can't be read
+		wantKeys        []string
+		wantUnreachable []int
+		wantErr         bool
+	}{
+		{name: "all read", wantKeys: []string{"PLC1-Y101", "PLC1-Y203", "PLC2-Y101", "Sched7", "Photocell"}},
+		{name: "PLC 2 down", down: []int{2}, wantKeys: []string{"PLC1-Y101", "PLC1-Y203", "Sched7"}, wantUnreachable: []int{2}},
+		{name: "reporter down", down: []int{1}, wantKeys: []string{"PLC2-Y101"}, wantUnreachable: []int{1}},
+		{name: "all down", down: []int{1, 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plcs := map[int]PLCConfig{}
+			for id := 1; id <= 2; id++ {
+				plc, addr := startFakeRegisterPLC(t)
+				plc.c[100] = true // C101: loop 0 on
+				plc.failReads = slices.Contains(tt.down, id)
+				plcs[id] = PLCConfig{Address: addr}
+			}
+			sessions := NewPLCSessions(plcs)
+			t.Cleanup(sessions.CloseAll)
+
+			status, err := ReadStatusFromPLCs(sessions, data)
+			if tt.wantErr {
+				var plcErr *PLCError
+				if !errors.As(err, &plcErr) {
+					t.Fatalf("error %v, want a *PLCError", err)
+				}
+				if ae := toAPIError(err); ae.Code != codePLCUnreachable || ae.Status != http.StatusBadGateway {
+					t.Errorf("classified as %d %s, want 502 %s", ae.Status, ae.Code, codePLCUnreachable)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := status[key]; !ok {
+					t.Errorf("no %s in %v", key, status)
+				}
+			}
+			if status["PLC1-Y101"] != nil && status["PLC1-Y101"] != true {
+				t.Errorf("PLC1-Y101 is %v, want true", status["PLC1-Y101"])
+			}
+			got, _ := status["UnreachablePLCs"].([]int)
+			if !slices.Equal(got, tt.wantUnreachable) {
+				t.Errorf("UnreachablePLCs %v, want %v", got, tt.wantUnreachable)
+			}
+		})
+	}
+}
+
+// TestStatusUnreachable checks that a PLC error gets through the status
+// endpoints rather than an empty 200.
+func TestStatusUnreachable(t *testing.T) {
+	app := newTestApp(t)
+	plc, addr := startFakeRegisterPLC(t)
+	plc.failReads = true
+	app.cfg.PLCs = map[int]PLCConfig{1: {Address: addr}}
+	app.cfg.WordPressAPIBaseURL = fakeWordPress(t, testImageConfig())
+	app.cfg.AuthReadMode = "none"
+	app.sessions.Rebuild(app.cfg.PLCs)
+	t.Cleanup(app.sessions.CloseAll)
+	router := app.newRouter()
+
+	for _, path := range []string{"/api/v1/status", "/status", "/api/v1/zones", "/api/v1/mappings"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var body errorResponse
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != http.StatusBadGateway || body.Error.Code != codePLCUnreachable {
+			t.Errorf("%s: %d %s, want 502 %s", path, rec.Code, body.Error.Code, codePLCUnreachable)
+		}
+	}
+}
+
+func TestMappingPulse(t *testing.T) {
+	data := &FullConfigurationData{Mappings: []FullConfigMapping{
+		{ID: 1, PLCID: 1, PLCOutputs: []string{"Y203"}},
+		{ID: 2, PLCID: 1},
+		{ID: 3, PLCID: 9, PLCOutputs: []string{"Y101"}},
+		{ID: 4, PLCID: 1, PLCOutputs: []string{"Y000"}},
+	}}
+	sessions := NewPLCSessions(map[int]PLCConfig{1: {Address: "127.0.0.1:502"}})
+	t.Cleanup(sessions.CloseAll)
+
+	tests := []struct {
+		mappingID  int
+		wantStatus int // 0 for success
+		wantCode   string
+	}{
+		{1, 0, ""},
+		{2, http.StatusUnprocessableEntity, codeMappingHasNoOutputs},
+		{3, http.StatusUnprocessableEntity, codePLCNotConfigured},
+		{4, http.StatusUnprocessableEntity, codeInvalidOutput},
+		{5, http.StatusNotFound, codeMappingNotFound},
+	}
+	for _, tt := range tests {
+		p, err := mappingPulse(sessions, data, tt.mappingID, "on")
+		if tt.wantStatus == 0 {
+			if err != nil || p.PLCID != 1 || p.LoopIndex != 9 || p.Output != "Y203" {
+				t.Errorf("mapping %d: got %+v, %v", tt.mappingID, p, err)
+			}
+			continue
+		}
+		if ae := toAPIError(err); ae.Status != tt.wantStatus || ae.Code != tt.wantCode {
+			t.Errorf("mapping %d: %d %s (%v), want %d %s", tt.mappingID, ae.Status, ae.Code, err, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// The ladder runs the schedules off the CLICK real-time clock:
+//
+//	SD19 year   SD21 month  SD22 day     SD23 day of week (1 = Sunday)
+//	SD24 hour   SD25 minute SD26 second  (SD20 is the 2-digit year)
+//
+// The PLCs keep it right with NTP, but when that fails the clock drifts and
+// lights switch at the wrong time with no error anywhere. The service reads the
+// clock every ClockCheckMinutes and reports how far off it is. It only sets the
+// clock (SetPLCTime) when ClockCorrectSeconds is on and the drift reaches it,
+// or when SD23 does not match the date, since the day masks depend on it.
+// The clock holds local time in the configured Timezone, so it should move
+// an hour at each DST change; one that didn't shows up an hour off.
+
+const (
+	clockFirstSD = 19 // SD19-SD26
+	clockSDCount = 8
+)
+
+// sdToModbusAddress converts a CLICK system data register number to its
+// Modbus address: SD1 is 61440.
+func sdToModbusAddress(sd int) uint16 {
+	return uint16(61440 + sd - 1)
+}
+
+// PLCClock is the last clock check of one PLC.
+type PLCClock struct {
+	Checked      time.Time  `json:"checked"`
+	Time         *time.Time `json:"time,omitempty"`      // What the PLC's clock said
+	DriftSeconds int        `json:"drift_seconds"`       // PLC minus host; positive means the PLC is fast
+	Weekday      int        `json:"weekday"`             // SD23, 1 = Sunday
+	WeekdayOK    bool       `json:"weekday_ok"`          // SD23 matches the PLC's date
+	Corrected    *time.Time `json:"corrected,omitempty"` // Last time the service set this clock
+	Error        string     `json:"error,omitempty"`     // The clock could not be read
+}
+
+// ClockMonitor keeps the last clock check of every PLC.
+type ClockMonitor struct {
+	mu       sync.Mutex
+	plcs     map[int]PLCClock
+	alerting map[int]bool // For logging alerts once when they start
+}
+
+func NewClockMonitor() *ClockMonitor {
+	return &ClockMonitor{plcs: make(map[int]PLCClock), alerting: make(map[int]bool)}
+}
+
+// record stores a check, keeping the last correction time, and logs an alert
+// that starts or clears.
+func (m *ClockMonitor) record(id int, c PLCClock, cfg Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.Corrected == nil {
+		c.Corrected = m.plcs[id].Corrected
+	}
+	m.plcs[id] = c
+
+	alerts := clockAlerts(id, c, cfg)
+	switch {
+	case len(alerts) > 0 && !m.alerting[id]:
+		modbusLog.Warn("PLC clock alert", "plc", id, "alerts", alerts)
+	case len(alerts) == 0 && m.alerting[id] && c.Error == "":
+		modbusLog.Info("PLC clock alert cleared", "plc", id)
+	}
+	if c.Error == "" {
+		m.alerting[id] = len(alerts) > 0
+	}
+}
+
+// Clocks returns the last check of every PLC.
+func (m *ClockMonitor) Clocks() map[int]PLCClock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clocks := make(map[int]PLCClock, len(m.plcs))
+	for id, c := range m.plcs {
+		clocks[id] = c
+	}
+	return clocks
+}
+
+// Alerts describes every PLC clock that is off, in PLC order.
+func (m *ClockMonitor) Alerts(cfg Config) []string {
+	clocks := m.Clocks()
+	ids := make([]int, 0, len(clocks))
+	for id := range clocks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var alerts []string
+	for _, id := range ids {
+		alerts = append(alerts, clockAlerts(id, clocks[id], cfg)...)
+	}
+	return alerts
+}
+
+// clockAlerts describes what is wrong with a clock.
+func clockAlerts(id int, c PLCClock, cfg Config) []string {
+	if c.Time == nil {
+		return nil
+	}
+	var alerts []string
+	drift := time.Duration(c.DriftSeconds) * time.Second
+	limit := time.Duration(cfg.ClockAlertSeconds) * time.Second
+	switch {
+	case drift >= limit:
+		alerts = append(alerts, fmt.Sprintf("PLC %d clock is %s fast%s", id, drift, missedDST(drift, limit)))
+	case -drift >= limit:
+		alerts = append(alerts, fmt.Sprintf("PLC %d clock is %s slow%s", id, -drift, missedDST(drift, limit)))
+	}
+	if !c.WeekdayOK {
+		alerts = append(alerts, fmt.Sprintf("PLC %d clock has day of week %d for %s; schedules will use the wrong day", id, c.Weekday, c.Time.Format("Mon Jan 2")))
+	}
+	return alerts
+}
+
+// missedDST adds a hint when a clock is off by about an hour.
+func missedDST(drift, limit time.Duration) string {
+	if (drift.Abs() - time.Hour).Abs() < limit {
+		return "; it may have missed a DST change"
+	}
+	return ""
+}
+
+// readPLCClock reads SD19-SD26 and returns the PLC's time in loc and SD23.
+func readPLCClock(client modbus.Client, loc *time.Location) (time.Time, int, error) {
+	raw, err := client.ReadHoldingRegisters(sdToModbusAddress(clockFirstSD), clockSDCount)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to read clock registers: %w", err)
+	}
+	if len(raw) < clockSDCount*2 {
+		return time.Time{}, 0, fmt.Errorf("short read of clock registers: %d bytes", len(raw))
+	}
+	sd := func(n int) int {
+		return int(binary.BigEndian.Uint16(raw[(n-clockFirstSD)*2:]))
+	}
+	year, month, day, weekday := sd(19), sd(21), sd(22), sd(23)
+	hour, minute, second := sd(24), sd(25), sd(26)
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, 0, fmt.Errorf("clock registers hold an impossible time: %d-%d-%d %d:%d:%d", year, month, day, hour, minute, second)
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, loc), weekday, nil
+}
+
+// startClockMonitor checks the PLC clocks every ClockCheckMinutes.
+func (app *App) startClockMonitor(ctx context.Context) {
+	interval := time.Duration(app.config().ClockCheckMinutes) * time.Minute
+	if interval <= 0 {
+		modbusLog.Info("PLC clock check is off")
+		return
+	}
+	modbusLog.Info("starting PLC clock check", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	app.checkPLCClocks()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.checkPLCClocks()
+		}
+	}
+}
+
+// checkPLCClocks reads every PLC's clock and corrects it if configured to.
+func (app *App) checkPLCClocks() {
+	if app.isSimulationMode() {
+		return
+	}
+	done, err := app.beginPLCWork()
+	if err != nil {
+		return
+	}
+	defer done()
+	cfg := app.config()
+	for _, session := range app.sessions.With(capClock) {
+		app.clocks.record(session.ID, app.checkPLCClock(cfg, session), cfg)
+	}
+}
+
+func (app *App) checkPLCClock(cfg Config, session *PLCSession) PLCClock {
+	loc := cfg.location()
+	c := readClockCheck(session, loc)
+	drift := time.Duration(c.DriftSeconds) * time.Second
+	limit := time.Duration(cfg.ClockCorrectSeconds) * time.Second
+	if c.Time == nil || limit <= 0 || (drift < limit && -drift < limit && c.WeekdayOK) {
+		return c
+	}
+
+	modbusLog.Warn("correcting PLC clock", "plc", session.ID, "drift", drift, "weekday", c.Weekday)
+	audit := AuditEntry{Time: time.Now(), Caller: "clock", Action: "time"}
+	if err := SetPLCTime(session, time.Now().In(loc)); err != nil {
+		modbusLog.Error("failed to correct PLC clock", "plc", session.ID, "err", err)
+		app.finishAudit(audit, nil, err)
+		return c
+	}
+	app.finishAudit(audit, []string{fmt.Sprintf("PLC%d:SD29-SD35", session.ID)}, nil)
+	corrected := time.Now()
+
+	// Read it back so the report shows the result, not the drift that was fixed.
+	c = readClockCheck(session, loc)
+	c.Corrected = &corrected
+	return c
+}
+
+// readClockCheck reads one PLC's clock, as local time in loc, and compares it
+// with the host's.
+func readClockCheck(session *PLCSession, loc *time.Location) PLCClock {
+	var plcTime time.Time
+	var weekday int
+	err := session.Do(func(client modbus.Client) error {
+		var err error
+		plcTime, weekday, err = readPLCClock(client, loc)
+		return err
+	})
+	now := time.Now()
+	c := PLCClock{Checked: now}
+	if err != nil {
+		modbusLog.Warn("could not read PLC clock", "plc", session.ID, "err", err)
+		c.Error = err.Error()
+		return c
+	}
+	plcTime = resolveOverlap(plcTime, now)
+	c.Time = &plcTime
+	c.DriftSeconds = int(plcTime.Sub(now).Round(time.Second) / time.Second)
+	c.Weekday = weekday
+	c.WeekdayOK = weekday == int(plcTime.Weekday())+1
+	return c
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// setClock loads t into a fake PLC's SD19-SD26, with weekday as SD23.
+func (f *fakeRegisterPLC) setClock(t time.Time, weekday int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sd[18], f.sd[19], f.sd[20], f.sd[21] = uint16(t.Year()), uint16(t.Year()%100), uint16(t.Month()), uint16(t.Day())
+	f.sd[22], f.sd[23], f.sd[24], f.sd[25] = uint16(weekday), uint16(t.Hour()), uint16(t.Minute()), uint16(t.Second())
+}
+
+func TestCheckPLCClock(t *testing.T) {
+	const limit = 2 // Seconds of slack for the test's own running time
+	tests := []struct {
+		name         string
+		offset       time.Duration // PLC clock minus now
+		weekdayShift int           // Added to the right SD23
+		correct      int           // ClockCorrectSeconds
+		wantDrift    int
+		wantWeekday  bool
+		wantFixed    bool
+	}{
+		{name: "right", wantWeekday: true},
+		{name: "fast, not corrected", offset: 90 * time.Second, wantDrift: 90, wantWeekday: true},
+		{name: "slow, corrected", offset: -5 * time.Minute, correct: 60, wantWeekday: true, wantFixed: true},
+		{name: "within the correction limit", offset: 30 * time.Second, correct: 60, wantDrift: 30, wantWeekday: true},
+		{name: "wrong weekday", weekdayShift: 1},
+		{name: "wrong weekday, corrected", weekdayShift: 1, correct: 60, wantWeekday: true, wantFixed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			plc, addr := startFakeRegisterPLC(t)
+			app.cfg.Timezone = "America/Los_Angeles"
+			app.cfg.ClockCorrectSeconds = tt.correct
+			app.cfg.PLCs = map[int]PLCConfig{1: {Address: addr}}
+			app.sessions.Rebuild(app.cfg.PLCs)
+			t.Cleanup(app.sessions.CloseAll)
+			loc := app.cfg.location()
+
+			plcTime := time.Now().In(loc).Add(tt.offset)
+			plc.setClock(plcTime, (int(plcTime.Weekday())+tt.weekdayShift)%7+1)
+			session, _ := app.sessions.Get(1)
+			c := app.checkPLCClock(app.config(), session)
+
+			if c.Error != "" || c.Time == nil {
+				t.Fatalf("clock not read: %+v", c)
+			}
+			if d := c.DriftSeconds - tt.wantDrift; d < -limit || d > limit {
+				t.Errorf("drift %ds, want %ds", c.DriftSeconds, tt.wantDrift)
+			}
+			if c.WeekdayOK != tt.wantWeekday {
+				t.Errorf("weekday %d ok %v, want %v", c.Weekday, c.WeekdayOK, tt.wantWeekday)
+			}
+			if (c.Corrected != nil) != tt.wantFixed {
+				t.Errorf("corrected %v, want %v", c.Corrected, tt.wantFixed)
+			}
+			// The new time goes to SD29-SD35, not DS29-DS35.
+			plc.mu.Lock()
+			defer plc.mu.Unlock()
+			if got := plc.sd[28] != 0; got != tt.wantFixed {
+				t.Errorf("SD29 holds %d, want it written %v", plc.sd[28], tt.wantFixed)
+			}
+			for ds := 29; ds <= 35; ds++ {
+				if plc.ds[ds-1] != 0 {
+					t.Errorf("DS%d written: %d", ds, plc.ds[ds-1])
+				}
+			}
+		})
+	}
+}
+
+func TestReadPLCClockRejectsImpossibleTime(t *testing.T) {
+	app := newTestApp(t)
+	plc, addr := startFakeRegisterPLC(t)
+	app.cfg.PLCs = map[int]PLCConfig{1: {Address: addr}}
+	app.sessions.Rebuild(app.cfg.PLCs)
+	t.Cleanup(app.sessions.CloseAll)
+	plc.setClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), 1)
+	plc.sd[20] = 13 // SD21, month
+
+	session, _ := app.sessions.Get(1)
+	c := readClockCheck(session, time.UTC)
+	if c.Time != nil || !strings.Contains(c.Error, "impossible time: 2026-13-1 12:0:0") {
+		t.Errorf("got %+v, want an impossible time error", c)
+	}
+}
+
+func TestClockAlerts(t *testing.T) {
+	cfg := defaultConfig() // ClockAlertSeconds 60
+	sunday := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		clock PLCClock
+		want  []string
+	}{
+		{name: "not read", clock: PLCClock{Error: "timeout"}},
+		{name: "right", clock: PLCClock{Time: &sunday, DriftSeconds: 59, Weekday: 1, WeekdayOK: true}},
+		{name: "fast", clock: PLCClock{Time: &sunday, DriftSeconds: 60, Weekday: 1, WeekdayOK: true}, want: []string{"PLC 2 clock is 1m0s fast"}},
+		{
+			name:  "missed DST",
+			clock: PLCClock{Time: &sunday, DriftSeconds: -3590, Weekday: 1, WeekdayOK: true},
+			want:  []string{"PLC 2 clock is 59m50s slow; it may have missed a DST change"},
+		},
+		{
+			name:  "wrong weekday",
+			clock: PLCClock{Time: &sunday, Weekday: 2},
+			want:  []string{"PLC 2 clock has day of week 2 for Sun Mar 1; schedules will use the wrong day"},
+		},
+	}
+	for _, tt := range tests {
+		got := clockAlerts(2, tt.clock, cfg)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Each controller is one entry of PLCs in the config, keyed by the PLC ID that
+// WordPress mappings use. An entry can be just its address,
+//
+//	"1": "192.168.1.201:502"
+//
+// which gives it every capability and no roles, or an object:
+//
+//	"3": {"Address": "192.168.1.203:502", "Name": "Tennis",
+//	      "Roles": ["photocell_slave"], "Capabilities": ["schedules", "clock"]}
+
+// Roles a PLC can have. There is at most one photocell master and one
+// schedule reporter; any number of PLCs can be photocell slaves.
+const (
+	rolePhotocellMaster  = "photocell_master"  // Reads the sensor on X001 and sets C153
+	rolePhotocellSlave   = "photocell_slave"   // Copies C154 from the master over RECV
+	roleScheduleReporter = "schedule_reporter" // Its C1-C12 and C154 are the Sched and Photocell keys of /status
+)
+
+// Capabilities a PLC can have. A PLC that lists none has them all.
+const (
+	capSchedules = "schedules" // Runs the schedule ladder: DS100-DS1023 are pushed, compared and decoded
+	capPhotocell = "photocell" // Has C153/C154: link check and photocell force
+	capClock     = "clock"     // Its clock is checked and corrected
+)
+
+var (
+	validRoles        = []string{rolePhotocellMaster, rolePhotocellSlave, roleScheduleReporter}
+	validCapabilities = []string{capSchedules, capPhotocell, capClock}
+)
+
+// PLCConfig describes one controller.
+type PLCConfig struct {
+	Address      string   `json:"Address"` // host:port; empty leaves the PLC unconfigured
+	Name         string   `json:"Name,omitempty"`
+	Roles        []string `json:"Roles,omitempty"`
+	Capabilities []string `json:"Capabilities,omitempty"`
+}
+
+// UnmarshalJSON accepts a bare address as well as the object.
+func (p *PLCConfig) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '"' {
+		*p = PLCConfig{}
+		return json.Unmarshal(b, &p.Address)
+	}
+	type plain PLCConfig // Without this method
+	return json.Unmarshal(b, (*plain)(p))
+}
+
+// HasRole reports whether the PLC has the role.
+func (p PLCConfig) HasRole(role string) bool { return slices.Contains(p.Roles, role) }
+
+// Can reports whether the PLC has the capability.
+func (p PLCConfig) Can(capability string) bool {
+	return len(p.Capabilities) == 0 || slices.Contains(p.Capabilities, capability)
+}
+
+// label is the PLC's name for logs and messages, e.g. "PLC 3 (Tennis)".
+func (p PLCConfig) label(id int) string {
+	if p.Name == "" {
+		return fmt.Sprintf("PLC %d", id)
+	}
+	return fmt.Sprintf("PLC %d (%s)", id, p.Name)
+}
+
+// configuredPLCs returns the IDs of the PLCs with an address, in order.
+func (cfg Config) configuredPLCs() []int {
+	var ids []int
+	for id, plc := range cfg.PLCs {
+		if plc.Address != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// plcAddresses maps each PLC ID to its address, as /health shows them.
+func (cfg Config) plcAddresses() map[int]string {
+	addresses := make(map[int]string, len(cfg.PLCs))
+	for id, plc := range cfg.PLCs {
+		addresses[id] = plc.Address
+	}
+	return addresses
+}
+
+// scheduleReporter is the PLC whose schedule bits and photocell /status
+// reports: the one with that role, or else the lowest configured ID that runs
+// schedules (PLC 1, the Lodge, before roles existed). 0 if there is none.
+func (cfg Config) scheduleReporter() int {
+	ids := cfg.configuredPLCs()
+	for _, id := range ids {
+		if cfg.PLCs[id].HasRole(roleScheduleReporter) {
+			return id
+		}
+	}
+	for _, id := range ids {
+		if cfg.PLCs[id].Can(capSchedules) {
+			return id
+		}
+	}
+	return 0
+}
+
+// photocellMaster is the PLC with the photocell_master role, or 0.
+func (cfg Config) photocellMaster() int {
+	for _, id := range cfg.configuredPLCs() {
+		if cfg.PLCs[id].HasRole(rolePhotocellMaster) {
+			return id
+		}
+	}
+	return 0
+}
+
+// photocellMasterLabel names the photocell master for alert messages.
+func (cfg Config) photocellMasterLabel() string {
+	if id := cfg.photocellMaster(); id != 0 {
+		return cfg.PLCs[id].label(id)
+	}
+	return "the photocell master"
+}
+
+// photocellSlaveLabels names the PLCs that copy C154 from master (a PLC ID,
+// or 0 for the configured one): those with the photocell_slave role, or else
+// every other PLC with a photocell.
+func (cfg Config) photocellSlaveLabels(master int) string {
+	if master == 0 {
+		master = cfg.photocellMaster()
+	}
+	var slaves, others []string
+	for _, id := range cfg.configuredPLCs() {
+		plc := cfg.PLCs[id]
+		switch {
+		case id == master || !plc.Can(capPhotocell):
+		case plc.HasRole(rolePhotocellSlave):
+			slaves = append(slaves, plc.label(id))
+		default:
+			others = append(others, plc.label(id))
+		}
+	}
+	if len(slaves) == 0 {
+		slaves = others
+	}
+	if len(slaves) == 0 {
+		return "the photocell slaves"
+	}
+	return strings.Join(slaves, ", ")
+}
+
+// validatePLCs checks addresses, roles and capabilities.
+func validatePLCs(plcs map[int]PLCConfig) error {
+	holders := make(map[string]int) // Role -> PLC ID
+	ids := make([]int, 0, len(plcs))
+	for id := range plcs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		plc := plcs[id]
+		if id < 1 {
+			return fmt.Errorf("PLC ID %d must be 1 or more", id)
+		}
+		if plc.Address != "" {
+			if _, _, err := net.SplitHostPort(plc.Address); err != nil {
+				return fmt.Errorf("PLC %d address %q must be host:port", id, plc.Address)
+			}
+		}
+		for _, c := range plc.Capabilities {
+			if !slices.Contains(validCapabilities, c) {
+				return fmt.Errorf("PLC %d capability %q must be one of %v", id, c, validCapabilities)
+			}
+		}
+		for _, role := range plc.Roles {
+			if !slices.Contains(validRoles, role) {
+				return fmt.Errorf("PLC %d role %q must be one of %v", id, role, validRoles)
+			}
+			if other, ok := holders[role]; ok && role != rolePhotocellSlave {
+				return fmt.Errorf("PLCs %d and %d both have role %s", other, id, role)
+			}
+			holders[role] = id
+		}
+		if plc.HasRole(rolePhotocellMaster) && plc.HasRole(rolePhotocellSlave) {
+			return fmt.Errorf("PLC %d can't be both photocell master and slave", id)
+		}
+		if (plc.HasRole(rolePhotocellMaster) || plc.HasRole(rolePhotocellSlave)) && !plc.Can(capPhotocell) {
+			return fmt.Errorf("PLC %d has a photocell role but not the photocell capability", id)
+		}
+		if plc.HasRole(roleScheduleReporter) && !plc.Can(capSchedules) {
+			return fmt.Errorf("PLC %d is the schedule reporter but not capable of schedules", id)
+		}
+	}
+	return nil
+}
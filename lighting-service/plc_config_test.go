@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidatePLCs(t *testing.T) {
+	const addr = "192.168.1.201:502"
+	tests := []struct {
+		name    string
+		plcs    map[int]PLCConfig
+		wantErr string // Substring; "" for valid
+	}{
+		{name: "none", plcs: map[int]PLCConfig{}},
+		{name: "address only", plcs: map[int]PLCConfig{1: {Address: addr}, 2: {Address: "192.168.1.202:502"}}},
+		{name: "unconfigured", plcs: map[int]PLCConfig{1: {}}},
+		{
+			name: "roles",
+			plcs: map[int]PLCConfig{
+				1: {Address: addr, Roles: []string{rolePhotocellSlave, roleScheduleReporter}},
+				2: {Address: addr, Roles: []string{rolePhotocellMaster}, Capabilities: []string{capPhotocell}},
+				3: {Address: addr, Roles: []string{rolePhotocellSlave}},
+			},
+		},
+		{name: "ID zero", plcs: map[int]PLCConfig{0: {Address: addr}}, wantErr: "PLC ID 0 must be 1 or more"},
+		{name: "no port", plcs: map[int]PLCConfig{1: {Address: "192.168.1.201"}}, wantErr: `PLC 1 address "192.168.1.201" must be host:port`},
+		{name: "unknown capability", plcs: map[int]PLCConfig{1: {Address: addr, Capabilities: []string{"coffee"}}}, wantErr: `PLC 1 capability "coffee"`},
+		{name: "unknown role", plcs: map[int]PLCConfig{1: {Address: addr, Roles: []string{"boss"}}}, wantErr: `PLC 1 role "boss"`},
+		{
+			name:    "two masters",
+			plcs:    map[int]PLCConfig{1: {Roles: []string{rolePhotocellMaster}}, 4: {Roles: []string{rolePhotocellMaster}}},
+			wantErr: "PLCs 1 and 4 both have role photocell_master",
+		},
+		{
+			name:    "two schedule reporters",
+			plcs:    map[int]PLCConfig{2: {Roles: []string{roleScheduleReporter}}, 3: {Roles: []string{roleScheduleReporter}}},
+			wantErr: "PLCs 2 and 3 both have role schedule_reporter",
+		},
+		{
+			name:    "master and slave",
+			plcs:    map[int]PLCConfig{1: {Roles: []string{rolePhotocellMaster, rolePhotocellSlave}}},
+			wantErr: "can't be both photocell master and slave",
+		},
+		{
+			name:    "slave without a photocell",
+			plcs:    map[int]PLCConfig{1: {Roles: []string{rolePhotocellSlave}, Capabilities: []string{capSchedules}}},
+			wantErr: "PLC 1 has a photocell role but not the photocell capability",
+		},
+		{
+			name:    "reporter without schedules",
+			plcs:    map[int]PLCConfig{1: {Roles: []string{roleScheduleReporter}, Capabilities: []string{capClock}}},
+			wantErr: "PLC 1 is the schedule reporter but not capable of schedules",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePLCs(tt.plcs)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPLCConfigUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want map[int]PLCConfig
+	}{
+		{`{"1": "192.168.1.201:502"}`, map[int]PLCConfig{1: {Address: "192.168.1.201:502"}}},
+		{`{"2": ""}`, map[int]PLCConfig{2: {}}},
+		{
+			`{"3": {"Address": "192.168.1.203:502", "Name": "Tennis", "Roles": ["photocell_slave"], "Capabilities": ["schedules", "clock"]}}`,
+			map[int]PLCConfig{3: {Address: "192.168.1.203:502", Name: "Tennis", Roles: []string{rolePhotocellSlave}, Capabilities: []string{capSchedules, capClock}}},
+		},
+	}
+	for _, tt := range tests {
+		var got map[int]PLCConfig
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Errorf("%s: %v", tt.json, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestPLCRoles(t *testing.T) {
+	const addr = "192.168.1.201:502"
+	tests := []struct {
+		name         string
+		plcs         map[int]PLCConfig
+		wantReporter int
+		wantMaster   int
+		wantSlaves   string
+	}{
+		{
+			name:         "two PLCs, no roles",
+			plcs:         map[int]PLCConfig{1: {Address: addr}, 2: {Address: addr}},
+			wantReporter: 1,
+			wantSlaves:   "PLC 1, PLC 2",
+		},
+		{
+			name: "Lodge and Cabana",
+			plcs: map[int]PLCConfig{
+				1: {Address: addr, Name: "Lodge", Roles: []string{rolePhotocellSlave, roleScheduleReporter}},
+				2: {Address: addr, Name: "Cabana", Roles: []string{rolePhotocellMaster}},
+			},
+			wantReporter: 1,
+			wantMaster:   2,
+			wantSlaves:   "PLC 1 (Lodge)",
+		},
+		{
+			name: "reporter role wins over the lowest ID",
+			plcs: map[int]PLCConfig{
+				1: {Address: addr, Capabilities: []string{capClock}},
+				2: {Address: addr},
+				5: {Address: addr, Roles: []string{roleScheduleReporter}},
+			},
+			wantReporter: 5,
+			wantSlaves:   "PLC 2, PLC 5",
+		},
+		{
+			name:         "lowest ID with schedules",
+			plcs:         map[int]PLCConfig{1: {Address: addr, Capabilities: []string{capPhotocell}}, 3: {Address: addr}},
+			wantReporter: 3,
+			wantSlaves:   "PLC 1, PLC 3",
+		},
+		{
+			name: "unconfigured PLCs don't count",
+			plcs: map[int]PLCConfig{
+				1: {Roles: []string{roleScheduleReporter, rolePhotocellMaster}},
+				2: {Address: addr, Name: "Pool", Roles: []string{rolePhotocellSlave}},
+			},
+			wantReporter: 2,
+			wantSlaves:   "PLC 2 (Pool)",
+		},
+		{name: "nothing configured", plcs: map[int]PLCConfig{1: {}}, wantSlaves: "the photocell slaves"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{PLCs: tt.plcs}
+			if got := cfg.scheduleReporter(); got != tt.wantReporter {
+				t.Errorf("schedule reporter %d, want %d", got, tt.wantReporter)
+			}
+			if got := cfg.photocellMaster(); got != tt.wantMaster {
+				t.Errorf("photocell master %d, want %d", got, tt.wantMaster)
+			}
+			if got := cfg.photocellSlaveLabels(0); got != tt.wantSlaves {
+				t.Errorf("photocell slaves %q, want %q", got, tt.wantSlaves)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/goburrow/modbus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// A PLC's image is the register data a sync writes to it: the 12 schedule
+// blocks (DS100-DS939) and the map of loop index to schedule slot
+// (DS1000-DS1023). Reading it back and decoding it shows what the PLC is
+// really running; comparing it with what the WordPress config compiles to
+// shows where the two differ.
+
+const (
+	scheduleSlotCount = 12
+	scheduleBlockSize = 70 // 14 spans * 5 registers
+	mapFirstDS        = 1000
+	mapSize           = 24
+)
+
+// PLCImage is the schedule blocks and map of one PLC.
+type PLCImage struct {
+	Schedules [scheduleSlotCount][]uint16 // Slot 1 is Schedules[0], at DS100
+	Map       []uint16                    // DS1000-DS1023, slot per loop index
+}
+
+// compileImage is the image a sync writes to the given PLC.
+func compileImage(data *FullConfigurationData, plcID int) PLCImage {
+	blocks, maps := compileConfiguration(data)
+	var img PLCImage
+	for slot := 1; slot <= scheduleSlotCount; slot++ {
+		img.Schedules[slot-1] = blocks[slot]
+		if img.Schedules[slot-1] == nil {
+			img.Schedules[slot-1] = make([]uint16, scheduleBlockSize) // Written as an empty block
+		}
+	}
+	img.Map = maps[plcID]
+	if img.Map == nil {
+		img.Map = make([]uint16, mapSize)
+	}
+	return img
+}
+
+// readPLCImage reads the schedule blocks and map from a PLC.
+func readPLCImage(session *PLCSession) (PLCImage, error) {
+	var img PLCImage
+	first := int(scheduleIDToModbusAddress(1)) + 1
+	blocks, err := readRegisters(session, registerRange{Kind: "DS", First: first, Count: scheduleSlotCount * scheduleBlockSize})
+	if err != nil {
+		return img, err
+	}
+	if img.Map, err = readRegisters(session, registerRange{Kind: "DS", First: mapFirstDS, Count: mapSize}); err != nil {
+		return img, err
+	}
+	for slot := range scheduleSlotCount {
+		img.Schedules[slot] = blocks[slot*scheduleBlockSize : (slot+1)*scheduleBlockSize]
+	}
+	return img, nil
+}
+
+// changedBlocks lists the schedule slots whose blocks differ between two
+// images, and whether the maps differ.
+func changedBlocks(expected, actual PLCImage) (slots []int, mapChanged bool) {
+	for slot := 1; slot <= scheduleSlotCount; slot++ {
+		if !slices.Equal(expected.Schedules[slot-1], actual.Schedules[slot-1]) {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, !slices.Equal(expected.Map, actual.Map)
+}
+
+// needsResync says whether writing the changed blocks changes what the PLC
+// does, so C151 has to be set: the map changed, or a changed slot is one a
+// loop follows before or after. Other slots are not running anything.
+func needsResync(expected, actual PLCImage, slots []int, mapChanged bool) bool {
+	if mapChanged {
+		return true
+	}
+	for _, slot := range slots {
+		if slices.Contains(expected.Map, uint16(slot)) || slices.Contains(actual.Map, uint16(slot)) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeImageBlocks writes the given schedule slots, and the map if writeMap,
+// from img. It returns the ranges written, e.g. "PLC1:DS170-DS239", and
+// carries on past a failed block.
+func writeImageBlocks(client modbus.Client, plcID int, img PLCImage, slots []int, writeMap bool) ([]string, error) {
+	var writes []string
+	var firstErr error
+	for _, slot := range slots {
+		address := scheduleIDToModbusAddress(slot)
+		block := img.Schedules[slot-1]
+		if _, err := client.WriteMultipleRegisters(address, uint16(len(block)), u16SliceToBytes(block)); err != nil {
+			modbusLog.Error("could not write schedule slot", "plc", plcID, "slot", slot, "err", err)
+			firstErr = cmp.Or(firstErr, err)
+			continue
+		}
+		writes = append(writes, fmt.Sprintf("PLC%d:DS%d-DS%d", plcID, address+1, int(address)+len(block)))
+	}
+	if writeMap {
+		if _, err := client.WriteMultipleRegisters(mapFirstDS-1, uint16(len(img.Map)), u16SliceToBytes(img.Map)); err != nil {
+			modbusLog.Error("could not write map block", "plc", plcID, "err", err)
+			firstErr = cmp.Or(firstErr, err)
+		} else {
+			writes = append(writes, fmt.Sprintf("PLC%d:DS%d-DS%d", plcID, mapFirstDS, mapFirstDS+len(img.Map)-1))
+		}
+	}
+	return writes, firstErr
+}
+
+// requestResync sets C151, which makes the ladder reload the schedules and
+// map. It also drops every manual override on that PLC.
+func requestResync(client modbus.Client, plcID int) ([]string, error) {
+	address, _ := cBitToModbusAddress(151)
+	if _, err := client.WriteSingleCoil(address, 0xFF00); err != nil {
+		modbusLog.Error("could not request re-sync (SET C151)", "plc", plcID, "err", err)
+		return nil, err
+	}
+	return []string{fmt.Sprintf("PLC%d:C151", plcID)}, nil
+}
+
+// RegisterDiff is one register whose value on the PLC differs from the config.
+type RegisterDiff struct {
+	Register     string `json:"register"` // e.g. "DS104"
+	Meaning      string `json:"meaning"`  // e.g. "slot 1 span 1 off time"
+	Actual       uint16 `json:"actual"`
+	Expected     uint16 `json:"expected"`
+	ActualText   string `json:"actual_text"` // Decoded, e.g. "23:00"
+	ExpectedText string `json:"expected_text"`
+}
+
+// diffImages lists the registers that differ, in address order.
+func diffImages(expected, actual PLCImage) []RegisterDiff {
+	diffs := []RegisterDiff{}
+	add := func(ds int, want, got uint16) {
+		if want == got {
+			return
+		}
+		meaning, wantText := describeRegister(ds, want)
+		_, gotText := describeRegister(ds, got)
+		diffs = append(diffs, RegisterDiff{Register: fmt.Sprintf("DS%d", ds), Meaning: meaning, Actual: got, Expected: want, ActualText: gotText, ExpectedText: wantText})
+	}
+	for slot := 1; slot <= scheduleSlotCount; slot++ {
+		first := int(scheduleIDToModbusAddress(slot)) + 1
+		for i := 0; i < scheduleBlockSize; i++ {
+			add(first+i, registerAt(expected.Schedules[slot-1], i), registerAt(actual.Schedules[slot-1], i))
+		}
+	}
+	for i := 0; i < mapSize; i++ {
+		add(mapFirstDS+i, registerAt(expected.Map, i), registerAt(actual.Map, i))
+	}
+	return diffs
+}
+
+func registerAt(block []uint16, i int) uint16 {
+	if i < len(block) {
+		return block[i]
+	}
+	return 0
+}
+
+// describeRegister says what a schedule or map register holds and decodes its value.
+func describeRegister(ds int, value uint16) (meaning, text string) {
+	if ds >= mapFirstDS {
+		loop := ds - mapFirstDS
+		meaning = fmt.Sprintf("loop %d (%s) schedule slot", loop, loopOutputs(loop))
+		if value == 0 {
+			return meaning, "none"
+		}
+		return meaning, fmt.Sprintf("slot %d", value)
+	}
+	i := ds - int(scheduleIDToModbusAddress(1)) - 1
+	slot, span, field := i/scheduleBlockSize+1, i%scheduleBlockSize/5+1, i%5
+	meaning = fmt.Sprintf("slot %d span %d %s", slot, span, []string{"days", "on trigger", "on time", "off trigger", "off time"}[field])
+	switch field {
+	case 0:
+		return meaning, daysLabel(bitmaskToDays(value))
+	case 1, 3:
+		if value == 0 {
+			return meaning, "TIME"
+		}
+		return meaning, "photocell"
+	}
+	return meaning, fmt.Sprintf("%02d:%02d", value/100, value%100)
+}
+
+// loopOutputs names the Y outputs a loop index drives, the inverse of
+// calculateLoopIndex: loop 0 is Y101/Y102, loop 8 is Y201/Y202.
+func loopOutputs(loop int) string {
+	module, pair := loop/8+1, loop%8
+	return fmt.Sprintf("Y%d%02d/Y%d%02d", module, pair*2+1, module, pair*2+2)
+}
+
+// DecodedImage is a PLC's image in readable form, with any differences from
+// what the WordPress config compiles to.
+type DecodedImage struct {
+	PLC         int            `json:"plc"`
+	Slots       []DecodedSlot  `json:"slots"`
+	Loops       []DecodedLoop  `json:"loops"`
+	InSync      *bool          `json:"in_sync"` // null if the config could not be fetched
+	Diff        []RegisterDiff `json:"diff"`
+	ConfigError string         `json:"config_error,omitempty"`
+}
+
+// DecodedSlot is one schedule block.
+type DecodedSlot struct {
+	Slot         int              `json:"slot"`
+	Registers    string           `json:"registers"`
+	Spans        []FullConfigSpan `json:"spans"`
+	ScheduleID   int              `json:"schedule_id,omitempty"` // The WordPress schedule compiled to this slot
+	ScheduleName string           `json:"schedule_name,omitempty"`
+}
+
+// DecodedLoop is one entry of the map.
+type DecodedLoop struct {
+	LoopIndex   int    `json:"loop_index"`
+	Register    string `json:"register"`
+	Outputs     string `json:"outputs"` // e.g. "Y101/Y102"
+	Slot        int    `json:"slot"`    // 0 if the loop follows no schedule
+	MappingID   int    `json:"mapping_id,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// decodeImage decodes img and, with the config, names the schedules and
+// mappings and lists the differences.
+func decodeImage(plcID int, img PLCImage, data *FullConfigurationData) DecodedImage {
+	out := DecodedImage{PLC: plcID, Slots: []DecodedSlot{}, Loops: []DecodedLoop{}, Diff: []RegisterDiff{}}
+	for slot := 1; slot <= scheduleSlotCount; slot++ {
+		first := int(scheduleIDToModbusAddress(slot)) + 1
+		s := DecodedSlot{Slot: slot, Registers: fmt.Sprintf("DS%d-DS%d", first, first+scheduleBlockSize-1), Spans: decodeScheduleBlock(img.Schedules[slot-1])}
+		if data != nil && slot <= len(data.Schedules) {
+			s.ScheduleID, s.ScheduleName = data.Schedules[slot-1].ID, data.Schedules[slot-1].ScheduleName
+		}
+		out.Slots = append(out.Slots, s)
+	}
+	for loop := 0; loop < mapSize; loop++ {
+		l := DecodedLoop{LoopIndex: loop, Register: fmt.Sprintf("DS%d", mapFirstDS+loop), Outputs: loopOutputs(loop), Slot: int(registerAt(img.Map, loop))}
+		if data != nil {
+			i := slices.IndexFunc(data.Mappings, func(m FullConfigMapping) bool {
+				return m.PLCID == plcID && len(m.PLCOutputs) > 0 && calculateLoopIndex(m.PLCOutputs[0]) == loop
+			})
+			if i >= 0 {
+				l.MappingID, l.Description = data.Mappings[i].ID, data.Mappings[i].Description
+			}
+		}
+		out.Loops = append(out.Loops, l)
+	}
+	if data != nil {
+		out.Diff = diffImages(compileImage(data, plcID), img)
+		inSync := len(out.Diff) == 0
+		out.InSync = &inSync
+	}
+	return out
+}
+
+// handlePLCImage reads and decodes a PLC's schedules and map and compares
+// them with the WordPress config.
+func (app *App) handlePLCImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	session, ok := app.sessions.Get(id)
+	if !ok {
+		writeError(w, r, apiError(http.StatusNotFound, codeNotFound, "PLC %d is not configured", id))
+		return
+	}
+	if !session.Can(capSchedules) {
+		writeError(w, r, apiError(http.StatusBadRequest, codeBadRequest, "PLC %d does not have the %s capability", id, capSchedules))
+		return
+	}
+	done, err := app.beginPLCWork()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer done()
+
+	img, err := readPLCImage(session)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	data, err := FetchConfigurationFromAPI(app.config())
+	decoded := decodeImage(id, img, data)
+	if err != nil {
+		decoded.ConfigError = configUnavailable(err).Message
+	}
+	writeJSON(w, http.StatusOK, decoded)
+}
+
+// printDecodedImage is the lightctl table for a DecodedImage.
+func printDecodedImage(w io.Writer, img DecodedImage) {
+	fmt.Fprintf(w, "PLC %d schedules\n", img.PLC)
+	fmt.Fprintln(w, "SLOT\tREGISTERS\tWORDPRESS\tDAYS\tON\tOFF")
+	for _, s := range img.Slots {
+		name := ""
+		if s.ScheduleName != "" {
+			name = fmt.Sprintf("%s (#%d)", s.ScheduleName, s.ScheduleID)
+		}
+		if len(s.Spans) == 0 {
+			fmt.Fprintf(w, "%d\t%s\t%s\t(empty)\t\t\n", s.Slot, s.Registers, name)
+		}
+		for i, span := range s.Spans {
+			lead := fmt.Sprintf("%d\t%s\t%s", s.Slot, s.Registers, name)
+			if i > 0 {
+				lead = "\t\t"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", lead, daysLabel(span.DaysOfWeek), spanEdge(span.OnTrigger, span.OnTime), spanEdge(span.OffTrigger, span.OffTime))
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "LOOP\tREGISTER\tOUTPUTS\tSLOT\tMAPPING")
+	for _, l := range img.Loops {
+		mapping := ""
+		if l.MappingID != 0 {
+			mapping = fmt.Sprintf("#%d %s", l.MappingID, l.Description)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", l.LoopIndex, l.Register, l.Outputs, l.Slot, mapping)
+	}
+	fmt.Fprintln(w)
+	switch {
+	case img.InSync == nil:
+		fmt.Fprintf(w, "not compared with WordPress: %s\n", img.ConfigError)
+	case *img.InSync:
+		fmt.Fprintln(w, "matches the WordPress config")
+	default:
+		fmt.Fprintf(w, "%d register(s) differ from the WordPress config\n", len(img.Diff))
+		fmt.Fprintln(w, "REGISTER\tMEANING\tPLC\tWORDPRESS")
+		for _, d := range img.Diff {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Register, d.Meaning, d.ActualText, d.ExpectedText)
+		}
+	}
+}
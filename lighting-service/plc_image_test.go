@@ -0,0 +1,221 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// testImageConfig has two schedules, so slots 1 and 2, and two lights on PLC 1.
+func testImageConfig() *FullConfigurationData {
+	at := func(s string) *string { return &s }
+	return &FullConfigurationData{
+		Schedules: []FullConfigSchedule{
+			{ID: 7, ScheduleName: "Dusk to 23:00", Spans: []FullConfigSpan{
+				{DaysOfWeek: []string{"mon", "tue", "wed", "thu", "fri"}, OnTrigger: "SUNDOWN", OffTrigger: "TIME", OffTime: at("23:00")},
+			}},
+			{ID: 9, ScheduleName: "All night", Spans: []FullConfigSpan{
+				{DaysOfWeek: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}, OnTrigger: "SUNDOWN", OffTrigger: "SUNRISE"},
+			}},
+		},
+		Zones: []FullConfigZone{{ID: 3, ZoneName: "Pool", ScheduleID: 7}, {ID: 4, ZoneName: "Paths", ScheduleID: 9}},
+		Mappings: []FullConfigMapping{
+			{ID: 11, PLCID: 1, Description: "Pool deck", PLCOutputs: []string{"Y101"}, LinkedZoneIDs: []int{3}},
+			{ID: 12, PLCID: 1, Description: "North path", PLCOutputs: []string{"Y203"}, LinkedZoneIDs: []int{4}},
+		},
+	}
+}
+
+// cloneImage copies an image so a test can change it without touching the original.
+func cloneImage(img PLCImage) PLCImage {
+	out := PLCImage{Map: slices.Clone(img.Map)}
+	for i, block := range img.Schedules {
+		out.Schedules[i] = slices.Clone(block)
+	}
+	return out
+}
+
+func TestDescribeRegister(t *testing.T) {
+	tests := []struct {
+		ds          int
+		value       uint16
+		wantMeaning string
+		wantText    string
+	}{
+		{100, 62, "slot 1 span 1 days", "mon,tue,wed,thu,fri"},
+		{100, 0, "slot 1 span 1 days", "(no days)"},
+		{101, 1, "slot 1 span 1 on trigger", "photocell"},
+		{103, 0, "slot 1 span 1 off trigger", "TIME"},
+		{104, 2300, "slot 1 span 1 off time", "23:00"},
+		{107, 630, "slot 1 span 2 on time", "06:30"},
+		{170, 127, "slot 2 span 1 days", "sun,mon,tue,wed,thu,fri,sat"},
+		{939, 5, "slot 12 span 14 off time", "00:05"},
+		{1000, 1, "loop 0 (Y101/Y102) schedule slot", "slot 1"},
+		{1009, 0, "loop 9 (Y203/Y204) schedule slot", "none"},
+		{1023, 12, "loop 23 (Y315/Y316) schedule slot", "slot 12"},
+	}
+	for _, tt := range tests {
+		meaning, text := describeRegister(tt.ds, tt.value)
+		if meaning != tt.wantMeaning || text != tt.wantText {
+			t.Errorf("DS%d=%d: got %q %q, want %q %q", tt.ds, tt.value, meaning, text, tt.wantMeaning, tt.wantText)
+		}
+	}
+}
+
+func TestDiffImages(t *testing.T) {
+	want := compileImage(testImageConfig(), 1)
+	tests := []struct {
+		name   string
+		change func(img *PLCImage)
+		want   []RegisterDiff
+	}{
+		{name: "same", change: func(*PLCImage) {}, want: []RegisterDiff{}},
+		{
+			name:   "off time",
+			change: func(img *PLCImage) { img.Schedules[0][4] = 2200 },
+			want:   []RegisterDiff{{Register: "DS104", Meaning: "slot 1 span 1 off time", Actual: 2200, Expected: 2300, ActualText: "22:00", ExpectedText: "23:00"}},
+		},
+		{
+			name: "map and a schedule, in address order",
+			change: func(img *PLCImage) {
+				img.Map[9] = 0
+				img.Schedules[1][0] = 1
+			},
+			want: []RegisterDiff{
+				{Register: "DS170", Meaning: "slot 2 span 1 days", Actual: 1, Expected: 127, ActualText: "sun", ExpectedText: "sun,mon,tue,wed,thu,fri,sat"},
+				{Register: "DS1009", Meaning: "loop 9 (Y203/Y204) schedule slot", Actual: 0, Expected: 2, ActualText: "none", ExpectedText: "slot 2"},
+			},
+		},
+		{
+			name:   "short map reads as zeros",
+			change: func(img *PLCImage) { img.Map = img.Map[:1] },
+			want:   []RegisterDiff{{Register: "DS1009", Meaning: "loop 9 (Y203/Y204) schedule slot", Actual: 0, Expected: 2, ActualText: "none", ExpectedText: "slot 2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := cloneImage(want)
+			tt.change(&actual)
+			if got := diffImages(want, actual); !slices.Equal(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	data := testImageConfig()
+	drifted := cloneImage(compileImage(data, 1))
+	drifted.Schedules[0][4] = 2200
+
+	inSync, outOfSync := true, false
+	tests := []struct {
+		name        string
+		img         PLCImage
+		data        *FullConfigurationData
+		wantInSync  *bool
+		wantDiff    int
+		wantOffTime string // Slot 1 span 1
+		wantNames   bool
+	}{
+		{name: "in sync", img: compileImage(data, 1), data: data, wantInSync: &inSync, wantOffTime: "23:00", wantNames: true},
+		{name: "drifted", img: drifted, data: data, wantInSync: &outOfSync, wantDiff: 1, wantOffTime: "22:00", wantNames: true},
+		{name: "no config", img: drifted, wantOffTime: "22:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeImage(1, tt.img, tt.data)
+			if len(got.Slots) != scheduleSlotCount || len(got.Loops) != mapSize {
+				t.Fatalf("%d slots and %d loops, want %d and %d", len(got.Slots), len(got.Loops), scheduleSlotCount, mapSize)
+			}
+			if (got.InSync == nil) != (tt.wantInSync == nil) || got.InSync != nil && *got.InSync != *tt.wantInSync {
+				t.Errorf("in_sync %v, want %v", got.InSync, tt.wantInSync)
+			}
+			if len(got.Diff) != tt.wantDiff {
+				t.Errorf("%d differences, want %d: %+v", len(got.Diff), tt.wantDiff, got.Diff)
+			}
+
+			slot := got.Slots[0]
+			if slot.Registers != "DS100-DS169" || len(slot.Spans) != 1 || *slot.Spans[0].OffTime != tt.wantOffTime {
+				t.Errorf("slot 1 decoded as %s %+v", slot.Registers, slot.Spans)
+			}
+			if got.Slots[2].Spans == nil || len(got.Slots[2].Spans) != 0 {
+				t.Errorf("empty slot 3 decoded as %+v, want no spans", got.Slots[2].Spans)
+			}
+			loop := got.Loops[9]
+			if loop.Register != "DS1009" || loop.Outputs != "Y203/Y204" || loop.Slot != 2 {
+				t.Errorf("loop 9 decoded as %+v", loop)
+			}
+
+			// Names come from the config only.
+			scheduleID, scheduleName, mappingID, description := 0, "", 0, ""
+			if tt.wantNames {
+				scheduleID, scheduleName, mappingID, description = 7, "Dusk to 23:00", 12, "North path"
+			}
+			if slot.ScheduleID != scheduleID || slot.ScheduleName != scheduleName {
+				t.Errorf("slot 1 named %d %q, want %d %q", slot.ScheduleID, slot.ScheduleName, scheduleID, scheduleName)
+			}
+			if loop.MappingID != mappingID || loop.Description != description {
+				t.Errorf("loop 9 named %d %q, want %d %q", loop.MappingID, loop.Description, mappingID, description)
+			}
+		})
+	}
+}
+
+func TestChangedBlocks(t *testing.T) {
+	want := compileImage(testImageConfig(), 1)
+	tests := []struct {
+		name        string
+		change      func(img *PLCImage)
+		wantSlots   []int
+		wantMapDiff bool
+	}{
+		{name: "same", change: func(*PLCImage) {}},
+		{name: "one register", change: func(img *PLCImage) { img.Schedules[1][69] = 1 }, wantSlots: []int{2}},
+		{
+			name: "several slots",
+			change: func(img *PLCImage) {
+				img.Schedules[11][0] = 1
+				img.Schedules[0][4] = 2200
+			},
+			wantSlots: []int{1, 12},
+		},
+		{name: "map only", change: func(img *PLCImage) { img.Map[0] = 2 }, wantMapDiff: true},
+		{name: "unread block", change: func(img *PLCImage) { img.Schedules[4] = nil }, wantSlots: []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := cloneImage(want)
+			tt.change(&actual)
+			slots, mapChanged := changedBlocks(want, actual)
+			if !slices.Equal(slots, tt.wantSlots) || mapChanged != tt.wantMapDiff {
+				t.Errorf("got slots %v map %v, want %v %v", slots, mapChanged, tt.wantSlots, tt.wantMapDiff)
+			}
+		})
+	}
+}
+
+func TestNeedsResync(t *testing.T) {
+	expected := compileImage(testImageConfig(), 1) // Loops follow slots 1 and 2
+	moved := cloneImage(expected)
+	moved.Map[0] = 3 // The PLC has loop 0 on slot 3
+	tests := []struct {
+		name       string
+		actual     PLCImage
+		slots      []int
+		mapChanged bool
+		want       bool
+	}{
+		{name: "nothing changed", actual: expected},
+		{name: "map changed", actual: moved, mapChanged: true, want: true},
+		{name: "slot in use", actual: expected, slots: []int{2}, want: true},
+		{name: "unused slot", actual: expected, slots: []int{5, 12}},
+		{name: "slot in use on the PLC only", actual: moved, slots: []int{3}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsResync(expected, tt.actual, tt.slots, tt.mapChanged); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goburrow/modbus"
+)
+
+// A sync must not leave the PLCs running different configs. It goes in three
+// phases: read and keep the image of every PLC, write the blocks that change
+// and read them back, and only then set C151 where needed (see needsResync).
+// If any step fails, the kept images are written back on every PLC already
+// written, and C151 is set again on any that had already reloaded.
+
+// PLCPushResult says what a sync did on one PLC.
+type PLCPushResult struct {
+	PLC          int    `json:"plc"`
+	State        string `json:"state"`         // "unchanged", "committed", "not_written", "rolled_back" or "rollback_failed"
+	SlotsWritten []int  `json:"slots_written"` // Schedule slots rewritten
+	MapWritten   bool   `json:"map_written"`
+	Resync       bool   `json:"resync"`         // C151 was set, which drops manual overrides
+	Full         bool   `json:"full,omitempty"` // Every block was written
+	Error        string `json:"error,omitempty"`
+}
+
+// summary is one line on what was done, e.g. "wrote slots 1,3 and map, C151 set".
+func (r PLCPushResult) summary() string {
+	switch r.State {
+	case "not_written":
+		return "not written"
+	case "rolled_back":
+		return "rolled back to the old config: " + r.Error
+	case "rollback_failed":
+		return "rollback failed, registers may be part old and part new: " + r.Error
+	}
+	var parts []string
+	if len(r.SlotsWritten) > 0 {
+		slots := make([]string, len(r.SlotsWritten))
+		for i, slot := range r.SlotsWritten {
+			slots[i] = strconv.Itoa(slot)
+		}
+		parts = append(parts, "slots "+strings.Join(slots, ","))
+	}
+	if r.MapWritten {
+		parts = append(parts, "map")
+	}
+	if len(parts) == 0 {
+		return "unchanged"
+	}
+	touched := "wrote " + strings.Join(parts, " and ")
+	if !r.Resync {
+		return touched + ", overrides kept (no C151)"
+	}
+	return touched + ", C151 set"
+}
+
+// plcPush is the state of one PLC during a push.
+type plcPush struct {
+	session    *PLCSession
+	expected   PLCImage // What the config compiles to
+	saved      PLCImage // What the PLC held before, for rollback
+	slots      []int    // Slots to write
+	mapChanged bool
+	resync     bool
+	read       bool // saved holds the PLC's image
+	written    bool // Blocks were written, perhaps only some
+	resynced   bool // C151 was set
+}
+
+func (p *plcPush) changed() bool { return len(p.slots) > 0 || p.mapChanged }
+
+// PushConfigurationToPLCs writes the config to every PLC, all or nothing.
+// Returns the register/coil ranges that were written, rollback included, for
+// the audit log, and the final state of each PLC. If ctx is canceled
+// (shutdown) the push stops before its next PLC request and is rolled back;
+// the rollback itself ignores ctx.
+func PushConfigurationToPLCs(ctx context.Context, sessions *PLCSessions, data *FullConfigurationData, full bool) ([]string, []PLCPushResult, error) {
+	syncLog.Info("starting configuration push to all PLCs", "full", full)
+	var writes []string
+	var pushes []*plcPush
+	for _, session := range sessions.With(capSchedules) {
+		pushes = append(pushes, &plcPush{session: session, expected: compileImage(data, session.ID)})
+	}
+
+	// 1. Read and keep every PLC's image. Nothing is written if one can't be read.
+	for _, p := range pushes {
+		if ctx.Err() != nil {
+			return rollback(pushes, full, writes, fmt.Errorf("push stopped before reading PLC %d, nothing was written: %w", p.session.ID, ctx.Err()))
+		}
+		modbusLog.Info("connecting to PLC", "plc", p.session.ID, "host", p.session.Host)
+		saved, err := readPLCImage(p.session)
+		if err != nil {
+			return rollback(pushes, full, writes, fmt.Errorf("could not read PLC %d before writing, nothing was written: %w", p.session.ID, err))
+		}
+		p.saved, p.read = saved, true
+		p.slots, p.mapChanged = changedBlocks(p.expected, saved)
+		p.resync = needsResync(p.expected, saved, p.slots, p.mapChanged)
+		if full {
+			p.slots, p.mapChanged, p.resync = allSlots(), true, true
+		}
+	}
+
+	// 2. Write the new blocks and read them back.
+	for _, p := range pushes {
+		if !p.changed() {
+			continue
+		}
+		if ctx.Err() != nil {
+			return rollback(pushes, full, writes, fmt.Errorf("push stopped before PLC %d: %w", p.session.ID, ctx.Err()))
+		}
+		p.written = true
+		err := p.session.Do(func(client modbus.Client) error {
+			w, err := writeImageBlocks(client, p.session.ID, p.expected, p.slots, p.mapChanged)
+			writes = append(writes, w...)
+			return err
+		})
+		if err == nil {
+			err = verifyImage(p.session, p.expected)
+		}
+		if err != nil {
+			return rollback(pushes, full, writes, fmt.Errorf("PLC %d: %w", p.session.ID, err))
+		}
+	}
+
+	// 3. Every PLC holds its new image: have the ladders reload.
+	for _, p := range pushes {
+		if !p.resync {
+			continue
+		}
+		if ctx.Err() != nil {
+			return rollback(pushes, full, writes, fmt.Errorf("push stopped before setting C151 on PLC %d: %w", p.session.ID, ctx.Err()))
+		}
+		var w []string
+		err := p.session.Do(func(client modbus.Client) error {
+			var err error
+			w, err = requestResync(client, p.session.ID)
+			return err
+		})
+		writes = append(writes, w...)
+		if err != nil {
+			return rollback(pushes, full, writes, fmt.Errorf("PLC %d: %w", p.session.ID, err))
+		}
+		p.resynced = true
+	}
+
+	results := pushResults(pushes, full, nil)
+	for _, r := range results {
+		syncLog.Info("PLC pushed", "plc", r.PLC, "slots", r.SlotsWritten, "map", r.MapWritten, "resync", r.Resync)
+	}
+	syncLog.Info("configuration push finished")
+	return writes, results, nil
+}
+
+// verifyImage reads a PLC's image back and checks it is want.
+func verifyImage(session *PLCSession, want PLCImage) error {
+	got, err := readPLCImage(session)
+	if err != nil {
+		return err
+	}
+	if diff := diffImages(want, got); len(diff) > 0 {
+		err := fmt.Errorf("read back %d register(s) that differ from what was written, first %s (%s) is %s instead of %s",
+			len(diff), diff[0].Register, diff[0].Meaning, diff[0].ActualText, diff[0].ExpectedText)
+		return &PLCError{PLCID: session.ID, Err: err}
+	}
+	return nil
+}
+
+// rollback writes the saved image back to every PLC that was written, sets
+// C151 again on those that had reloaded the new one, and returns cause. Any
+// PLC not yet written is left as it was.
+func rollback(pushes []*plcPush, full bool, writes []string, cause error) ([]string, []PLCPushResult, error) {
+	syncLog.Error("PLC push failed, rolling back", "err", cause)
+	failed := make(map[int]error)
+	undo := func(w []string) {
+		for _, write := range w {
+			writes = append(writes, write+" (rollback)")
+		}
+	}
+	for _, p := range pushes {
+		if !p.written {
+			continue
+		}
+		werr := p.session.Do(func(client modbus.Client) error {
+			w, err := writeImageBlocks(client, p.session.ID, p.saved, p.slots, p.mapChanged)
+			undo(w)
+			return err
+		})
+		// What counts is the image read back: a block that failed to write
+		// going forward may never have changed.
+		err := verifyImage(p.session, p.saved)
+		if err != nil && werr != nil {
+			err = werr
+		}
+		if err == nil && p.resynced {
+			err = p.session.Do(func(client modbus.Client) error {
+				w, err := requestResync(client, p.session.ID)
+				undo(w)
+				return err
+			})
+		}
+		if err != nil {
+			syncLog.Error("PLC rollback failed, its registers may be part old and part new", "plc", p.session.ID, "err", err)
+			failed[p.session.ID] = err
+			continue
+		}
+		syncLog.Warn("PLC rolled back to its old config", "plc", p.session.ID)
+	}
+	return writes, pushResults(pushes, full, &rollbackOutcome{cause: cause, failed: failed}), cause
+}
+
+type rollbackOutcome struct {
+	cause  error
+	failed map[int]error
+}
+
+// pushResults reports each PLC's final state; rb is nil unless the push was
+// rolled back.
+func pushResults(pushes []*plcPush, full bool, rb *rollbackOutcome) []PLCPushResult {
+	results := []PLCPushResult{}
+	for _, p := range pushes {
+		r := PLCPushResult{PLC: p.session.ID, SlotsWritten: []int{}, Full: full}
+		switch {
+		case rb != nil && rb.failed[p.session.ID] != nil:
+			r.State, r.Error = "rollback_failed", rb.failed[p.session.ID].Error()
+		case rb != nil && p.written:
+			r.State, r.Error = "rolled_back", rb.cause.Error()
+		case p.read && !p.changed():
+			r.State = "unchanged"
+		case rb != nil:
+			r.State = "not_written"
+		default:
+			r.State = "committed"
+			r.SlotsWritten, r.MapWritten, r.Resync = append(r.SlotsWritten, p.slots...), p.mapChanged, p.resync
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func allSlots() []int {
+	slots := make([]int, scheduleSlotCount)
+	for i := range slots {
+		slots[i] = i + 1
+	}
+	return slots
+}
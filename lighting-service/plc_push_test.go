@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegisterPLC is a Modbus TCP server holding DS and SD registers and C
+// bits. It answers coil reads (FC1), register reads (FC3), register writes
+// (FC16) and coil writes (FC5), and can be told to fail or misbehave. Like a
+// CLICK, it sets its clock from SD29-SD35 when SC53 and SC55 are set.
+type fakeRegisterPLC struct {
+	mu         sync.Mutex
+	ds         [4500]uint16 // DS1 is ds[0]
+	sd         [100]uint16  // SD1 is sd[0]
+	c          [2000]bool   // C1 is c[0]
+	coilWrites []int        // C bits written ON, in order
+	failReads  bool
+	failWrites bool
+	stuck      map[int]bool // DS registers that ignore writes
+	onWrite    func()       // Called after each register write that succeeds, before the reply
+	onCoil     func()       // Called after each coil write, before the reply
+}
+
+func startFakeRegisterPLC(t *testing.T) (*fakeRegisterPLC, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	plc := &fakeRegisterPLC{stuck: map[int]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go plc.serve(conn)
+		}
+	}()
+	return plc, ln.Addr().String()
+}
+
+func (f *fakeRegisterPLC) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 7) // Transaction, protocol, length, unit
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		reply, hook := f.handle(pdu)
+		if hook != nil {
+			hook() // Before replying, so the client sees its effect
+		}
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(reply)+1))
+		conn.Write(append(header, reply...))
+	}
+}
+
+// handle carries out one request and returns the reply and the hook to run.
+func (f *fakeRegisterPLC) handle(pdu []byte) ([]byte, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fc, addr := pdu[0], int(binary.BigEndian.Uint16(pdu[1:3]))
+	exception := []byte{fc | 0x80, 4} // Server device failure
+	switch fc {
+	case 1:
+		if f.failReads {
+			return exception, nil
+		}
+		count := int(binary.BigEndian.Uint16(pdu[3:5]))
+		bits := make([]byte, (count+7)/8)
+		for i := range count {
+			if f.c[addr-16384+i] {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{fc, byte(len(bits))}, bits...), nil
+	case 3:
+		if f.failReads {
+			return exception, nil
+		}
+		count := int(binary.BigEndian.Uint16(pdu[3:5]))
+		reply := []byte{fc, byte(count * 2)}
+		for _, v := range f.registers(addr)[:count] {
+			reply = binary.BigEndian.AppendUint16(reply, v)
+		}
+		return reply, nil
+	case 16:
+		if f.failWrites {
+			return exception, nil
+		}
+		count := int(binary.BigEndian.Uint16(pdu[3:5]))
+		regs := f.registers(addr)
+		for i := range count {
+			if !f.stuck[addr+i+1] {
+				regs[i] = binary.BigEndian.Uint16(pdu[6+2*i:])
+			}
+		}
+		return pdu[:5], f.onWrite
+	case 5:
+		on := binary.BigEndian.Uint16(pdu[3:5]) == 0xFF00
+		switch {
+		case addr == 61492 && on: // SC53 sets the date from SD29-SD32
+			f.sd[18], f.sd[20], f.sd[21], f.sd[22] = f.sd[28], f.sd[29], f.sd[30], f.sd[31]
+		case addr == 61494 && on: // SC55 sets the time from SD33-SD35
+			f.sd[23], f.sd[24], f.sd[25] = f.sd[32], f.sd[33], f.sd[34]
+		}
+		if addr >= 61440 {
+			return pdu[:5], nil
+		}
+		f.c[addr-16384] = on
+		if on {
+			f.coilWrites = append(f.coilWrites, addr-16384+1)
+		}
+		return pdu[:5], f.onCoil
+	}
+	return []byte{fc | 0x80, 1}, nil // Illegal function
+}
+
+// registers returns the DS or SD registers from a Modbus address on.
+func (f *fakeRegisterPLC) registers(addr int) []uint16 {
+	if addr >= 61440 {
+		return f.sd[addr-61440:]
+	}
+	return f.ds[addr:]
+}
+
+// image is what the PLC holds in the registers a push writes.
+func (f *fakeRegisterPLC) image() PLCImage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var img PLCImage
+	for slot := range scheduleSlotCount {
+		first := int(scheduleIDToModbusAddress(slot + 1))
+		img.Schedules[slot] = slices.Clone(f.ds[first : first+scheduleBlockSize])
+	}
+	img.Map = slices.Clone(f.ds[mapFirstDS-1 : mapFirstDS-1+mapSize])
+	return img
+}
+
+// load puts an image in the registers.
+func (f *fakeRegisterPLC) load(img PLCImage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for slot, block := range img.Schedules {
+		copy(f.ds[scheduleIDToModbusAddress(slot+1):], block)
+	}
+	copy(f.ds[mapFirstDS-1:], img.Map)
+}
+
+func (f *fakeRegisterPLC) resyncs() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.coilWrites {
+		if c == 151 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPushConfigurationToPLCs(t *testing.T) {
+	data := testImageConfig()
+	data.Mappings = append(data.Mappings, FullConfigMapping{ID: 13, PLCID: 2, Description: "Cabana deck", PLCOutputs: []string{"Y101"}, LinkedZoneIDs: []int{3}})
+	// What the PLCs hold before the push: slot 1 ends at 22:00 instead of
+	// 23:00, and PLC 1's North path follows no schedule.
+	old := func(id int) PLCImage {
+		img := cloneImage(compileImage(data, id))
+		img.Schedules[0][4] = 2200
+		if id == 1 {
+			img.Map[9] = 0
+		}
+		return img
+	}
+
+	tests := []struct {
+		name        string
+		setup       func(plcs []*fakeRegisterPLC, cancel context.CancelFunc)
+		wantErr     string // Substring; "" for success
+		wantStates  []string
+		wantImages  []string // "new" or "old", per PLC
+		wantResyncs []int    // C151 writes, per PLC
+	}{
+		{
+			name:        "both written",
+			setup:       func([]*fakeRegisterPLC, context.CancelFunc) {},
+			wantStates:  []string{"committed", "committed"},
+			wantImages:  []string{"new", "new"},
+			wantResyncs: []int{1, 1}, // Both have a loop on slot 1
+		},
+		{
+			name:        "PLC 2 can't be read",
+			setup:       func(plcs []*fakeRegisterPLC, _ context.CancelFunc) { plcs[1].failReads = true },
+			wantErr:     "could not read PLC 2 before writing, nothing was written",
+			wantStates:  []string{"not_written", "not_written"},
+			wantImages:  []string{"old", "old"},
+			wantResyncs: []int{0, 0},
+		},
+		{
+			name:        "PLC 2 write fails after PLC 1 was written",
+			setup:       func(plcs []*fakeRegisterPLC, _ context.CancelFunc) { plcs[1].failWrites = true },
+			wantErr:     "PLC 2",
+			wantStates:  []string{"rolled_back", "rolled_back"},
+			wantImages:  []string{"old", "old"},
+			wantResyncs: []int{0, 0},
+		},
+		{
+			name:        "PLC 1 reads back wrong",
+			setup:       func(plcs []*fakeRegisterPLC, _ context.CancelFunc) { plcs[0].stuck[104] = true },
+			wantErr:     "read back 1 register(s) that differ from what was written, first DS104",
+			wantStates:  []string{"rolled_back", "not_written"},
+			wantImages:  []string{"old", "old"},
+			wantResyncs: []int{0, 0},
+		},
+		{
+			name: "canceled after writing PLC 1",
+			setup: func(plcs []*fakeRegisterPLC, cancel context.CancelFunc) {
+				plcs[0].onWrite = cancel
+			},
+			wantErr:     "push stopped before PLC 2",
+			wantStates:  []string{"rolled_back", "not_written"},
+			wantImages:  []string{"old", "old"},
+			wantResyncs: []int{0, 0},
+		},
+		{
+			name: "canceled after PLC 1 reloaded",
+			setup: func(plcs []*fakeRegisterPLC, cancel context.CancelFunc) {
+				plcs[0].onCoil = cancel
+			},
+			wantErr:     "push stopped before setting C151 on PLC 2",
+			wantStates:  []string{"rolled_back", "rolled_back"},
+			wantImages:  []string{"old", "old"},
+			wantResyncs: []int{2, 0}, // PLC 1 reloads the old image again
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plcs := make([]*fakeRegisterPLC, 2)
+			cfgs := map[int]PLCConfig{}
+			for i := range plcs {
+				var addr string
+				plcs[i], addr = startFakeRegisterPLC(t)
+				plcs[i].load(old(i + 1))
+				cfgs[i+1] = PLCConfig{Address: addr}
+			}
+			sessions := NewPLCSessions(cfgs)
+			t.Cleanup(sessions.CloseAll)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tt.setup(plcs, cancel)
+
+			writes, results, err := PushConfigurationToPLCs(ctx, sessions, data, false)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+			}
+
+			var states []string
+			for _, r := range results {
+				states = append(states, r.State)
+			}
+			if !slices.Equal(states, tt.wantStates) {
+				t.Errorf("states %v, want %v", states, tt.wantStates)
+			}
+			for i, plc := range plcs {
+				want := old(i + 1)
+				if tt.wantImages[i] == "new" {
+					want = compileImage(data, i+1)
+				}
+				if diff := diffImages(want, plc.image()); len(diff) > 0 {
+					t.Errorf("PLC %d holds %d register(s) that differ from its %s image, first %+v", i+1, len(diff), tt.wantImages[i], diff[0])
+				}
+				if got := plc.resyncs(); got != tt.wantResyncs[i] {
+					t.Errorf("PLC %d had C151 set %d times, want %d", i+1, got, tt.wantResyncs[i])
+				}
+			}
+			rolledBack := slices.ContainsFunc(writes, func(w string) bool { return strings.HasSuffix(w, "(rollback)") })
+			if rolledBack != slices.Contains(tt.wantStates, "rolled_back") {
+				t.Errorf("writes %v: rollback marked %v", writes, rolledBack)
+			}
+		})
+	}
+}
+
+func TestPushResultSummary(t *testing.T) {
+	tests := []struct {
+		result PLCPushResult
+		want   string
+	}{
+		{PLCPushResult{State: "unchanged"}, "unchanged"},
+		{PLCPushResult{State: "committed", SlotsWritten: []int{1, 3}, MapWritten: true, Resync: true}, "wrote slots 1,3 and map, C151 set"},
+		{PLCPushResult{State: "committed", SlotsWritten: []int{5}}, "wrote slots 5, overrides kept (no C151)"},
+		{PLCPushResult{State: "not_written"}, "not written"},
+		{PLCPushResult{State: "rolled_back", Error: "PLC 2: timeout"}, "rolled back to the old config: PLC 2: timeout"},
+	}
+	for _, tt := range tests {
+		if got := tt.result.summary(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.result, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/goburrow/modbus"
+)
+
+// registerRange is a run of PLC addresses such as DS100-DS939 or C101-C124.
+type registerRange struct {
+	Kind  string // "DS", "SD" or "C"
+	First int
+	Count int
+}
+
+// modbusAddress is the Modbus address of address n of the range's kind.
+func (r registerRange) modbusAddress(n int) uint16 {
+	switch r.Kind {
+	case "SD":
+		return sdToModbusAddress(n)
+	case "C":
+		addr, _ := cBitToModbusAddress(n)
+		return addr
+	}
+	return uint16(n - 1) // DS1 is Modbus 0
+}
+
+// readRegisters reads a DS or SD range, 125 registers (the Modbus limit) at a time.
+func readRegisters(session *PLCSession, r registerRange) ([]uint16, error) {
+	values := make([]uint16, 0, r.Count)
+	err := session.Do(func(client modbus.Client) error {
+		for n := r.First; n < r.First+r.Count; n += 125 {
+			count := min(125, r.First+r.Count-n)
+			raw, err := client.ReadHoldingRegisters(r.modbusAddress(n), uint16(count))
+			if err != nil {
+				return fmt.Errorf("failed to read %s%d-%s%d: %w", r.Kind, n, r.Kind, n+count-1, err)
+			}
+			for i := 0; i+1 < len(raw); i += 2 {
+				values = append(values, uint16(raw[i])<<8|uint16(raw[i+1]))
+			}
+		}
+		return nil
+	})
+	return values, err
+}
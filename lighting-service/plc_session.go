@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// plcTimeout is the connect and per-request timeout for Modbus TCP.
+const plcTimeout = 5 * time.Second
+
+// PLCError is a failed request to a PLC (connect, read or write).
+type PLCError struct {
+	PLCID int
+	Err   error
+}
+
+func (e *PLCError) Error() string { return e.Err.Error() }
+func (e *PLCError) Unwrap() error { return e.Err }
+
+// PLCSession is a reusable Modbus TCP connection to one PLC.
+// Requests to the same PLC are serialized so pulses and block writes never interleave.
+type PLCSession struct {
+	ID   int
+	Host string
+
+	mu      sync.Mutex
+	handler *modbus.TCPClientHandler
+	client  modbus.Client
+
+	// Outcome of the last request, for status reporting, and the PLC's
+	// name, roles and capabilities, which a reload can change.
+	stateMu sync.Mutex
+	lastErr error
+	lastAt  time.Time
+	info    PLCConfig
+}
+
+func newPLCSession(id int, info PLCConfig) *PLCSession {
+	host := info.Address
+	handler := modbus.NewTCPClientHandler(host)
+	handler.Timeout = plcTimeout
+	// Raw frames are only written when the modbus subsystem is at debug level.
+	handler.Logger = slog.NewLogLogger(modbusLog.With("plc", id).Handler(), slog.LevelDebug)
+	return &PLCSession{
+		ID:      id,
+		Host:    host,
+		info:    info,
+		handler: handler,
+		client:  modbus.NewClient(handler),
+	}
+}
+
+// Do runs fn with a connected client. If fn fails, the connection is dropped
+// so the next call starts with a fresh one. Errors are returned as *PLCError.
+func (s *PLCSession) Do(fn func(client modbus.Client) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.handler.Connect(); err != nil {
+		err = fmt.Errorf("connect to PLC %d at %s: %w", s.ID, s.Host, err)
+		s.record(err)
+		return &PLCError{PLCID: s.ID, Err: err}
+	}
+	if err := fn(s.client); err != nil {
+		s.handler.Close()
+		s.record(err)
+		return &PLCError{PLCID: s.ID, Err: err}
+	}
+	s.record(nil)
+	return nil
+}
+
+func (s *PLCSession) record(err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.lastErr = err
+	s.lastAt = time.Now()
+}
+
+// State describes the last request without waiting for one in progress:
+// "not contacted yet", "ok" or "unreachable (...)".
+func (s *PLCSession) State() string {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	switch {
+	case s.lastAt.IsZero():
+		return "not contacted yet"
+	case s.lastErr != nil:
+		return fmt.Sprintf("unreachable since %s (%v)", s.lastAt.Format("15:04:05"), s.lastErr)
+	default:
+		return "ok"
+	}
+}
+
+// Info returns the PLC's config entry.
+func (s *PLCSession) Info() PLCConfig {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.info
+}
+
+// Can reports whether the PLC has the capability.
+func (s *PLCSession) Can(capability string) bool { return s.Info().Can(capability) }
+
+// Close waits for any request in progress and then closes the connection.
+func (s *PLCSession) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler.Close()
+}
+
+// PLCSessions holds one session per configured PLC.
+type PLCSessions struct {
+	mu       sync.RWMutex
+	sessions map[int]*PLCSession
+	reporter int // See Config.scheduleReporter
+}
+
+func NewPLCSessions(plcs map[int]PLCConfig) *PLCSessions {
+	p := &PLCSessions{sessions: make(map[int]*PLCSession)}
+	p.Rebuild(plcs)
+	return p
+}
+
+// Rebuild brings the sessions in line with a new PLC map. Sessions whose
+// address is unchanged are kept; removed or re-addressed ones are closed.
+func (p *PLCSessions) Rebuild(plcs map[int]PLCConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, s := range p.sessions {
+		if plcs[id].Address != s.Host {
+			modbusLog.Info("closing PLC session", "plc", id, "host", s.Host)
+			go s.Close() // Don't hold the pool lock while a request finishes
+			delete(p.sessions, id)
+		}
+	}
+	for id, info := range plcs {
+		if info.Address == "" {
+			continue // Unconfigured PLC
+		}
+		if s, ok := p.sessions[id]; ok {
+			s.stateMu.Lock()
+			s.info = info
+			s.stateMu.Unlock()
+		} else {
+			p.sessions[id] = newPLCSession(id, info)
+		}
+	}
+	p.reporter = Config{PLCs: plcs}.scheduleReporter()
+}
+
+// Reporter returns the ID of the PLC whose schedule bits and photocell
+// /status reports, or 0 if there is none.
+func (p *PLCSessions) Reporter() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.reporter
+}
+
+// Get returns the session for a PLC ID.
+func (p *PLCSessions) Get(id int) (*PLCSession, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s, ok := p.sessions[id]
+	return s, ok
+}
+
+// All returns every session, ordered by PLC ID.
+func (p *PLCSessions) All() []*PLCSession {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	all := make([]*PLCSession, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// With returns the sessions of the PLCs with a capability, ordered by PLC ID.
+func (p *PLCSessions) With(capability string) []*PLCSession {
+	var with []*PLCSession
+	for _, s := range p.All() {
+		if s.Can(capability) {
+			with = append(with, s)
+		}
+	}
+	return with
+}
+
+// CloseAll closes every session, waiting for requests in progress.
+func (p *PLCSessions) CloseAll() {
+	for _, s := range p.All() {
+		s.Close()
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Pulse requests (C201+/C251+) go through one queue per PLC. The ladder's
+// sequencer handles one pulse at a time and the relay power supply can drive
+// at most 4 relays at once, so the service limits how many requests it will
+// hand each PLC: a token bucket of PulseBudget pulses, refilled at
+// PulseRefillPerMinute. A request for a loop index that is still waiting in
+// the queue is merged with it (the latest state wins) and costs nothing.
+
+// pulse is one ON/OFF request for an output loop on a PLC.
+type pulse struct {
+	PLCID     int
+	LoopIndex int    // 0-based; the coil is C201+LoopIndex or C251+LoopIndex
+	State     string // "on" or "off"
+	Output    string // First Y output of the mapping, for logging
+}
+
+// coil returns the request bit for the pulse's state.
+func (p pulse) coil() int {
+	if p.State == "on" {
+		return 201 + p.LoopIndex
+	}
+	return 251 + p.LoopIndex
+}
+
+type pulseResult struct {
+	write string // "PLC1:C203", for the audit log
+	err   error
+}
+
+type pendingPulse struct {
+	pulse
+	waiters []chan pulseResult
+}
+
+// ThrottledError means the PLC's pulse budget is used up.
+type ThrottledError struct {
+	PLCID      int
+	RetryAfter time.Duration // 0 if the request can never fit the budget
+	Needed     int
+	Budget     int
+}
+
+func (e *ThrottledError) Error() string {
+	if e.RetryAfter == 0 {
+		return fmt.Sprintf("request needs %d pulses on PLC %d but the budget is %d", e.Needed, e.PLCID, e.Budget)
+	}
+	return fmt.Sprintf("pulse budget for PLC %d is used up; retry in %s", e.PLCID, e.RetryAfter.Round(time.Second))
+}
+
+type pulseQueue struct {
+	plcID    int
+	tokens   float64
+	refilled time.Time
+	pending  []*pendingPulse
+	wake     chan struct{}
+}
+
+// PulseQueues owns the per-PLC queues and their worker goroutines.
+type PulseQueues struct {
+	sessions *PLCSessions
+	config   func() Config
+
+	mu     sync.Mutex // Guards every queue, so a zone's pulses are admitted all or nothing
+	queues map[int]*pulseQueue
+}
+
+func NewPulseQueues(sessions *PLCSessions, config func() Config) *PulseQueues {
+	return &PulseQueues{sessions: sessions, config: config, queues: make(map[int]*pulseQueue)}
+}
+
+// queue returns the queue for a PLC, starting its worker on first use. Call with p.mu held.
+func (p *PulseQueues) queue(plcID int) *pulseQueue {
+	q, ok := p.queues[plcID]
+	if !ok {
+		q = &pulseQueue{plcID: plcID, tokens: float64(p.config().PulseBudget), refilled: time.Now(), wake: make(chan struct{}, 1)}
+		p.queues[plcID] = q
+		go p.run(q)
+	}
+	return q
+}
+
+// refill adds the tokens earned since the last refill. Call with p.mu held.
+// now can be a little before a queue created in the same request was filled.
+func (q *pulseQueue) refill(cfg Config, now time.Time) {
+	if now.Before(q.refilled) {
+		return
+	}
+	perSecond := cfg.PulseRefillPerMinute / 60
+	q.tokens = math.Min(float64(cfg.PulseBudget), q.tokens+now.Sub(q.refilled).Seconds()*perSecond)
+	q.refilled = now
+}
+
+// Submit queues the pulses and waits until they have been written. It returns
+// the coils written and the last error, or a *ThrottledError (nothing queued)
+// if any PLC lacks the budget for its share.
+func (p *PulseQueues) Submit(pulses []pulse) ([]string, error) {
+	results, err := p.SubmitEach(pulses)
+	if err != nil {
+		return nil, err
+	}
+	var writes []string
+	var lastErr error
+	for _, res := range results {
+		if res.err != nil {
+			lastErr = res.err
+			continue
+		}
+		writes = append(writes, res.write)
+	}
+	return writes, lastErr
+}
+
+// SubmitEach is Submit with one result per pulse, in order, for callers that
+// report on each target. Admission is still all or nothing.
+func (p *PulseQueues) SubmitEach(pulses []pulse) ([]pulseResult, error) {
+	cfg := p.config()
+	now := time.Now()
+
+	p.mu.Lock()
+	needed := make(map[int]int)
+	seen := make(map[[2]int]bool)
+	for _, pl := range pulses {
+		key := [2]int{pl.PLCID, pl.LoopIndex}
+		if !seen[key] && p.queue(pl.PLCID).find(pl.LoopIndex) == nil {
+			needed[pl.PLCID]++
+		}
+		seen[key] = true
+	}
+	for plcID, n := range needed {
+		q := p.queue(plcID)
+		q.refill(cfg, now)
+		if float64(n) <= q.tokens {
+			continue
+		}
+		p.mu.Unlock()
+		err := &ThrottledError{PLCID: plcID, Needed: n, Budget: cfg.PulseBudget}
+		if n <= cfg.PulseBudget && cfg.PulseRefillPerMinute > 0 {
+			wait := (float64(n) - q.tokens) / (cfg.PulseRefillPerMinute / 60)
+			err.RetryAfter = time.Duration(math.Ceil(wait)) * time.Second
+		}
+		modbusLog.Warn("pulse request throttled", "plc", plcID, "needed", n, "available", int(q.tokens))
+		return nil, err
+	}
+
+	waiters := make([]chan pulseResult, 0, len(pulses))
+	for _, pl := range pulses {
+		q := p.queue(pl.PLCID)
+		done := make(chan pulseResult, 1)
+		waiters = append(waiters, done)
+		if pp := q.find(pl.LoopIndex); pp != nil {
+			if pp.State != pl.State {
+				modbusLog.Info("coalesced pulse, later state wins", "plc", pl.PLCID, "loop", pl.LoopIndex+1, "was", pp.State, "now", pl.State)
+			}
+			pp.State = pl.State
+			pp.waiters = append(pp.waiters, done)
+			continue
+		}
+		q.tokens--
+		q.pending = append(q.pending, &pendingPulse{pulse: pl, waiters: []chan pulseResult{done}})
+		notify(q.wake)
+	}
+	p.mu.Unlock()
+
+	results := make([]pulseResult, len(waiters))
+	for i, done := range waiters {
+		results[i] = <-done
+	}
+	return results, nil
+}
+
+// find returns the queued (not yet written) pulse for a loop index. Call with p.mu held.
+func (q *pulseQueue) find(loopIndex int) *pendingPulse {
+	for _, pp := range q.pending {
+		if pp.LoopIndex == loopIndex {
+			return pp
+		}
+	}
+	return nil
+}
+
+// run writes a PLC's queued pulses one at a time, PulseSpacingMs apart.
+func (p *PulseQueues) run(q *pulseQueue) {
+	for range q.wake {
+		for {
+			p.mu.Lock()
+			if len(q.pending) == 0 {
+				p.mu.Unlock()
+				break
+			}
+			pp := q.pending[0]
+			q.pending = q.pending[1:]
+			p.mu.Unlock()
+
+			res := p.write(pp.pulse)
+			for _, w := range pp.waiters {
+				w <- res
+			}
+			time.Sleep(time.Duration(p.config().PulseSpacingMs) * time.Millisecond)
+		}
+	}
+}
+
+func (p *PulseQueues) write(pl pulse) pulseResult {
+	session, ok := p.sessions.Get(pl.PLCID)
+	if !ok {
+		return pulseResult{err: fmt.Errorf("PLC %d is not configured", pl.PLCID)}
+	}
+	cBit := pl.coil()
+	addr, _ := cBitToModbusAddress(cBit)
+	request := fmt.Sprintf("RequestON (C%d)", cBit)
+	if pl.State != "on" {
+		request = fmt.Sprintf("RequestOFF (C%d)", cBit)
+	}
+	modbusLog.Info("pulsing", "request", request, "output", pl.Output, "plc", pl.PLCID, "host", session.Host, "loop", pl.LoopIndex+1)
+	if err := setPLCBit(session, addr); err != nil {
+		modbusLog.Error("pulse failed", "plc", pl.PLCID, "host", session.Host, "err", err)
+		return pulseResult{err: err}
+	}
+	return pulseResult{write: fmt.Sprintf("PLC%d:C%d", pl.PLCID, cBit)}
+}
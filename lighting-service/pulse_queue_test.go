@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCoilPLC is a Modbus TCP server that accepts single coil writes (FC5)
+// and records their addresses. While failing is set it refuses them.
+type fakeCoilPLC struct {
+	mu      sync.Mutex
+	writes  []uint16
+	failing atomic.Bool
+}
+
+func startFakeCoilPLC(t *testing.T) (*fakeCoilPLC, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	plc := &fakeCoilPLC{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go plc.serve(conn)
+		}
+	}()
+	return plc, ln.Addr().String()
+}
+
+func (f *fakeCoilPLC) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 7) // Transaction, protocol, length, unit
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		reply := []byte{pdu[0] | 0x80, 1} // Illegal function
+		if f.failing.Load() {
+			reply[1] = 4 // Server device failure
+		} else if pdu[0] == 5 {
+			f.mu.Lock()
+			f.writes = append(f.writes, binary.BigEndian.Uint16(pdu[1:3]))
+			f.mu.Unlock()
+			reply = pdu[:5] // FC5 echoes the request
+		}
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(reply)+1))
+		conn.Write(append(header, reply...))
+	}
+}
+
+func (f *fakeCoilPLC) coils() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var coils []int
+	for _, addr := range f.writes {
+		coils = append(coils, int(addr)-16384+1)
+	}
+	return coils
+}
+
+func testPulseQueues(t *testing.T, cfg Config) (*PulseQueues, *fakeCoilPLC) {
+	t.Helper()
+	plc, addr := startFakeCoilPLC(t)
+	sessions := NewPLCSessions(map[int]PLCConfig{1: {Address: addr}})
+	t.Cleanup(sessions.CloseAll)
+	return NewPulseQueues(sessions, func() Config { return cfg }), plc
+}
+
+func pulseConfig(budget int, refillPerMinute float64) Config {
+	cfg := defaultConfig()
+	cfg.PulseBudget = budget
+	cfg.PulseRefillPerMinute = refillPerMinute
+	cfg.PulseSpacingMs = 0
+	return cfg
+}
+
+func TestSubmitEachCoalesces(t *testing.T) {
+	tests := []struct {
+		name       string
+		pulses     []pulse
+		wantWrites []string // One per pulse, in order
+		wantCoils  []int    // Written to the PLC
+	}{
+		{
+			name:       "distinct loops",
+			pulses:     []pulse{{PLCID: 1, LoopIndex: 0, State: "on"}, {PLCID: 1, LoopIndex: 2, State: "off"}},
+			wantWrites: []string{"PLC1:C201", "PLC1:C253"},
+			wantCoils:  []int{201, 253},
+		},
+		{
+			name:       "same loop twice, later state wins",
+			pulses:     []pulse{{PLCID: 1, LoopIndex: 1, State: "on"}, {PLCID: 1, LoopIndex: 1, State: "off"}},
+			wantWrites: []string{"PLC1:C252", "PLC1:C252"},
+			wantCoils:  []int{252},
+		},
+		{
+			name:       "same loop and state",
+			pulses:     []pulse{{PLCID: 1, LoopIndex: 3, State: "on"}, {PLCID: 1, LoopIndex: 0, State: "on"}, {PLCID: 1, LoopIndex: 3, State: "on"}},
+			wantWrites: []string{"PLC1:C204", "PLC1:C201", "PLC1:C204"},
+			wantCoils:  []int{204, 201},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, plc := testPulseQueues(t, pulseConfig(10, 0))
+			results, err := q.SubmitEach(tt.pulses)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var writes []string
+			for _, res := range results {
+				if res.err != nil {
+					t.Fatalf("pulse failed: %v", res.err)
+				}
+				writes = append(writes, res.write)
+			}
+			if !slices.Equal(writes, tt.wantWrites) {
+				t.Errorf("results %v, want %v", writes, tt.wantWrites)
+			}
+			if coils := plc.coils(); !slices.Equal(coils, tt.wantCoils) {
+				t.Errorf("coils written %v, want %v", coils, tt.wantCoils)
+			}
+		})
+	}
+}
+
+func TestSubmitEachThrottles(t *testing.T) {
+	loops := func(n int) []pulse {
+		var pulses []pulse
+		for i := range n {
+			pulses = append(pulses, pulse{PLCID: 1, LoopIndex: i, State: "on"})
+		}
+		return pulses
+	}
+	tests := []struct {
+		name      string
+		budget    int
+		refill    float64
+		first     []pulse // Admitted before the request under test
+		request   []pulse
+		wantErr   bool
+		wantRetry time.Duration // For a *ThrottledError; 0 means never fits
+	}{
+		{name: "within budget", budget: 3, request: loops(3)},
+		{name: "repeated loop costs one", budget: 1, request: []pulse{{PLCID: 1, State: "on"}, {PLCID: 1, State: "off"}}},
+		{name: "more than the budget", budget: 2, refill: 60, request: loops(3), wantErr: true},
+		{name: "budget used up", budget: 2, refill: 60, first: loops(2), request: loops(1), wantErr: true, wantRetry: time.Second},
+		{name: "budget used up, no refill", budget: 2, first: loops(2), request: loops(1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, plc := testPulseQueues(t, pulseConfig(tt.budget, tt.refill))
+			if tt.first != nil {
+				if _, err := q.SubmitEach(tt.first); err != nil {
+					t.Fatalf("first request: %v", err)
+				}
+			}
+			before := len(plc.coils())
+			_, err := q.SubmitEach(tt.request)
+
+			var throttled *ThrottledError
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &throttled) {
+				t.Fatalf("error %v, want *ThrottledError", err)
+			}
+			if throttled.RetryAfter != tt.wantRetry {
+				t.Errorf("RetryAfter %v, want %v", throttled.RetryAfter, tt.wantRetry)
+			}
+			if after := len(plc.coils()); after != before {
+				t.Errorf("a throttled request wrote %d coils, want none", after-before)
+			}
+		})
+	}
+}
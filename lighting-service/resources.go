@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Read-only views of the WordPress config and the configured PLCs for the
+// /api/v1 collection routes. The config is fetched on every request, like the
+// override and status handlers do, so these always match what WordPress has.
+// Zones and mappings also carry the live state read from the PLCs, so callers
+// don't have to match /status keys against mappings themselves.
+
+// ZoneResource is a zone with the live state of its lights. State is "on" or
+// "off" when every light agrees, "mixed" when they don't and "unknown" when no
+// light could be read (or the zone has none).
+type ZoneResource struct {
+	FullConfigZone
+	State          string            `json:"state"`
+	ScheduleSlot   int               `json:"schedule_slot"`   // PLC schedule 1-12 (C1-C12); 0 if none
+	ScheduleActive *bool             `json:"schedule_active"` // null if not read
+	Overridden     bool              `json:"overridden"`      // Some light differs from its schedule
+	MappingIDs     []int             `json:"mapping_ids"`
+	Lights         []MappingResource `json:"lights"`
+}
+
+// MappingResource is a mapping (one PLC output loop) with its coils and live state.
+type MappingResource struct {
+	FullConfigMapping
+	LoopIndex      int        `json:"loop_index"` // 0-based; -1 if the first output is not a valid Y output
+	Coils          *loopCoils `json:"coils"`      // null if LoopIndex is -1
+	On             *bool      `json:"on"`         // null if not read
+	ScheduleSlot   int        `json:"schedule_slot"`
+	ScheduleActive *bool      `json:"schedule_active"`
+	Overridden     bool       `json:"overridden"` // On differs from the schedule, i.e. a manual override is in effect
+}
+
+// loopCoils are the C bits the ladder uses for one output loop.
+type loopCoils struct {
+	State       coilRef `json:"state"`        // C101+
+	RequestOn   coilRef `json:"request_on"`   // C201+
+	RequestOff  coilRef `json:"request_off"`  // C251+
+	MapRegister string  `json:"map_register"` // DS1000+, holds the schedule slot
+}
+
+type coilRef struct {
+	Name    string `json:"name"`    // e.g. "C101"
+	Address uint16 `json:"address"` // Modbus coil address
+}
+
+func newCoilRef(cBit int) coilRef {
+	addr, _ := cBitToModbusAddress(cBit)
+	return coilRef{Name: fmt.Sprintf("C%d", cBit), Address: addr}
+}
+
+// scheduleSlots maps each WordPress schedule ID to the PLC slot (1-12) it is
+// pushed to, in the same order PushConfigurationToPLCs uses.
+func scheduleSlots(data *FullConfigurationData) map[int]int {
+	slots := make(map[int]int)
+	for i, schedule := range data.Schedules {
+		if i >= 12 {
+			break
+		}
+		slots[schedule.ID] = i + 1
+	}
+	return slots
+}
+
+// statusView builds zone and mapping resources from the config and a status
+// map as returned by ReadStatusFromPLCs (or the simulator).
+type statusView struct {
+	data   *FullConfigurationData
+	status map[string]interface{}
+	slots  map[int]int
+	zones  map[int]FullConfigZone
+}
+
+func newStatusView(data *FullConfigurationData, status map[string]interface{}) *statusView {
+	v := &statusView{data: data, status: status, slots: scheduleSlots(data), zones: make(map[int]FullConfigZone)}
+	for _, z := range data.Zones {
+		v.zones[z.ID] = z
+	}
+	return v
+}
+
+func (v *statusView) boolAt(key string) *bool {
+	if b, ok := v.status[key].(bool); ok {
+		return &b
+	}
+	return nil
+}
+
+// scheduleActive reads the C1-C12 bit for a WordPress schedule ID.
+func (v *statusView) scheduleActive(scheduleID int) *bool {
+	if _, ok := v.slots[scheduleID]; !ok {
+		return nil
+	}
+	return v.boolAt(fmt.Sprintf("Sched%d", scheduleID))
+}
+
+func (v *statusView) mapping(m FullConfigMapping) MappingResource {
+	res := MappingResource{FullConfigMapping: m, LoopIndex: -1}
+	if len(m.PLCOutputs) > 0 {
+		res.LoopIndex = calculateLoopIndex(m.PLCOutputs[0])
+	}
+	if res.LoopIndex < 0 {
+		return res
+	}
+	res.Coils = &loopCoils{
+		State:       newCoilRef(101 + res.LoopIndex),
+		RequestOn:   newCoilRef(201 + res.LoopIndex),
+		RequestOff:  newCoilRef(251 + res.LoopIndex),
+		MapRegister: fmt.Sprintf("DS%d", 1000+res.LoopIndex),
+	}
+	res.On = v.boolAt(fmt.Sprintf("PLC%d-%s", m.PLCID, m.PLCOutputs[0]))
+
+	// The PLC's map block gives each loop the schedule of its first zone.
+	if len(m.LinkedZoneIDs) > 0 {
+		scheduleID := v.zones[m.LinkedZoneIDs[0]].ScheduleID
+		res.ScheduleSlot = v.slots[scheduleID]
+		res.ScheduleActive = v.scheduleActive(scheduleID)
+	}
+	res.Overridden = res.On != nil && res.ScheduleActive != nil && *res.On != *res.ScheduleActive
+	return res
+}
+
+func (v *statusView) zone(zone FullConfigZone) ZoneResource {
+	res := ZoneResource{
+		FullConfigZone: zone,
+		State:          "unknown",
+		ScheduleSlot:   v.slots[zone.ScheduleID],
+		ScheduleActive: v.scheduleActive(zone.ScheduleID),
+		MappingIDs:     []int{},
+		Lights:         []MappingResource{},
+	}
+	var on, off int
+	for _, m := range v.data.Mappings {
+		if !slices.Contains(m.LinkedZoneIDs, zone.ID) {
+			continue
+		}
+		light := v.mapping(m)
+		res.MappingIDs = append(res.MappingIDs, m.ID)
+		res.Lights = append(res.Lights, light)
+		res.Overridden = res.Overridden || light.Overridden
+		switch {
+		case light.On == nil:
+		case *light.On:
+			on++
+		default:
+			off++
+		}
+	}
+	switch {
+	case on > 0 && off > 0:
+		res.State = "mixed"
+	case on > 0:
+		res.State = "on"
+	case off > 0:
+		res.State = "off"
+	}
+	return res
+}
+
+// PLCResource is one configured PLC and how the last request to it went.
+type PLCResource struct {
+	ID           int       `json:"id"`
+	Host         string    `json:"host"`
+	Name         string    `json:"name,omitempty"`
+	Roles        []string  `json:"roles"`
+	Capabilities []string  `json:"capabilities"`    // Listed in full when the config leaves them out
+	State        string    `json:"state"`           // "ok", "not contacted yet" or "unreachable since ..."
+	Clock        *PLCClock `json:"clock,omitempty"` // Last clock check
+}
+
+// fetchConfig fetches the WordPress config, writing the error response on failure.
+func (app *App) fetchConfig(w http.ResponseWriter, r *http.Request) (*FullConfigurationData, bool) {
+	data, err := FetchConfigurationFromAPI(app.config())
+	if err != nil {
+		writeError(w, r, configUnavailable(err))
+		return nil, false
+	}
+	return data, true
+}
+
+// readStatus reads the live (or simulated) status for a statusView. A PLC that
+// can't be read leaves its lights out of the map, so they show as unknown.
+func (app *App) readStatus(data *FullConfigurationData) (map[string]interface{}, error) {
+	if app.isSimulationMode() {
+		return app.getSimulatedState()
+	}
+	done, err := app.beginPLCWork()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	status, err := ReadStatusFromPLCs(app.sessions, data)
+	if err == nil {
+		app.observeStatus(data, status)
+	}
+	return status, err
+}
+
+// fetchStatusView fetches the config and live status, writing the error response on failure.
+func (app *App) fetchStatusView(w http.ResponseWriter, r *http.Request) (*statusView, bool) {
+	data, ok := app.fetchConfig(w, r)
+	if !ok {
+		return nil, false
+	}
+	status, err := app.readStatus(data)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+	return newStatusView(data, status), true
+}
+
+func (app *App) handleListZones(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	view, ok := app.fetchStatusView(w, r)
+	if !ok {
+		return
+	}
+	zones := make([]ZoneResource, 0, len(view.data.Zones))
+	for _, z := range view.data.Zones {
+		zones = append(zones, view.zone(z))
+	}
+	writeJSON(w, http.StatusOK, zones)
+}
+
+func (app *App) handleGetZone(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	view, ok := app.fetchStatusView(w, r)
+	if !ok {
+		return
+	}
+	zone, ok := view.zones[id]
+	if !ok {
+		writeError(w, r, apiError(http.StatusNotFound, codeZoneNotFound, "zone %d not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, view.zone(zone))
+}
+
+func (app *App) handleListMappings(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	view, ok := app.fetchStatusView(w, r)
+	if !ok {
+		return
+	}
+	mappings := make([]MappingResource, 0, len(view.data.Mappings))
+	for _, m := range view.data.Mappings {
+		mappings = append(mappings, view.mapping(m))
+	}
+	writeJSON(w, http.StatusOK, mappings)
+}
+
+func (app *App) handleGetMapping(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	view, ok := app.fetchStatusView(w, r)
+	if !ok {
+		return
+	}
+	i := slices.IndexFunc(view.data.Mappings, func(m FullConfigMapping) bool { return m.ID == id })
+	if i < 0 {
+		writeError(w, r, apiError(http.StatusNotFound, codeMappingNotFound, "mapping %d not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, view.mapping(view.data.Mappings[i]))
+}
+
+func (app *App) handleListSchedules(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data, ok := app.fetchConfig(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, append([]FullConfigSchedule{}, data.Schedules...))
+}
+
+func (app *App) handleGetSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	data, ok := app.fetchConfig(w, r)
+	if !ok {
+		return
+	}
+	i := slices.IndexFunc(data.Schedules, func(s FullConfigSchedule) bool { return s.ID == id })
+	if i < 0 {
+		writeError(w, r, apiError(http.StatusNotFound, codeNotFound, "schedule %d not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, data.Schedules[i])
+}
+
+func (app *App) plcResource(id int, plc PLCConfig) PLCResource {
+	res := PLCResource{ID: id, Host: plc.Address, Name: plc.Name, Roles: []string{}, Capabilities: []string{}, State: "not configured"}
+	res.Roles = append(res.Roles, plc.Roles...)
+	for _, c := range validCapabilities {
+		if plc.Can(c) {
+			res.Capabilities = append(res.Capabilities, c)
+		}
+	}
+	if session, ok := app.sessions.Get(id); ok {
+		res.State = session.State()
+	}
+	if c, ok := app.clocks.Clocks()[id]; ok {
+		res.Clock = &c
+	}
+	return res
+}
+
+func (app *App) handleListPLCs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	plcs := []PLCResource{}
+	for id, plc := range app.config().PLCs {
+		plcs = append(plcs, app.plcResource(id, plc))
+	}
+	sort.Slice(plcs, func(i, j int) bool { return plcs[i].ID < plcs[j].ID })
+	writeJSON(w, http.StatusOK, plcs)
+}
+
+func (app *App) handleGetPLC(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	plc, ok := app.config().PLCs[id]
+	if !ok {
+		writeError(w, r, apiError(http.StatusNotFound, codeNotFound, "PLC %d is not configured", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, app.plcResource(id, plc))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// route is one entry in the API route table. The same table registers the
+// handlers and generates the OpenAPI document (openapi.go), so the two can't
+// drift apart.
+type route struct {
+	Method  string
+	Path    string // httprouter syntax, e.g. /api/v1/zones/:id
+	Scope   string // Required scope; "" for public routes
+	Handle  httprouter.Handle
+	Summary string
+	Tag     string
+
+	Query    []queryParam
+	Body     any    // Zero value of the request body type, if any
+	Response any    // Zero value of the success body type
+	Alias    string // Original unversioned path served by the same handler
+}
+
+type queryParam struct {
+	Name, Type, Description string
+}
+
+// apiV1 is the prefix for the versioned API. The original unversioned routes
+// are kept as aliases for existing callers and marked deprecated in the document.
+const apiV1 = "/api/v1"
+
+func (app *App) routes() []route {
+	auditQuery := []queryParam{
+		{"since", "date-time", "Entries at or after this time (RFC3339)"},
+		{"until", "date-time", "Entries before this time (RFC3339)"},
+		{"caller", "string", "WordPress user or token:<name>"},
+		{"action", "string", "override, test or sync"},
+		{"zone", "integer", "Zone ID"},
+		{"mapping", "integer", "Mapping ID"},
+		{"limit", "integer", "Return only the newest N entries"},
+		{"format", "string", "csv for a spreadsheet download"},
+	}
+
+	return []route{
+		{Method: "GET", Path: apiV1 + "/openapi.json", Handle: app.handleOpenAPI, Summary: "This document", Tag: "meta", Response: map[string]any{}},
+		{Method: "GET", Path: apiV1 + "/health", Alias: "/health", Scope: scopeStatus, Handle: app.handleHealth, Summary: "Service health", Tag: "meta", Response: HealthReport{}},
+		{Method: "GET", Path: apiV1 + "/status", Alias: "/status", Scope: scopeStatus, Handle: app.handleStatus, Summary: "Live output, schedule and photocell bits, keyed like PLC1-Y101", Tag: "status", Response: map[string]any{}},
+
+		{Method: "GET", Path: apiV1 + "/zones", Scope: scopeStatus, Handle: app.handleListZones, Summary: "List zones with their computed state", Tag: "zones", Response: []ZoneResource{}},
+		{Method: "GET", Path: apiV1 + "/zones/:id", Scope: scopeStatus, Handle: app.handleGetZone, Summary: "Get a zone with its lights, schedule and override status", Tag: "zones", Response: ZoneResource{}},
+		{Method: "POST", Path: apiV1 + "/overrides", Alias: "/override", Scope: scopeOverride, Handle: app.handleOverrides, Summary: "Turn several zones and mappings on or off in one request", Tag: "overrides", Body: overrideRequest{}, Response: overridesResponse{}},
+		{Method: "POST", Path: apiV1 + "/zones/:id/override/:state", Alias: "/override/zone/:id/:state", Scope: scopeOverride, Handle: app.handleOverride, Summary: "Turn every light in a zone on or off", Tag: "overrides", Response: commandResponse{}},
+
+		{Method: "GET", Path: apiV1 + "/mappings", Scope: scopeStatus, Handle: app.handleListMappings, Summary: "List mappings (PLC output loops)", Tag: "mappings", Response: []MappingResource{}},
+		{Method: "GET", Path: apiV1 + "/mappings/:id", Scope: scopeStatus, Handle: app.handleGetMapping, Summary: "Get a mapping with its coils and current state", Tag: "mappings", Response: MappingResource{}},
+		{Method: "POST", Path: apiV1 + "/mappings/:id/test/:state", Alias: "/test/mapping/:id/:state", Scope: scopeTest, Handle: app.handleTestMapping, Summary: "Pulse one mapping to test the wiring", Tag: "mappings", Response: commandResponse{}},
+		{Method: "POST", Path: apiV1 + "/mappings/:id/relamp", Alias: "/runtime/mapping/:id/relamp", Scope: scopeAdmin, Handle: app.handleRelamp, Summary: "Reset lamp hours after replacing bulbs", Tag: "runtime", Response: map[string]any{}},
+
+		{Method: "GET", Path: apiV1 + "/schedules", Scope: scopeStatus, Handle: app.handleListSchedules, Summary: "List schedules", Tag: "schedules", Response: []FullConfigSchedule{}},
+		{Method: "GET", Path: apiV1 + "/schedules/:id", Scope: scopeStatus, Handle: app.handleGetSchedule, Summary: "Get a schedule", Tag: "schedules", Response: FullConfigSchedule{}},
+
+		{Method: "GET", Path: apiV1 + "/plcs", Scope: scopeStatus, Handle: app.handleListPLCs, Summary: "List PLCs and their connectivity", Tag: "plcs", Response: []PLCResource{}},
+		{Method: "GET", Path: apiV1 + "/plcs/:id", Scope: scopeStatus, Handle: app.handleGetPLC, Summary: "Get a PLC", Tag: "plcs", Response: PLCResource{}},
+		{Method: "GET", Path: apiV1 + "/plcs/:id/image", Alias: "/plc/:id/image", Scope: scopeStatus, Handle: app.handlePLCImage, Summary: "Decoded schedule blocks and map on a PLC, diffed against the WordPress config", Tag: "plcs", Response: DecodedImage{}},
+		{Method: "GET", Path: apiV1 + "/drift", Alias: "/drift", Scope: scopeStatus, Handle: app.handleDrift, Summary: "Registers on each PLC that differ from the WordPress config, from the last drift check", Tag: "plcs", Response: map[int]PLCDrift{}},
+		{Method: "POST", Path: apiV1 + "/sync", Alias: "/sync", Scope: scopeSync, Handle: app.handleSyncTrigger, Summary: "Push the changes in the WordPress config to the PLCs", Tag: "plcs", Query: []queryParam{{"full", "boolean", "Rewrite every block and set C151 even if nothing changed"}}, Response: commandResponse{}},
+
+		{Method: "GET", Path: apiV1 + "/photocell", Scope: scopeStatus, Handle: app.handlePhotocell, Summary: "Photocell override, recent changes, sunrise/sunset and alerts", Tag: "photocell", Response: photocellResponse{}},
+		{Method: "POST", Path: apiV1 + "/photocell/force/:state", Scope: scopeOverride, Handle: app.handleForcePhotocell, Summary: "Force the photocell bit C154 on (night) or off (day) on every PLC", Tag: "photocell", Query: []queryParam{{"minutes", "integer", "Timeout; default PhotocellForceMinutes, at most 10080"}}, Response: commandResponse{}},
+		{Method: "DELETE", Path: apiV1 + "/photocell/force", Scope: scopeOverride, Handle: app.handleReleasePhotocell, Summary: "Hand C154 back to the sensor", Tag: "photocell", Response: commandResponse{}},
+
+		{Method: "GET", Path: apiV1 + "/runtime/report", Alias: "/runtime/report", Scope: scopeStatus, Handle: app.handleRuntimeReport, Summary: "On-hours, kWh per month and lamp-life warnings", Tag: "runtime", Response: RuntimeReport{}},
+		{Method: "GET", Path: apiV1 + "/audit", Alias: "/audit", Scope: scopeAdmin, Handle: app.handleAuditQuery, Summary: "Query the audit log of PLC commands", Tag: "admin", Query: auditQuery, Response: []AuditEntry{}},
+		{Method: "GET", Path: apiV1 + "/admin/loglevel", Alias: "/admin/loglevel", Scope: scopeAdmin, Handle: app.handleGetLogLevel, Summary: "Current log levels", Tag: "admin", Response: map[string]any{}},
+		{Method: "POST", Path: apiV1 + "/admin/loglevel", Alias: "/admin/loglevel", Scope: scopeAdmin, Handle: app.handleSetLogLevel, Summary: "Change a log level", Tag: "admin", Body: logLevelRequest{}, Response: map[string]any{}},
+	}
+}
+
+// newRouter registers every route, wrapping each in the scope check it needs.
+func (app *App) newRouter() *httprouter.Router {
+	router := httprouter.New()
+	installErrorHandlers(router)
+	for _, rt := range app.routes() {
+		h := rt.Handle
+		if rt.Scope != "" {
+			h = app.requireScope(rt.Scope, h)
+		}
+		router.Handle(rt.Method, rt.Path, h)
+		if rt.Alias != "" {
+			router.Handle(rt.Method, rt.Alias, h)
+		}
+	}
+	return router
+}
+
+// pathParams lists the :name segments of an httprouter path.
+func pathParams(path string) []string {
+	var names []string
+	for _, seg := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(seg, ":"); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// handleOpenAPI serves the OpenAPI 3 document generated from the route table.
+func (app *App) handleOpenAPI(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, buildOpenAPI(app.routes()))
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxSampleGap is the longest gap between two status samples we will credit as on-time.
+// If the service was down (or nobody polled) for longer, we don't know what the relays did.
+const maxSampleGap = 10 * time.Minute
+
+// replaceSoonFraction of rated life triggers a "replace soon" warning.
+const replaceSoonFraction = 0.9
+
+// circuitRuntime is the accumulated on-time of one mapping or zone.
+type circuitRuntime struct {
+	OnSeconds   float64            `json:"on_seconds"`      // Lifetime total
+	LampSeconds float64            `json:"lamp_seconds"`    // Since the lamps were last replaced
+	Monthly     map[string]float64 `json:"monthly_seconds"` // "2025-11" -> seconds
+	RelampedAt  time.Time          `json:"relamped_at,omitempty"`
+	LastOn      bool               `json:"last_on"`
+	LastSample  time.Time          `json:"last_sample"`
+}
+
+// observe credits the interval since the previous sample if the circuit was on then.
+func (c *circuitRuntime) observe(now time.Time, on bool) {
+	if !c.LastSample.IsZero() && c.LastOn {
+		gap := now.Sub(c.LastSample)
+		if gap > 0 && gap <= maxSampleGap {
+			secs := gap.Seconds()
+			c.OnSeconds += secs
+			c.LampSeconds += secs
+			if c.Monthly == nil {
+				c.Monthly = make(map[string]float64)
+			}
+			c.Monthly[c.LastSample.Format("2006-01")] += secs
+		}
+	}
+	c.LastOn = on
+	c.LastSample = now
+}
+
+type runtimeData struct {
+	Mappings map[int]*circuitRuntime `json:"mappings"`
+	Zones    map[int]*circuitRuntime `json:"zones"`
+}
+
+// RuntimeTracker adds up relay on-time from the C101-C124 state bits and keeps
+// the totals in a JSON file so they survive restarts.
+type RuntimeTracker struct {
+	path  string
+	mu    sync.Mutex
+	data  runtimeData
+	saved time.Time
+}
+
+// NewRuntimeTracker loads previous totals from path (if any).
+func NewRuntimeTracker(path string) *RuntimeTracker {
+	rt := &RuntimeTracker{
+		path: path,
+		data: runtimeData{
+			Mappings: make(map[int]*circuitRuntime),
+			Zones:    make(map[int]*circuitRuntime),
+		},
+	}
+	if path == "" {
+		return rt
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			runtimeLog.Warn("could not read runtime file, starting from zero", "path", path, "err", err)
+		}
+		return rt
+	}
+	if err := json.Unmarshal(raw, &rt.data); err != nil {
+		runtimeLog.Warn("could not parse runtime file, starting from zero", "path", path, "err", err)
+		return rt
+	}
+	if rt.data.Mappings == nil {
+		rt.data.Mappings = make(map[int]*circuitRuntime)
+	}
+	if rt.data.Zones == nil {
+		rt.data.Zones = make(map[int]*circuitRuntime)
+	}
+	return rt
+}
+
+// Observe records one status sample. The status map is the one built by
+// ReadStatusFromPLCs (keys like "PLC1-Y101").
+func (rt *RuntimeTracker) Observe(now time.Time, configData *FullConfigurationData, status map[string]interface{}) {
+	if rt == nil || configData == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	zoneOn := make(map[int]bool)
+	zoneSeen := make(map[int]bool)
+	for _, mapping := range configData.Mappings {
+		if len(mapping.PLCOutputs) == 0 {
+			continue
+		}
+		value, ok := status[fmt.Sprintf("PLC%d-%s", mapping.PLCID, mapping.PLCOutputs[0])].(bool)
+		if !ok {
+			continue // PLC didn't answer; leave the previous sample alone
+		}
+		c := rt.data.Mappings[mapping.ID]
+		if c == nil {
+			c = &circuitRuntime{}
+			rt.data.Mappings[mapping.ID] = c
+		}
+		c.observe(now, value)
+
+		for _, zoneID := range mapping.LinkedZoneIDs {
+			zoneSeen[zoneID] = true
+			zoneOn[zoneID] = zoneOn[zoneID] || value
+		}
+	}
+	for zoneID := range zoneSeen {
+		c := rt.data.Zones[zoneID]
+		if c == nil {
+			c = &circuitRuntime{}
+			rt.data.Zones[zoneID] = c
+		}
+		c.observe(now, zoneOn[zoneID])
+	}
+
+	// The poller samples every minute; there's no need to hit the SD card that often.
+	if now.Sub(rt.saved) >= 5*time.Minute {
+		rt.saveLocked()
+		rt.saved = now
+	}
+}
+
+// Relamp resets the lamp-life counter for a mapping after its lamps are replaced.
+func (rt *RuntimeTracker) Relamp(mappingID int, now time.Time) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	c := rt.data.Mappings[mappingID]
+	if c == nil {
+		c = &circuitRuntime{}
+		rt.data.Mappings[mappingID] = c
+	}
+	c.LampSeconds = 0
+	c.RelampedAt = now
+	rt.saveLocked()
+}
+
+// Save writes the totals to disk immediately.
+func (rt *RuntimeTracker) Save() {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.saveLocked()
+}
+
+func (rt *RuntimeTracker) saveLocked() {
+	if rt.path == "" {
+		return
+	}
+	raw, err := json.MarshalIndent(rt.data, "", "  ")
+	if err != nil {
+		runtimeLog.Error("could not encode runtime totals", "err", err)
+		return
+	}
+	// Write to a temp file and rename so a power cut can't leave a half-written file.
+	tmp := rt.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		runtimeLog.Error("could not write runtime file", "path", tmp, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, rt.path); err != nil {
+		runtimeLog.Error("could not replace runtime file", "path", rt.path, "err", err)
+	}
+}
+
+// --- Report ---
+
+type MappingRuntimeReport struct {
+	MappingID      int                `json:"mapping_id"`
+	Description    string             `json:"description"`
+	PLCID          int                `json:"plc_id"`
+	Outputs        []string           `json:"plc_outputs"`
+	OnHours        float64            `json:"on_hours"`
+	LampHours      float64            `json:"lamp_hours"`
+	RelampedAt     *time.Time         `json:"relamped_at,omitempty"`
+	LampWattage    float64            `json:"lamp_wattage,omitempty"`
+	RatedLifeHours float64            `json:"rated_life_hours,omitempty"`
+	LifeUsedPct    float64            `json:"life_used_pct,omitempty"`
+	MonthlyKWh     map[string]float64 `json:"monthly_kwh,omitempty"`
+	ReplaceSoon    bool               `json:"replace_soon"`
+}
+
+type ZoneRuntimeReport struct {
+	ZoneID       int                `json:"zone_id"`
+	ZoneName     string             `json:"zone_name"`
+	OnHours      float64            `json:"on_hours"`
+	MonthlyHours map[string]float64 `json:"monthly_hours"`
+	MonthlyKWh   map[string]float64 `json:"monthly_kwh,omitempty"`
+}
+
+type RuntimeReport struct {
+	GeneratedAt time.Time              `json:"generated_at"`
+	Mappings    []MappingRuntimeReport `json:"mappings"`
+	Zones       []ZoneRuntimeReport    `json:"zones"`
+	Warnings    []string               `json:"warnings"`
+}
+
+// Report combines the accumulated totals with lamp specs from the WordPress config.
+func (rt *RuntimeTracker) Report(configData *FullConfigurationData) RuntimeReport {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	report := RuntimeReport{
+		GeneratedAt: time.Now(),
+		Mappings:    []MappingRuntimeReport{},
+		Zones:       []ZoneRuntimeReport{},
+		Warnings:    []string{},
+	}
+
+	// kWh per month for each zone is the sum of its member mappings.
+	zoneKWh := make(map[int]map[string]float64)
+
+	for _, mapping := range configData.Mappings {
+		m := MappingRuntimeReport{
+			MappingID:      mapping.ID,
+			Description:    mapping.Description,
+			PLCID:          mapping.PLCID,
+			Outputs:        mapping.PLCOutputs,
+			LampWattage:    mapping.LampWattage,
+			RatedLifeHours: mapping.RatedLifeHours,
+		}
+		if c := rt.data.Mappings[mapping.ID]; c != nil {
+			m.OnHours = c.OnSeconds / 3600
+			m.LampHours = c.LampSeconds / 3600
+			if !c.RelampedAt.IsZero() {
+				relamped := c.RelampedAt
+				m.RelampedAt = &relamped
+			}
+			if mapping.LampWattage > 0 {
+				m.MonthlyKWh = make(map[string]float64)
+				for month, secs := range c.Monthly {
+					kwh := mapping.LampWattage * secs / 3600 / 1000
+					m.MonthlyKWh[month] = kwh
+					for _, zoneID := range mapping.LinkedZoneIDs {
+						if zoneKWh[zoneID] == nil {
+							zoneKWh[zoneID] = make(map[string]float64)
+						}
+						zoneKWh[zoneID][month] += kwh
+					}
+				}
+			}
+		}
+		if mapping.RatedLifeHours > 0 {
+			m.LifeUsedPct = 100 * m.LampHours / mapping.RatedLifeHours
+			if m.LampHours >= replaceSoonFraction*mapping.RatedLifeHours {
+				m.ReplaceSoon = true
+				report.Warnings = append(report.Warnings, fmt.Sprintf(
+					"Mapping %d (%s) has %.0f of %.0f rated lamp hours; replace soon.",
+					mapping.ID, mapping.Description, m.LampHours, mapping.RatedLifeHours))
+			}
+		}
+		report.Mappings = append(report.Mappings, m)
+	}
+
+	for _, zone := range configData.Zones {
+		z := ZoneRuntimeReport{ZoneID: zone.ID, ZoneName: zone.ZoneName, MonthlyHours: map[string]float64{}}
+		if c := rt.data.Zones[zone.ID]; c != nil {
+			z.OnHours = c.OnSeconds / 3600
+			for month, secs := range c.Monthly {
+				z.MonthlyHours[month] = secs / 3600
+			}
+		}
+		z.MonthlyKWh = zoneKWh[zone.ID]
+		report.Zones = append(report.Zones, z)
+	}
+
+	sort.Slice(report.Mappings, func(i, j int) bool { return report.Mappings[i].MappingID < report.Mappings[j].MappingID })
+	sort.Slice(report.Zones, func(i, j int) bool { return report.Zones[i].ZoneID < report.Zones[j].ZoneID })
+	return report
+}
+
+// --- Background poller ---
+
+// startStatusPoller samples the relay states on a fixed interval so on-time is
+// counted even when nobody has the monitor page open.
+func (app *App) startStatusPoller(ctx context.Context) {
+	interval := time.Duration(app.config().StatusPollSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	runtimeLog.Info("starting background status poller", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	app.markPollProgress()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.pollStatusOnce()
+			app.markPollProgress()
+		}
+	}
+}
+
+// pollStatusOnce reads the current relay states and feeds the runtime tracker.
+func (app *App) pollStatusOnce() {
+	configData, err := FetchConfigurationFromAPI(app.config())
+	if err != nil {
+		runtimeLog.Warn("status poller could not fetch config", "err", err)
+		return
+	}
+
+	var status map[string]interface{}
+	if app.isSimulationMode() {
+		status, err = app.getSimulatedState()
+	} else {
+		done, werr := app.beginPLCWork()
+		if werr != nil {
+			return
+		}
+		defer done()
+		app.reassertPhotocell()
+		status, err = ReadStatusFromPLCs(app.sessions, configData)
+	}
+	if err != nil {
+		runtimeLog.Warn("status poller could not read status", "err", err)
+		return
+	}
+	app.observeStatus(configData, status)
+}
+
+// observeStatus feeds a live status reading to the runtime tracker and the
+// photocell monitors.
+func (app *App) observeStatus(configData *FullConfigurationData, status map[string]interface{}) {
+	now := time.Now()
+	cfg := app.config()
+	app.Runtime.Observe(now, configData, status)
+	if app.isSimulationMode() {
+		return
+	}
+	if dark, ok := status["Photocell"].(bool); ok && app.photocell.Current() == nil && len(app.photocell.Pending()) == 0 {
+		app.photocellMonitor.Observe(now, cfg, dark)
+	}
+	app.photocellLink.Observe(now, cfg, status)
+}
+
+// handleRuntimeReport returns on-hours, kWh per month and lamp-life warnings.
+func (app *App) handleRuntimeReport(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	configData, err := FetchConfigurationFromAPI(app.config())
+	if err != nil {
+		writeError(w, r, configUnavailable(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, app.Runtime.Report(configData))
+}
+
+// handleRelamp resets the lamp-hours counter for a mapping after a bulb/ballast change.
+func (app *App) handleRelamp(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	mappingID, err := parseID(ps, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	app.Runtime.Relamp(mappingID, time.Now())
+	runtimeLog.InfoContext(r.Context(), "lamp-life counter reset", "mapping", mappingID, "caller", callerFromRequest(r))
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "mapping_id": mappingID, "request_id": requestID(r.Context())})
+}
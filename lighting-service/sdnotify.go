@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state string ("READY=1", "STATUS=...", "WATCHDOG=1") to
+// systemd. It does nothing unless the service was started with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // Abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns systemd's WatchdogSec for this process, or 0 if the
+// watchdog is not enabled.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // Meant for another process
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notifyReady tells systemd the config is loaded and the listener is up.
+func (app *App) notifyReady() {
+	if err := sdNotify("READY=1\nSTATUS=" + app.plcStatusLine()); err != nil {
+		mainLog.Warn("could not notify systemd", "err", err)
+	}
+}
+
+// markPollProgress is called by the status poller each time round its loop.
+func (app *App) markPollProgress() {
+	app.pollProgress.Store(time.Now().UnixNano())
+}
+
+// pollStallLimit is how long the poll loop may go without progress before the
+// service counts as wedged. A pass can wait on WordPress and every PLC timeout.
+func (app *App) pollStallLimit() time.Duration {
+	interval := time.Duration(app.config().StatusPollSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return 2*interval + time.Minute
+}
+
+// runWatchdog pings systemd's watchdog at half its interval, but only while the
+// status poller is making progress. If a goroutine hangs holding a PLC session
+// or a mutex the poller stops, the pings stop, and systemd restarts the service.
+func (app *App) runWatchdog(ctx context.Context) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	mainLog.Info("systemd watchdog enabled", "interval", interval)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		since := time.Since(time.Unix(0, app.pollProgress.Load()))
+		if since > app.pollStallLimit() {
+			mainLog.Error("status poller has stalled, withholding watchdog ping", "since_progress", since.Round(time.Second))
+			sdNotify("STATUS=status poller stalled for " + since.Round(time.Second).String())
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1\nSTATUS=" + app.plcStatusLine()); err != nil {
+			mainLog.Warn("could not ping systemd watchdog", "err", err)
+		}
+	}
+}
+
+// plcStatusLine summarizes PLC connectivity for `systemctl status`.
+func (app *App) plcStatusLine() string {
+	if app.isSimulationMode() {
+		return "simulation mode (no PLC configured)"
+	}
+	var parts []string
+	for _, s := range app.sessions.All() {
+		parts = append(parts, fmt.Sprintf("PLC%d %s", s.ID, s.State()))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket listens where NOTIFY_SOCKET points, as systemd does.
+func fakeNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// nextNotify waits for a message starting with prefix, skipping others.
+func nextNotify(t *testing.T, conn *net.UnixConn, prefix string) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("no %q from the service: %v", prefix, err)
+		}
+		if msg := string(buf[:n]); strings.HasPrefix(msg, prefix) {
+			return msg
+		}
+	}
+}
+
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.StatusPollSeconds = 1
+	app := &App{
+		cfg:              cfg,
+		sessions:         NewPLCSessions(cfg.PLCs),
+		Runtime:          NewRuntimeTracker(filepath.Join(t.TempDir(), "runtime.json")),
+		photocell:        NewPhotocellOverride(filepath.Join(t.TempDir(), "photocell_force.json")),
+		photocellMonitor: NewPhotocellMonitor(),
+		photocellLink:    NewPhotocellLinkMonitor(),
+		clocks:           NewClockMonitor(),
+		drift:            NewDriftMonitor(),
+	}
+	app.hardStop, app.cancelHardStop = context.WithCancel(context.Background())
+	return app
+}
+
+func TestNotifyReadyAndStopping(t *testing.T) {
+	conn := fakeNotifySocket(t)
+	app := newTestApp(t)
+
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- app.serveUntil(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: app.newRouter()}) }()
+
+	if msg := nextNotify(t, conn, "READY=1"); !strings.Contains(msg, "STATUS=simulation mode") {
+		t.Errorf("READY message %q has no status line", msg)
+	}
+	stop()
+	nextNotify(t, conn, "STOPPING=1")
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serveUntil: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveUntil did not return after shutdown")
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	conn := fakeNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	app := newTestApp(t)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	app.markPollProgress()
+	go app.runWatchdog(ctx)
+	nextNotify(t, conn, "WATCHDOG=1")
+
+	// A poller that stopped longer ago than the stall limit gets no pings.
+	app.pollProgress.Store(time.Now().Add(-time.Hour).UnixNano())
+	nextNotify(t, conn, "STATUS=status poller stalled")
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break // Timed out: nothing but stall reports
+		}
+		if msg := string(buf[:n]); strings.HasPrefix(msg, "WATCHDOG=1") {
+			t.Fatalf("watchdog pinged while the poller was stalled: %q", msg)
+		}
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"junk", "", 0},
+		{"0", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", pid, 30 * time.Second},
+		{"30000000", "1", 0}, // Another process's watchdog
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := watchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: got %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestSdNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without NOTIFY_SOCKET: %v", err)
+	}
+}
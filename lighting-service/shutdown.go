@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// pushRollbackReserve is how long before the shutdown deadline a running push
+// is canceled, so it has time to roll back before the sessions close. It is at
+// most half of ShutdownTimeoutSeconds.
+const pushRollbackReserve = 10 * time.Second
+
+// errShuttingDown is returned to callers that try to start PLC work after SIGTERM.
+var errShuttingDown = errors.New("service is shutting down")
+
+// beginPLCWork registers a pulse, push or poll that talks to the PLCs so shutdown
+// can wait for it. It refuses new work once shutdown has started.
+func (app *App) beginPLCWork() (done func(), err error) {
+	app.shutdownMutex.Lock()
+	defer app.shutdownMutex.Unlock()
+	if app.shuttingDown {
+		return nil, errShuttingDown
+	}
+	app.inflight.Add(1)
+	return app.inflight.Done, nil
+}
+
+// beginPush is beginPLCWork for a config push, which shutdown also waits for
+// past the deadline: a canceled push is still rolling back. It waits for any
+// push or drift repair in progress, since one that wrote between another's
+// snapshot and verify would make it roll back over the newer image.
+func (app *App) beginPush() (done func(), err error) {
+	app.pushMutex.Lock()
+	return app.startPush()
+}
+
+// tryBeginPush is beginPush that gives up, returning ok false, if a push is
+// in progress.
+func (app *App) tryBeginPush() (done func(), ok bool, err error) {
+	if !app.pushMutex.TryLock() {
+		return nil, false, nil
+	}
+	done, err = app.startPush()
+	return done, err == nil, err
+}
+
+// startPush registers a push once the caller holds pushMutex.
+func (app *App) startPush() (done func(), err error) {
+	workDone, err := app.beginPLCWork()
+	if err != nil {
+		app.pushMutex.Unlock()
+		return nil, err
+	}
+	app.pushes.Add(1)
+	return func() {
+		app.pushes.Done()
+		workDone()
+		app.pushMutex.Unlock()
+	}, nil
+}
+
+// drainPLCWork stops new PLC work and waits (up to the deadline) for the work in
+// progress. A config push is never abandoned halfway through a PLC's image.
+func (app *App) drainPLCWork(deadline time.Time) bool {
+	app.shutdownMutex.Lock()
+	app.shuttingDown = true
+	app.shutdownMutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		app.inflight.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// serveUntil runs the HTTP server until ctx is canceled, then shuts down in order:
+// stop accepting requests, let in-flight requests and PLC work finish, close the
+// Modbus sessions and save the runtime totals.
+func (app *App) serveUntil(ctx context.Context, server *http.Server) error {
+	// Listen first so READY=1 is only sent once connections can be accepted.
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err // Could not start (port in use, etc.)
+	}
+	if server.TLSConfig != nil {
+		ln = tls.NewListener(ln, server.TLSConfig)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(ln) }()
+	app.notifyReady()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := time.Duration(app.config().ShutdownTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 20 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	mainLog.Info("shutdown requested, draining requests and PLC work", "timeout", timeout)
+	sdNotify("STOPPING=1\nSTATUS=draining requests and PLC work")
+
+	// Cancel pushes that are still running close to the deadline, so they
+	// roll back before it.
+	reserve := min(pushRollbackReserve, timeout/2)
+	stopTimer := time.AfterFunc(timeout-reserve, app.cancelHardStop)
+	defer stopTimer.Stop()
+
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		mainLog.Warn("HTTP requests still running at shutdown deadline", "err", err)
+	}
+	if !app.drainPLCWork(deadline) {
+		mainLog.Warn("PLC work still running at shutdown deadline")
+	}
+	// Closing the sessions under a push rolling back would leave a PLC half
+	// written, which is what the rollback is there to prevent.
+	app.pushes.Wait()
+
+	// Closing a session waits for its current request, so a PLC image that is
+	// being written is always finished.
+	app.sessions.CloseAll()
+	app.Runtime.Save()
+	mainLog.Info("shutdown complete")
+	return nil
+}
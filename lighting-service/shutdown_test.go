@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainPLCWork(t *testing.T) {
+	app := newTestApp(t)
+	done, err := app.beginPLCWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drained := make(chan bool, 1)
+	go func() { drained <- app.drainPLCWork(time.Now().Add(5 * time.Second)) }()
+	// New work is refused as soon as the drain starts, while the old work runs.
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := app.beginPLCWork()
+		if errors.Is(err, errShuttingDown) {
+			break
+		}
+		if err != nil || time.Now().After(deadline) {
+			t.Fatalf("beginPLCWork during drain: %v", err)
+		}
+		app.inflight.Done() // It was accepted; give it back and try again
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := app.beginPush(); !errors.Is(err, errShuttingDown) {
+		t.Errorf("beginPush during drain: %v", err)
+	}
+	if !app.pushMutex.TryLock() {
+		t.Error("refused beginPush kept the push lock")
+	}
+	app.pushMutex.Unlock()
+
+	// A command sent now gets a 503 without touching anything.
+	app.cfg.AuthControlMode = "none"
+	app.cfg.PLCs = map[int]PLCConfig{1: {Address: "127.0.0.1:1"}} // Never dialed
+	rec := httptest.NewRecorder()
+	app.newRouter().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/sync", nil))
+	var resp commandResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusServiceUnavailable || resp.Error == nil || resp.Error.Code != codeShuttingDown {
+		t.Errorf("sync during drain: %d %s", rec.Code, rec.Body)
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("drain returned with work still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	select {
+	case ok := <-drained:
+		if !ok {
+			t.Error("drain reported work still running after it finished")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not return after the work finished")
+	}
+}
+
+func TestDrainPLCWorkDeadline(t *testing.T) {
+	app := newTestApp(t)
+	done, err := app.beginPLCWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	start := time.Now()
+	if app.drainPLCWork(start.Add(50 * time.Millisecond)) {
+		t.Error("drain reported done with work still running")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("drain waited %s past a 50ms deadline", waited)
+	}
+}
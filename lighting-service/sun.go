@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// sunTimes returns sunrise and sunset on the given day (in day's location) at
+// latitude/longitude in degrees (east positive), using the sunrise equation
+// with the usual -0.833° for refraction and the sun's radius. It is good to a
+// minute or two, which is plenty for checking a photocell. ok is false on days
+// with no sunrise or sunset (polar day or night).
+func sunTimes(day time.Time, lat, lon float64) (rise, set time.Time, ok bool) {
+	const rad = math.Pi / 180
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	julianDay := float64(noon.Unix())/86400 + 2440587.5
+
+	n := math.Round(julianDay - 2451545.0 + 0.0008)
+	meanSolarNoon := n - lon/360
+	m := math.Mod(357.5291+0.98560028*meanSolarNoon, 360)
+	center := 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	lambda := math.Mod(m+center+180+102.9372, 360)
+	transit := 2451545.0 + meanSolarNoon + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+
+	sinDecl := math.Sin(lambda*rad) * math.Sin(23.4397*rad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosHour := (math.Sin(-0.833*rad) - math.Sin(lat*rad)*sinDecl) / (math.Cos(lat*rad) * cosDecl)
+	if cosHour < -1 || cosHour > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHour) / rad
+
+	fromJulian := func(j float64) time.Time {
+		return time.Unix(int64(math.Round((j-2440587.5)*86400)), 0).In(day.Location())
+	}
+	return fromJulian(transit - hourAngle/360), fromJulian(transit + hourAngle/360), true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunTimes(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Expected times are from the NOAA solar calculator, in local time.
+	tests := []struct {
+		name      string
+		day       time.Time
+		lat, lon  float64
+		rise, set string
+		ok        bool
+	}{
+		{"Los Angeles, winter", time.Date(2024, 12, 21, 0, 0, 0, 0, pacific), 34.05, -118.24, "06:55", "16:47", true},
+		{"Los Angeles, summer", time.Date(2024, 6, 21, 0, 0, 0, 0, pacific), 34.05, -118.24, "05:42", "20:08", true},
+		{"Greenwich, summer", time.Date(2024, 6, 21, 0, 0, 0, 0, london), 51.48, 0, "04:43", "21:21", true},
+		{"equator, equinox", time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC), 0, 0, "06:04", "18:11", true},
+		{"Tromsø, midnight sun", time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96, "", "", false},
+		{"Tromsø, polar night", time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96, "", "", false},
+	}
+	near := func(got time.Time, day time.Time, want string) bool {
+		w, err := time.ParseInLocation("15:04", want, day.Location())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = time.Date(day.Year(), day.Month(), day.Day(), w.Hour(), w.Minute(), 0, 0, day.Location())
+		return got.Location() == day.Location() && got.Sub(w).Abs() <= 2*time.Minute
+	}
+	for _, tt := range tests {
+		rise, set, ok := sunTimes(tt.day, tt.lat, tt.lon)
+		if ok != tt.ok {
+			t.Errorf("%s: ok %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !near(rise, tt.day, tt.rise) || !near(set, tt.day, tt.set) {
+			t.Errorf("%s: got %s-%s, want %s-%s within 2 minutes", tt.name, rise.Format("15:04 MST"), set.Format("15:04 MST"), tt.rise, tt.set)
+		}
+	}
+}